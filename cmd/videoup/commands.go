@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"videoup/internal/app"
+	"videoup/internal/cluster"
+	"videoup/internal/errs"
+	"videoup/internal/ffmpeg"
+	"videoup/internal/server"
+	"videoup/internal/ui"
+	"videoup/internal/upscaler"
+	"videoup/internal/watch"
+)
+
+// runCommand runs a subcommand such as "videoup compare"
+func runCommand(name string, args []string) error {
+	switch name {
+	case "compare":
+		return runCompare(args)
+	case "bakeoff":
+		return runBakeoff(args)
+	case "doctor":
+		return runDoctor(args)
+	case "serve":
+		return runServe(args)
+	case "watch":
+		return runWatch(args)
+	case "coordinate":
+		return runCoordinate(args)
+	case "worker":
+		return runWorker(args)
+	case "scenes":
+		return runScenes(args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
+}
+
+// shutsDownOnSignal reports whether a command runs until it is stopped and
+// shuts down cleanly on SIGINT or SIGTERM, see signalContext
+func shutsDownOnSignal(name string) bool {
+	switch name {
+	case "serve", "watch", "coordinate", "worker":
+		return true
+	}
+	return false
+}
+
+// signalContext returns a context that is cancelled on SIGINT or SIGTERM
+func signalContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// runCompare measures the quality of an upscaled video against its source
+// and writes the report next to the upscaled video
+func runCompare(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: videoup compare [-start ... -end ... -splice] [-hdr ...] <source> <upscaled>")
+		fs.PrintDefaults()
+	}
+	options, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("expected a source and an upscaled video")
+	}
+
+	if err := app.CheckFFmpeg(); err != nil {
+		return err
+	}
+
+	source := resolvePath(fs.Arg(0))
+	upscaled := resolvePath(fs.Arg(1))
+
+	report, reportPath, err := app.MeasureQuality(source, upscaled, options.FFmpeg)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(ui.FormatInfo(app.FormatQualitySummary(report)))
+	fmt.Println(ui.FormatSuccess(fmt.Sprintf("Report written to %s and %s",
+		reportPath, strings.TrimSuffix(reportPath, ".json")+".csv")))
+	return nil
+}
+
+// runBakeoff runs a clip through several models and scales and prints a
+// summary table
+func runBakeoff(args []string) error {
+	fs := flag.NewFlagSet("bakeoff", flag.ContinueOnError)
+	models := fs.String("models", "", "Comma separated models to compare (default: all installed)")
+	scales := fs.String("scales", "", "Comma separated scales to compare (default: every native scale of each model)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: videoup bakeoff [-start ... -end ...] [-models ...] [-scales ...] <video>")
+		fs.PrintDefaults()
+	}
+	options, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected a video")
+	}
+
+	if err := app.CheckDependencies(); err != nil {
+		return err
+	}
+
+	candidates, err := bakeoffCandidates(*models, *scales)
+	if err != nil {
+		return err
+	}
+
+	report, bakeoffDir, err := app.RunBakeoff(resolvePath(fs.Arg(0)), options, candidates)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	app.WriteBakeoffTable(os.Stdout, report)
+	fmt.Println()
+	if report.GridPath != "" {
+		fmt.Println(ui.FormatInfo(fmt.Sprintf("Grid comparison: %s", report.GridPath)))
+	}
+	fmt.Println(ui.FormatSuccess(fmt.Sprintf("Bake-off results written to %s", bakeoffDir)))
+	return nil
+}
+
+// bakeoffCandidates selects the installed model/scale pairs matching the
+// -models and -scales filters
+func bakeoffCandidates(models, scales string) ([]upscaler.ModelScale, error) {
+	wantModel := map[string]bool{}
+	for _, model := range strings.Split(models, ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			wantModel[model] = true
+		}
+	}
+	wantScale := map[int]bool{}
+	for _, scale := range strings.Split(scales, ",") {
+		if scale = strings.TrimSpace(scale); scale != "" {
+			n, err := strconv.Atoi(scale)
+			if err != nil {
+				return nil, fmt.Errorf("invalid scale %q", scale)
+			}
+			wantScale[n] = true
+		}
+	}
+
+	var candidates []upscaler.ModelScale
+	for _, candidate := range upscaler.InstalledModelScales() {
+		if len(wantModel) > 0 && !wantModel[candidate.Model] {
+			continue
+		}
+		if len(wantScale) > 0 && !wantScale[candidate.Scale] {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no installed model matches the selected models and scales")
+	}
+	return candidates, nil
+}
+
+// resolvePath resolves a path given on the command line against the
+// directory videoup was started from, as the launcher changes directory
+func resolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	if originalDir := os.Getenv("VIDEOUP_ORIGINAL_DIR"); originalDir != "" {
+		return filepath.Join(originalDir, path)
+	}
+	return path
+}
+
+// runDoctor checks the dependencies and prints what to fix
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: videoup doctor [-gpus 0,1]")
+		fs.PrintDefaults()
+	}
+	options, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, check := range app.RunDoctor(options) {
+		line := fmt.Sprintf("%-20s %s", check.Name, check.Detail)
+		switch check.Status {
+		case app.CheckOK:
+			fmt.Println(ui.FormatSuccess("ok    " + line))
+		case app.CheckWarn:
+			fmt.Println(ui.FormatInfo("warn  " + line))
+		default:
+			failed++
+			fmt.Println(ui.FormatError("FAIL  " + line))
+		}
+		if check.Fix != "" {
+			fmt.Println("      fix: " + check.Fix)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	fmt.Println(ui.FormatSuccess("Everything videoup needs is working"))
+	return nil
+}
+
+// runServe runs the HTTP API for submitting and monitoring jobs
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:8089", "Address to listen on")
+	jobs := fs.String("jobs", defaultJobsPath(), "File the jobs are saved to, so they survive a restart")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: videoup serve [-addr host:port] [-jobs jobs.json]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := app.CheckDependencies(); err != nil {
+		return err
+	}
+
+	srv, err := server.New(server.Options{
+		Addr:         *addr,
+		JobsPath:     resolvePath(*jobs),
+		ParseOptions: parseJobOptions,
+	})
+	if err != nil {
+		return err
+	}
+	ctx, stop := signalContext()
+	defer stop()
+	return srv.Run(ctx)
+}
+
+// parseJobOptions parses the options of a job submitted to the server, given
+// as flag names without the dash
+func parseJobOptions(flags map[string]string) (app.Options, error) {
+	var args []string
+	for name, value := range flags {
+		// Jobs use the server's config file, they can't read other files
+		if name == "config" {
+			return app.Options{}, fmt.Errorf("config is not a job option")
+		}
+		args = append(args, "-"+name+"="+value)
+	}
+	fs := flag.NewFlagSet("job", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	options, err := parseFlags(fs, args)
+	if err != nil {
+		return options, err
+	}
+	if fs.NArg() > 0 {
+		return options, fmt.Errorf("unexpected arguments %v", fs.Args())
+	}
+	return options, nil
+}
+
+// defaultJobsPath returns where the server saves its jobs by default
+func defaultJobsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "videoup_jobs.json"
+	}
+	return filepath.Join(dir, "videoup", "jobs.json")
+}
+
+// defaultConfigPath returns where the config file is read from by default
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "videoup_config.json"
+	}
+	return filepath.Join(dir, "videoup", "config.json")
+}
+
+// configArg returns the config file given with -config, or the default one.
+// It is needed before the other flags are parsed, as it holds their defaults
+func configArg(args []string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "config" {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			value = args[i+1]
+		}
+		return resolvePath(value), true
+	}
+	return defaultConfigPath(), false
+}
+
+// runWatch upscales every video dropped into a directory with the options
+// given as flags
+func runWatch(args []string) error {
+	watchOptions := watch.DefaultOptions()
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.DurationVar(&watchOptions.Interval, "interval", watchOptions.Interval, "How often the directory is scanned")
+	fs.DurationVar(&watchOptions.Settle, "settle", watchOptions.Settle, "How long a file must stop growing before it is upscaled")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: videoup watch [-interval 5s] [-settle 10s] [-output-dir ...] [upscale options] <dir>")
+		fs.PrintDefaults()
+	}
+	options, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected a directory to watch")
+	}
+	if watchOptions.Interval <= 0 || watchOptions.Settle < 0 {
+		return fmt.Errorf("invalid interval %s or settle time %s", watchOptions.Interval, watchOptions.Settle)
+	}
+
+	if err := app.CheckDependencies(); err != nil {
+		return err
+	}
+
+	watchOptions.Dir = resolvePath(fs.Arg(0))
+	watchOptions.Job = options
+	ctx, stop := signalContext()
+	defer stop()
+	return watch.Watch(ctx, watchOptions)
+}
+
+// runCoordinate upscales a video with the frames spread over workers on
+// other machines
+func runCoordinate(args []string) error {
+	coordinatorOptions := cluster.DefaultCoordinatorOptions()
+	fs := flag.NewFlagSet("coordinate", flag.ContinueOnError)
+	fs.StringVar(&coordinatorOptions.Addr, "addr", coordinatorOptions.Addr, "Address the workers connect to")
+	fs.IntVar(&coordinatorOptions.ChunkFrames, "chunk", coordinatorOptions.ChunkFrames, "Frames handed to a worker at once")
+	fs.DurationVar(&coordinatorOptions.Lease, "lease", coordinatorOptions.Lease, "How long a silent worker keeps its chunk before it is reassigned")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: videoup coordinate [-addr :8090] [-chunk 100] [-lease 2m] [upscale options] <video>")
+		fs.PrintDefaults()
+	}
+	options, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected a video")
+	}
+	if coordinatorOptions.ChunkFrames <= 0 || coordinatorOptions.Lease <= 0 {
+		return fmt.Errorf("invalid chunk size %d or lease %s", coordinatorOptions.ChunkFrames, coordinatorOptions.Lease)
+	}
+
+	// The workers run realesrgan, this machine only needs ffmpeg
+	if err := app.CheckFFmpeg(); err != nil {
+		return err
+	}
+
+	coordinatorOptions.Job = options
+	ctx, stop := signalContext()
+	defer stop()
+	result, err := cluster.Coordinate(ctx, resolvePath(fs.Arg(0)), coordinatorOptions, func(stage string) {
+		fmt.Println(ui.FormatInfo(stage + "..."))
+	})
+	if err != nil {
+		return err
+	}
+	if result.Skipped {
+		fmt.Println(ui.FormatInfo(fmt.Sprintf("The output already exists: %s", result.Output)))
+		return nil
+	}
+	fmt.Println(ui.FormatSuccess(fmt.Sprintf("Upscaled video written to %s", result.Output)))
+	return nil
+}
+
+// runWorker upscales frames for a coordinator until it has no more work
+func runWorker(args []string) error {
+	workerOptions := cluster.DefaultWorkerOptions()
+	fs := flag.NewFlagSet("worker", flag.ContinueOnError)
+	fs.StringVar(&workerOptions.Name, "name", workerOptions.Name, "Name shown in the coordinator's log")
+	fs.DurationVar(&workerOptions.Poll, "poll", workerOptions.Poll, "How often to ask for work when every chunk is taken")
+	batchSize := fs.Int("batch-size", workerOptions.Upscaler.BatchSize, "Frames processed in parallel across all GPUs")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: videoup worker [-name ...] [-gpus 0,1] [-tile-size ...] [-threads ...] [-batch-size ...] <coordinator URL>")
+		fs.PrintDefaults()
+	}
+	options, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected the coordinator URL, e.g. http://render1:8090")
+	}
+	if *batchSize <= 0 {
+		return fmt.Errorf("invalid batch size %d", *batchSize)
+	}
+
+	if _, err := upscaler.RealesrganPath(); err != nil {
+		return err
+	}
+
+	workerOptions.Coordinator = fs.Arg(0)
+	workerOptions.Upscaler = options.Upscaler
+	workerOptions.Upscaler.BatchSize = *batchSize
+	ctx, stop := signalContext()
+	defer stop()
+	if err := cluster.Work(ctx, workerOptions); ctx.Err() == nil {
+		return err
+	}
+	// A stopped worker's chunk goes to another worker once its lease expires
+	return errs.ErrCancelled
+}
+
+// runScenes detects the scenes of a video and writes them to a scene file,
+// which can be edited to give scenes their own model or scale
+func runScenes(args []string) error {
+	fs := flag.NewFlagSet("scenes", flag.ContinueOnError)
+	out := fs.String("out", "", "Scene file to write (default: <video>_scenes.json next to the video)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: videoup scenes [-scene-threshold 0.3] [-start ... -end ...] [-out scenes.json] <video>")
+		fs.PrintDefaults()
+	}
+	options, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected a video")
+	}
+	if options.Scenes.Threshold == 0 {
+		options.Scenes.Threshold = app.DefaultSceneThreshold
+	}
+
+	if err := app.CheckFFmpeg(); err != nil {
+		return err
+	}
+
+	videoPath := resolvePath(fs.Arg(0))
+	scenesPath := app.ScenesPath(videoPath)
+	if *out != "" {
+		scenesPath = resolvePath(*out)
+	}
+	// The file may hold overrides someone spent time on
+	if _, err := os.Stat(scenesPath); err == nil {
+		return fmt.Errorf("%s already exists, remove it or pass -out", scenesPath)
+	}
+
+	info, err := ffmpeg.GetVideoInfo(videoPath)
+	if err != nil {
+		return err
+	}
+	scenes, err := app.DetectScenes(videoPath, info, options.Scenes.Threshold, options.FFmpeg)
+	if err != nil {
+		return err
+	}
+
+	list := &app.SceneList{
+		Video:     videoPath,
+		FrameRate: info.FrameRate,
+		Threshold: options.Scenes.Threshold,
+		Scenes:    scenes,
+	}
+	if err := app.WriteScenes(scenesPath, list); err != nil {
+		return err
+	}
+
+	for i, scene := range scenes {
+		fmt.Printf("%4d  %s  frames %d-%d (%d)\n", i+1, scene.Time, scene.Start, scene.End-1, scene.Frames())
+	}
+	fmt.Println(ui.FormatSuccess(fmt.Sprintf("%d scene(s) written to %s", len(scenes), scenesPath)))
+	fmt.Println(ui.FormatInfo("Add \"model\" or \"scale\" to a scene and pass the file with -scene-file"))
+	return nil
+}
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
-	"runtime"
+	"path/filepath"
+	"strings"
 	"syscall"
 
 	"videoup/internal/app"
 	"videoup/internal/cleanup"
+	"videoup/internal/errs"
+	"videoup/internal/ffmpeg"
 	"videoup/internal/ui"
+	"videoup/internal/upscaler"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -23,19 +28,211 @@ func main() {
 	// Always clean up on exit
 	defer cleanup.CleanupAll()
 
-	// Set up signal handling for graceful shutdown
-	setupSignalHandling(ctx, cancel)
+	// Set up signal handling for graceful shutdown. The UI and commands that
+	// run until stopped shut down on their own when signalled
+	isCommand := len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-")
+	if isCommand && !shutsDownOnSignal(os.Args[1]) {
+		setupSignalHandling(ctx, cancel)
+	}
 
 	// Defer cleanup in case of panic
 	defer handlePanic()
 
+	// Run a subcommand if one was given
+	if isCommand {
+		if err := runCommand(os.Args[1], os.Args[2:]); err != nil {
+			printError(err)
+			cleanup.CleanupAll()
+			os.Exit(errs.ExitCode(err))
+		}
+		return
+	}
+
+	// Parse command line options
+	options, err := parseFlags(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Println(ui.FormatError(fmt.Sprintf("Error: %v", err)))
+		os.Exit(errs.ExitUsage)
+	}
+
 	// Check dependencies
 	if err := checkDependencies(); err != nil {
-		os.Exit(1)
+		os.Exit(errs.ExitCode(err))
 	}
 
 	// Run the application
-	runApplication()
+	runApplication(options)
+}
+
+// parseFlags builds the job options from command line flags, with the
+// defaults taken from the config file
+func parseFlags(fs *flag.FlagSet, args []string) (app.Options, error) {
+	options := app.DefaultOptions()
+
+	// The config file holds job options, not those of the command itself
+	commandFlags := map[string]bool{}
+	fs.VisitAll(func(f *flag.Flag) { commandFlags[f.Name] = true })
+
+	configFile, explicit := configArg(args)
+	fs.String("config", configFile, "JSON file with default values for these flags, e.g. {\"tile-size\": 256}")
+
+	hdr := fs.String("hdr", string(options.FFmpeg.HDR), "HDR handling: preserve (keep PQ/HLG) or tonemap (convert to SDR)")
+	container := fs.String("container", string(options.FFmpeg.Container), "Output container: mov or mkv (mkv keeps ASS subtitles and font attachments)")
+	fs.StringVar(&options.FFmpeg.Start, "start", "", "Start of the range to upscale: frame number, seconds (60.5s) or timecode (HH:MM:SS.mmm)")
+	fs.StringVar(&options.FFmpeg.End, "end", "", "End of the range to upscale: frame number, seconds (60.5s) or timecode (HH:MM:SS.mmm)")
+	fs.StringVar(&options.Upscaler.Model, "model", options.Upscaler.Model, "realesrgan model, or auto to pick the anime or the general model from sampled frames")
+	fs.IntVar(&options.Upscaler.Scale, "scale", options.Upscaler.Scale, "Upscale factor; above the model's native scale it is done in several passes (e.g. 8 = 4x then 2x)")
+	intermediateModels := fs.String("intermediate-models", "", "Comma separated models for the passes after the first (default: the same model)")
+	fs.IntVar(&options.Upscaler.TileSize, "tile-size", options.Upscaler.TileSize, "realesrgan tile size, lower uses less GPU memory (0 for auto, lowered automatically when out of memory)")
+	fs.StringVar(&options.Upscaler.Threads, "threads", options.Upscaler.Threads, "realesrgan thread counts as load:proc:save, e.g. 1:2:2 (default: realesrgan's own)")
+	gpus := fs.String("gpus", upscaler.FormatGPUs(options.Upscaler.GPUs), "Comma separated GPU IDs to spread frames over, each with an optional weight (e.g. 0:2,1); -1 for CPU")
+	target := fs.String("target", "", "Output resolution instead of a fixed scale: WIDTHxHEIGHT, 4k, uhd, 1440p, 1080p...")
+	targetMode := fs.String("target-mode", string(options.FFmpeg.TargetMode), "How the video is fitted into the target: fit (pad) or fill (crop)")
+	fs.StringVar(&options.FFmpeg.TargetFilter, "target-filter", options.FFmpeg.TargetFilter, "Scaler used to resample to the target: lanczos, bicubic, spline...")
+	fs.StringVar(&options.FFmpeg.PadColor, "pad-color", options.FFmpeg.PadColor, "Color of the padding added by -target-mode fit")
+	outputDir := fs.String("output-dir", "", "Directory to write the output to (default: next to the source)")
+	fs.StringVar(&options.Output.Template, "output-name", app.DefaultTemplate, "Output file name; fields: {name} {model} {scale} {width} {height} {ext} {date}")
+	overwrite := fs.String("overwrite", string(options.Output.Overwrite), "When the output exists: skip, overwrite or increment (write <name>_upscaled_2...)")
+	fs.BoolVar(&options.FFmpeg.Metrics, "metrics", false, "Measure PSNR/SSIM (and VMAF when available) against the original after encoding")
+	fs.BoolVar(&options.FFmpeg.Splice, "splice", false, "Put the upscaled range back into a full-length output, scaling the rest conventionally")
+	preFilter := fs.String("prefilter", "", "Comma separated pre-filters applied at extraction: a preset ("+strings.Join(ffmpeg.PreFilterPresets(), ", ")+"), yadif, bwdif, hqdn3d[:light|medium|strong], nlmeans[:...], deblock, crop=W:H:X:Y")
+	previewAt := fs.String("preview-at", "", "Comma separated points to preview with Ctrl+P (default 25%, 50% and 75% of the video)")
+	fs.IntVar(&options.Preview.Frames, "preview-frames", 0, "Frames rendered at each preview point (0 for two seconds)")
+	fs.Float64Var(&options.Scenes.Threshold, "scene-threshold", 0, "Split the video into scenes where the scene change score (0-1) exceeds this, e.g. 0.3 (0 to not split)")
+	sceneFile := fs.String("scene-file", "", "Scene file with a model and scale per scene, as written by videoup scenes")
+	compare := fs.String("compare", "", "Render a comparison against the original: sbs, split or wipe")
+	fs.StringVar(&options.FFmpeg.CompareFilter, "compare-filter", options.FFmpeg.CompareFilter, "Filter used to scale the original for the comparison: neighbor or bicubic")
+
+	// Config values replace the defaults, flags given on the command line
+	// still win
+	config, err := app.LoadConfig(configFile, !explicit)
+	if err != nil {
+		return options, err
+	}
+	for name, value := range config {
+		f := fs.Lookup(name)
+		if f == nil || commandFlags[name] || name == "config" {
+			return options, fmt.Errorf("unknown option %q in config file %s", name, configFile)
+		}
+		if err := f.Value.Set(value); err != nil {
+			return options, fmt.Errorf("invalid %s %q in config file %s: %w", name, value, configFile, err)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return options, err
+	}
+
+	for _, timestamp := range strings.Split(*previewAt, ",") {
+		if timestamp = strings.TrimSpace(timestamp); timestamp != "" {
+			options.Preview.Timestamps = append(options.Preview.Timestamps, timestamp)
+		}
+	}
+
+	for _, model := range strings.Split(*intermediateModels, ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			options.Upscaler.IntermediateModels = append(options.Upscaler.IntermediateModels, model)
+		}
+	}
+
+	if options.Upscaler.TileSize < 0 {
+		return options, fmt.Errorf("invalid tile size %d", options.Upscaler.TileSize)
+	}
+	if err := upscaler.ValidateThreads(options.Upscaler.Threads); err != nil {
+		return options, err
+	}
+	devices, err := upscaler.ParseGPUs(*gpus)
+	if err != nil {
+		return options, err
+	}
+	options.Upscaler.GPUs = devices
+
+	if options.Scenes.Threshold < 0 || options.Scenes.Threshold >= 1 {
+		return options, fmt.Errorf("invalid scene threshold %g (expected between 0 and 1)", options.Scenes.Threshold)
+	}
+	if *sceneFile != "" {
+		options.Scenes.File = resolvePath(*sceneFile)
+	}
+
+	if options.FFmpeg.PreFilter, err = ffmpeg.ParsePreFilter(*preFilter); err != nil {
+		return options, err
+	}
+
+	// A relative -output-dir is relative to where videoup was started, one
+	// from the config file to each source, e.g. "upscaled" for a subfolder
+	if *outputDir != "" {
+		options.Output.Dir = *outputDir
+		if onCommandLine(fs, "output-dir") {
+			if options.Output.Dir, err = filepath.Abs(resolvePath(*outputDir)); err != nil {
+				return options, fmt.Errorf("failed to resolve output directory: %w", err)
+			}
+		}
+	}
+	if options.Output.Overwrite, err = app.ParseOverwritePolicy(*overwrite); err != nil {
+		return options, err
+	}
+
+	switch mode := ffmpeg.HDRMode(*hdr); mode {
+	case ffmpeg.HDRPreserve, ffmpeg.HDRToneMap:
+		options.FFmpeg.HDR = mode
+	default:
+		return options, fmt.Errorf("invalid HDR mode %q (expected preserve or tonemap)", *hdr)
+	}
+
+	switch c := ffmpeg.Container(*container); c {
+	case ffmpeg.ContainerMOV, ffmpeg.ContainerMKV:
+		options.FFmpeg.Container = c
+	default:
+		return options, fmt.Errorf("invalid container %q (expected mov or mkv)", *container)
+	}
+
+	switch layout := ffmpeg.CompareLayout(*compare); layout {
+	case ffmpeg.CompareNone, ffmpeg.CompareSideBySide, ffmpeg.CompareSplit, ffmpeg.CompareWipe:
+		options.FFmpeg.Compare = layout
+	default:
+		return options, fmt.Errorf("invalid comparison layout %q (expected sbs, split or wipe)", *compare)
+	}
+
+	if *target != "" {
+		width, height, err := ffmpeg.ParseResolution(*target)
+		if err != nil {
+			return options, err
+		}
+		options.FFmpeg.TargetWidth, options.FFmpeg.TargetHeight = width, height
+	}
+	switch mode := ffmpeg.TargetMode(*targetMode); mode {
+	case ffmpeg.TargetFit, ffmpeg.TargetFill:
+		options.FFmpeg.TargetMode = mode
+	default:
+		return options, fmt.Errorf("invalid target mode %q (expected fit or fill)", *targetMode)
+	}
+
+	switch options.FFmpeg.CompareFilter {
+	case "neighbor", "bicubic":
+	default:
+		return options, fmt.Errorf("invalid comparison filter %q (expected neighbor or bicubic)", options.FFmpeg.CompareFilter)
+	}
+
+	// With a target the scale is picked once the video is known
+	if !options.FFmpeg.HasTarget() {
+		if _, err := upscaler.PlanPasses(options.Upscaler); err != nil {
+			return options, err
+		}
+	}
+
+	return options, nil
+}
+
+// onCommandLine reports whether a flag was given on the command line rather
+// than taken from the config file
+func onCommandLine(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
 }
 
 // setupSignalHandling sets up handlers for termination signals
@@ -47,10 +244,10 @@ func setupSignalHandling(ctx context.Context, cancel context.CancelFunc) {
 		select {
 		case <-signalCh:
 			fmt.Println(ui.FormatInfo("\nReceived termination signal. Exiting..."))
-			// No need to explicitly call cleanup.CleanupAll() here
-			// as it will be called by the defer at the top of main()
+			// os.Exit skips the deferred cleanup at the top of main()
 			cancel()
-			os.Exit(1)
+			cleanup.CleanupAll()
+			os.Exit(errs.ExitCode(errs.ErrCancelled))
 		case <-ctx.Done():
 			return
 		}
@@ -70,50 +267,47 @@ func handlePanic() {
 func checkDependencies() error {
 	err := app.CheckDependencies()
 	if err != nil {
-		// Get OS-specific directory name for realesrgan
-		var dirName string
-		switch runtime.GOOS {
-		case "windows":
-			dirName = "realesrgan_win"
-		case "darwin": // macOS
-			dirName = "realesrgan_mac"
-		case "linux":
-			dirName = "realesrgan_linux"
-		default:
-			dirName = "realesrgan directory"
-		}
-
-		// Get OS-specific executable name
-		exeName := "realesrgan-ncnn-vulkan"
-		if runtime.GOOS == "windows" {
-			exeName += ".exe"
-		}
-
-		fmt.Println(ui.FormatError(fmt.Sprintf("Error: %v", err)))
-
-		if err.Error() == "ffmpeg is not installed or not in PATH" {
-			fmt.Println(ui.FormatInfo("Please install ffmpeg and make sure it's in your PATH"))
-		} else if err.Error() == "ffprobe is not installed or not in PATH" {
-			fmt.Println(ui.FormatInfo("Please install ffprobe and make sure it's in your PATH"))
-		} else {
-			fmt.Println(ui.FormatInfo(fmt.Sprintf("Please make sure %s is in the %s directory", exeName, dirName)))
-		}
+		printError(err)
+	}
+	return err
+}
 
-		return err
+// printError prints an error with advice on how to fix it
+func printError(err error) {
+	fmt.Println(ui.FormatError(fmt.Sprintf("Error: %v", err)))
+	if remedy := errs.Remedy(err); remedy != "" {
+		fmt.Println(ui.FormatInfo(remedy))
 	}
-	return nil
 }
 
 // runApplication starts the Bubble Tea application
-func runApplication() {
+func runApplication(options app.Options) {
+	model := app.NewUIModel(options)
+
+	// A signal cancels the running job like the quit key, so its frames are
+	// removed and its commands stopped
+	ctx, stop := signalContext()
+	defer stop()
+
 	// Create and run the program
-	p := tea.NewProgram(app.NewUIModel())
+	p := tea.NewProgram(model, tea.WithoutSignalHandler())
+	go func() {
+		<-ctx.Done()
+		p.Send(app.InterruptMsg{})
+	}()
 
-	if _, err := p.Run(); err != nil {
+	final, err := p.Run()
+	if err != nil {
 		fmt.Printf("Error running program: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Exit with the code of the error the job ended with, if any
+	if model, ok := final.(app.UIModel); ok && model.Err() != nil {
+		cleanup.CleanupAll()
+		os.Exit(errs.ExitCode(model.Err()))
+	}
+
 	// Note: We don't need to explicitly call cleanup.CleanupAll() here
 	// because we have a defer cleanup.CleanupAll() at the top of main()
 }
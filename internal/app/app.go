@@ -4,16 +4,17 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"videoup/internal/cleanup"
+	"videoup/internal/errs"
 	"videoup/internal/ffmpeg"
 	"videoup/internal/upscaler"
 )
 
-// ProcessVideo extracts frames from a video file
-func ProcessVideo(videoPath string) (string, error) {
+// ProcessVideo extracts frames from a video file. The frames directory is
+// also returned when the extraction fails, so it can be removed
+func ProcessVideo(videoPath string, options ffmpeg.Options) (string, error) {
 	// Create temp directory
 	tempDir, err := ffmpeg.CreateTempDir(videoPath)
 	if err != nil {
@@ -24,9 +25,9 @@ func ProcessVideo(videoPath string) (string, error) {
 	cleanup.RegisterDirectory(tempDir)
 
 	// Extract frames
-	err = ffmpeg.ExtractFrames(videoPath, tempDir)
+	err = ffmpeg.ExtractFrames(videoPath, tempDir, options)
 	if err != nil {
-		return "", err
+		return tempDir, err
 	}
 
 	return tempDir, nil
@@ -45,34 +46,147 @@ func UpscaleFrames(inputDir string, options upscaler.UpscalerOptions) (string, e
 
 	// Upscale frames
 	err = upscaler.UpscaleFrames(inputDir, upscaledDir, options)
+	if err != nil {
+		return "", errs.Upscale(err)
+	}
+
+	// Transparent sources need their alpha channel carried through
+	info, err := ffmpeg.ReadVideoInfo(inputDir)
 	if err != nil {
 		return "", err
 	}
+	if info.HasAlpha {
+		return upscaleAlpha(inputDir, upscaledDir, options)
+	}
 
 	return upscaledDir, nil
 }
 
-// CombineFramesToVideo combines upscaled frames into a video
-func CombineFramesToVideo(upscaledDir, videoPath string) (string, error) {
+// upscaleDepth upscales frames with upscale. realesrgan reads 8 bits per
+// component, so 16-bit frames are upscaled from 8-bit copies and the
+// precision the copies lost is added back to the result afterwards
+func upscaleDepth(upscale UpscaleFunc, framesDir string, scenes []Scene, options upscaler.UpscalerOptions) (string, error) {
+	info, err := ffmpeg.ReadVideoInfo(framesDir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsHighBitDepth() {
+		return upscale(framesDir, scenes, options)
+	}
+
+	fmt.Println("Upscaling 8-bit copies of the 16-bit frames, the lost precision is restored afterwards")
+
+	// Cancelling the upscale also stops ffmpeg
+	ffmpegOptions := ffmpeg.Options{Context: options.Context}
+
+	// These all live inside framesDir, so they are removed along with it
+	reducedDir := filepath.Join(framesDir, "8bit")
+	if err := ffmpeg.ReduceDepth(framesDir, reducedDir, ffmpegOptions); err != nil {
+		return "", err
+	}
+
+	upscaledDir, err := upscale(reducedDir, scenes, options)
+	if err != nil {
+		return "", err
+	}
+
+	restoredDir := filepath.Join(framesDir, "restored")
+	if err := ffmpeg.RestoreDepth(framesDir, reducedDir, upscaledDir, restoredDir, ffmpegOptions); err != nil {
+		return "", err
+	}
+
+	return restoredDir, nil
+}
+
+// upscaleAlpha makes sure the upscaled frames keep the source's alpha channel.
+// If the upscaler dropped it, the alpha masks are upscaled on their own and
+// merged back, returning the directory with the merged RGBA frames
+func upscaleAlpha(inputDir, upscaledDir string, options upscaler.UpscalerOptions) (string, error) {
+	files, err := filepath.Glob(filepath.Join(upscaledDir, "*.png"))
+	if err != nil || len(files) == 0 {
+		return "", fmt.Errorf("no upscaled frames found in %s", upscaledDir)
+	}
+
+	hasAlpha, err := upscaler.HasAlphaChannel(files[0])
+	if err != nil {
+		return "", err
+	}
+	if hasAlpha {
+		return upscaledDir, nil
+	}
+
+	fmt.Println("Upscaler dropped the alpha channel, upscaling it separately")
+
+	// Cancelling the upscale also stops ffmpeg
+	ffmpegOptions := ffmpeg.Options{Context: options.Context}
+
+	// These all live inside inputDir, so they are removed along with it
+	alphaDir := filepath.Join(inputDir, "alpha")
+	if err := ffmpeg.ExtractAlpha(inputDir, alphaDir, ffmpegOptions); err != nil {
+		return "", err
+	}
+
+	alphaUpscaledDir, err := upscaler.CreateUpscaledDir(alphaDir)
+	if err != nil {
+		return "", err
+	}
+	if err := upscaler.UpscaleFrames(alphaDir, alphaUpscaledDir, options); err != nil {
+		return "", err
+	}
+
+	mergedDir := filepath.Join(inputDir, "merged")
+	if err := ffmpeg.MergeAlpha(upscaledDir, alphaUpscaledDir, mergedDir, ffmpegOptions); err != nil {
+		return "", err
+	}
+
+	return mergedDir, nil
+}
+
+// CombineFramesToVideo combines upscaled frames into a video and verifies the
+// result. It also returns the source streams that could not be carried into
+// the output container
+func CombineFramesToVideo(upscaledDir, videoPath string, options Options) (string, []string, error) {
 	// Get video info
 	info, err := ffmpeg.GetVideoInfo(videoPath)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
 	// Create output video path
-	baseName := filepath.Base(videoPath)
-	nameWithoutExt := strings.TrimSuffix(baseName, filepath.Ext(baseName))
-	// Always use .mov extension for ProRes codec compatibility
-	outputVideoPath := filepath.Join(filepath.Dir(videoPath), nameWithoutExt+"_upscaled.mov")
+	outputVideoPath, err := OutputPath(videoPath, info, options)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Record how the output was made in its container tags
+	options.FFmpeg.Metadata, err = ProvenanceTags(info, options)
+	if err != nil {
+		return "", nil, err
+	}
 
 	// Combine frames into video
-	err = ffmpeg.CombineFramesToVideo(upscaledDir, outputVideoPath, info)
+	err = ffmpeg.CombineFramesToVideo(upscaledDir, outputVideoPath, info, options.FFmpeg)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 
-	return outputVideoPath, nil
+	// Check the output holds what the source should have produced
+	dropped := ffmpeg.PlanPassthrough(info, options.FFmpeg.Container).Dropped
+	if err := VerifyOutput(outputVideoPath, info, upscaledDir, options); err != nil {
+		return outputVideoPath, dropped, err
+	}
+
+	return outputVideoPath, dropped, nil
+}
+
+// RenderComparison renders a comparison between the original and the upscaled
+// video next to the upscaled video, returning its path
+func RenderComparison(videoPath, outputVideoPath string, options ffmpeg.Options) (string, error) {
+	comparisonPath := ffmpeg.ComparisonPath(outputVideoPath)
+	if err := ffmpeg.RenderComparison(videoPath, outputVideoPath, comparisonPath, options); err != nil {
+		return "", err
+	}
+	return comparisonPath, nil
 }
 
 // CleanupTempFiles removes temporary directories
@@ -105,20 +219,35 @@ func CleanupTempFiles(outputDir, upscaledDir string) error {
 
 // CheckDependencies checks if all required dependencies are installed
 func CheckDependencies() error {
+	if err := CheckFFmpeg(); err != nil {
+		return err
+	}
+
+	// Check if realesrgan is installed
+	_, err := upscaler.RealesrganPath()
+	return err
+}
+
+// CheckFFmpeg checks if ffmpeg and ffprobe are installed
+func CheckFFmpeg() error {
 	// Check if ffmpeg is installed
 	if !ffmpeg.IsFFmpegInstalled() {
-		return fmt.Errorf("ffmpeg is not installed or not in PATH")
+		return missingFromPath("ffmpeg")
 	}
 
 	// Check if ffprobe is installed
 	if !ffmpeg.IsFFprobeInstalled() {
-		return fmt.Errorf("ffprobe is not installed or not in PATH")
-	}
-
-	// Check if realesrgan is installed
-	if !upscaler.IsRealesrganInstalled() {
-		return fmt.Errorf("realesrgan-ncnn-vulkan is not installed or not found")
+		return missingFromPath("ffprobe")
 	}
 
 	return nil
 }
+
+// missingFromPath returns the error for a program that is not in the PATH
+func missingFromPath(program string) error {
+	return &errs.DependencyError{
+		Name:     program,
+		Location: "PATH",
+		Remedy:   fmt.Sprintf("Please install %s and make sure it's in your PATH", program),
+	}
+}
@@ -0,0 +1,227 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"videoup/internal/cleanup"
+	"videoup/internal/ffmpeg"
+	"videoup/internal/upscaler"
+)
+
+// defaultBakeoffClip is the length of the clip used when no range is given
+const defaultBakeoffClip = "5s"
+
+// BakeoffResult holds the outcome of running one model/scale on the clip
+type BakeoffResult struct {
+	upscaler.ModelScale
+	Frames      int     `json:"frames"`
+	Seconds     float64 `json:"seconds"`
+	MsPerFrame  float64 `json:"ms_per_frame"`
+	FramesBytes int64   `json:"frames_bytes"`
+	OutputBytes int64   `json:"output_bytes"`
+	Width       int     `json:"width"`
+	Height      int     `json:"height"`
+	OutputPath  string  `json:"output_path,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// BakeoffReport holds the results of a bake-off
+type BakeoffReport struct {
+	Source   string          `json:"source"`
+	Start    string          `json:"start"`
+	End      string          `json:"end"`
+	GridPath string          `json:"grid_path,omitempty"`
+	Results  []BakeoffResult `json:"results"`
+}
+
+// RunBakeoff upscales the same clip with each candidate model/scale and
+// writes one clip per candidate, a grid comparing them and bakeoff.json into
+// a "<name>_bakeoff" directory next to the video. Without candidates every
+// installed model/scale is tried
+func RunBakeoff(videoPath string, options Options, candidates []upscaler.ModelScale) (*BakeoffReport, string, error) {
+	if len(candidates) == 0 {
+		candidates = upscaler.InstalledModelScales()
+	}
+	if len(candidates) == 0 {
+		return nil, "", fmt.Errorf("no installed models found")
+	}
+
+	// Use the start of the video when no clip is selected
+	ffmpegOptions := options.FFmpeg
+	ffmpegOptions.Splice = false
+	if !ffmpegOptions.HasRange() {
+		ffmpegOptions.End = defaultBakeoffClip
+	}
+
+	info, err := ffmpeg.GetVideoInfo(videoPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	baseName := filepath.Base(videoPath)
+	nameWithoutExt := strings.TrimSuffix(baseName, filepath.Ext(baseName))
+	bakeoffDir := filepath.Join(filepath.Dir(videoPath), nameWithoutExt+"_bakeoff")
+	if err := os.MkdirAll(bakeoffDir, 0755); err != nil {
+		return nil, "", fmt.Errorf("failed to create bake-off directory: %w", err)
+	}
+
+	// The clip is extracted once and shared by every candidate, in a temp
+	// directory in the working directory next to those of jobs
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get current working directory: %w", err)
+	}
+	tempDir, err := os.MkdirTemp(cwd, fmt.Sprintf("temp_frames_%s_bakeoff_", nameWithoutExt))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	cleanup.RegisterDirectory(tempDir)
+	defer func() {
+		if err := os.RemoveAll(tempDir); err == nil {
+			cleanup.RemoveDirectory(tempDir)
+		}
+	}()
+
+	framesDir := filepath.Join(tempDir, "frames")
+	if err := ffmpeg.ExtractFrames(videoPath, framesDir, ffmpegOptions); err != nil {
+		return nil, "", err
+	}
+	frames, _ := filepath.Glob(filepath.Join(framesDir, "*.png"))
+
+	// realesrgan reads 8 bits, so high bit depth frames are compared as the
+	// 8-bit copies a job upscales, without the restore that follows there
+	if info.IsHighBitDepth() {
+		reducedDir := filepath.Join(tempDir, "frames_8bit")
+		if err := ffmpeg.ReduceDepth(framesDir, reducedDir, ffmpegOptions); err != nil {
+			return nil, "", err
+		}
+		framesDir = reducedDir
+	}
+
+	report := &BakeoffReport{
+		Source: videoPath,
+		Start:  ffmpegOptions.Start,
+		End:    ffmpegOptions.End,
+	}
+
+	var clips []string
+	tileWidth, tileHeight := 0, 0
+	for _, candidate := range candidates {
+		fmt.Printf("Bake-off: %s\n", candidate)
+		result := BakeoffResult{ModelScale: candidate, Frames: len(frames)}
+
+		upscalerOptions := options.Upscaler
+		upscalerOptions.Model = candidate.Model
+		upscalerOptions.Scale = candidate.Scale
+
+		name := fmt.Sprintf("%s_x%d", candidate.Model, candidate.Scale)
+		upscaledDir := filepath.Join(tempDir, name)
+
+		started := time.Now()
+		err := upscaler.UpscaleFrames(framesDir, upscaledDir, upscalerOptions)
+		elapsed := time.Since(started)
+		if err != nil {
+			result.Error = err.Error()
+			report.Results = append(report.Results, result)
+			continue
+		}
+
+		result.Seconds = elapsed.Seconds()
+		if len(frames) > 0 {
+			result.MsPerFrame = float64(elapsed.Milliseconds()) / float64(len(frames))
+		}
+		result.FramesBytes = dirSize(upscaledDir)
+		result.Width, result.Height, _ = ffmpeg.FrameSize(upscaledDir)
+
+		clipPath := filepath.Join(bakeoffDir, name+ffmpegOptions.Container.Extension())
+		if err := os.Remove(clipPath); err != nil && !os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("failed to remove old clip: %w", err)
+		}
+		if err := ffmpeg.CombineFramesToVideo(upscaledDir, clipPath, info, ffmpegOptions); err != nil {
+			result.Error = err.Error()
+			report.Results = append(report.Results, result)
+			continue
+		}
+		if stat, err := os.Stat(clipPath); err == nil {
+			result.OutputBytes = stat.Size()
+		}
+		result.OutputPath = clipPath
+		report.Results = append(report.Results, result)
+		clips = append(clips, clipPath)
+
+		// Tiles use the smallest output so no candidate is enlarged further
+		if tileWidth == 0 || result.Width < tileWidth {
+			tileWidth, tileHeight = result.Width, result.Height
+		}
+
+		// Free the disk space before the next candidate
+		os.RemoveAll(upscaledDir)
+	}
+
+	if len(clips) > 0 {
+		gridPath := filepath.Join(bakeoffDir, "grid"+ffmpegOptions.Container.Extension())
+		if err := os.Remove(gridPath); err != nil && !os.IsNotExist(err) {
+			return nil, "", fmt.Errorf("failed to remove old grid: %w", err)
+		}
+		if err := ffmpeg.RenderGrid(clips, gridPath, tileWidth, tileHeight, ffmpegOptions); err != nil {
+			return nil, "", err
+		}
+		report.GridPath = gridPath
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal bake-off report: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(bakeoffDir, "bakeoff.json"), data, 0644); err != nil {
+		return nil, "", fmt.Errorf("failed to write bake-off report: %w", err)
+	}
+
+	return report, bakeoffDir, nil
+}
+
+// WriteBakeoffTable writes the bake-off results as a table. Grid tiles are in
+// the same order as the rows
+func WriteBakeoffTable(w io.Writer, report *BakeoffReport) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "TILE\tMODEL\tSCALE\tOUTPUT\tMS/FRAME\tFRAMES SIZE\tCLIP SIZE\tRESULT")
+	tile := 0
+	for _, result := range report.Results {
+		status := "ok"
+		position := "-"
+		if result.Error != "" {
+			status = result.Error
+		} else {
+			tile++
+			position = fmt.Sprintf("%d", tile)
+		}
+		fmt.Fprintf(table, "%s\t%s\tx%d\t%dx%d\t%.0f\t%s\t%s\t%s\n",
+			position, result.Model, result.Scale, result.Width, result.Height,
+			result.MsPerFrame, formatBytes(result.FramesBytes), formatBytes(result.OutputBytes), status)
+	}
+	table.Flush()
+}
+
+// dirSize returns the total size of the files in a directory
+func dirSize(dir string) int64 {
+	var total int64
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// formatBytes formats a size in bytes as MB
+func formatBytes(size int64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+}
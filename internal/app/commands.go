@@ -1,6 +1,10 @@
 package app
 
 import (
+	"context"
+
+	"videoup/internal/classify"
+	"videoup/internal/ffmpeg"
 	"videoup/internal/upscaler"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -15,64 +19,102 @@ func (e errMsg) Error() string {
 	return e.err.Error()
 }
 
-type processResultMsg struct {
-	outputDir string
+type probeResultMsg struct {
+	info *ffmpeg.VideoInfo
+}
+
+type contentResultMsg struct {
+	content *classify.Result
+	err     error
 }
 
-type upscaleResultMsg struct {
-	upscaledDir string
+type upscaleProgressMsg struct {
+	progress upscaler.Progress
+	updates  <-chan tea.Msg
 }
 
-type combineResultMsg struct {
-	outputVideoPath string
+type stageMsg struct {
+	stage   string
+	updates <-chan tea.Msg
 }
 
-type cleanupResultMsg struct {
-	success bool
+type jobResultMsg struct {
+	result *JobResult
+	err    error
+}
+
+// InterruptMsg asks the UI to cancel the running job and quit, as the quit
+// key does. It is sent when the program is signalled
+type InterruptMsg struct{}
+
+type previewResultMsg struct {
+	previewDir string
+}
+
+type previewErrMsg struct {
+	err error
 }
 
 // Command functions for Bubble Tea
 
-// processVideoCmd creates a command to process a video
-func processVideoCmd(videoPath string) tea.Cmd {
+// probeVideoCmd creates a command to read the properties of a video
+func probeVideoCmd(videoPath string) tea.Cmd {
 	return func() tea.Msg {
-		outputDir, err := ProcessVideo(videoPath)
+		info, err := ffmpeg.GetVideoInfo(videoPath)
 		if err != nil {
 			return errMsg{err}
 		}
-		return processResultMsg{outputDir: outputDir}
+		return probeResultMsg{info: info}
 	}
 }
 
-// upscaleFramesCmd creates a command to upscale frames
-func upscaleFramesCmd(inputDir string, options upscaler.UpscalerOptions) tea.Cmd {
+// analyzeContentCmd creates a command to classify the content of a video
+func analyzeContentCmd(videoPath string, info *ffmpeg.VideoInfo, options ffmpeg.Options) tea.Cmd {
 	return func() tea.Msg {
-		upscaledDir, err := UpscaleFrames(inputDir, options)
-		if err != nil {
-			return errMsg{err}
+		content, err := AnalyzeContent(videoPath, info, options)
+		return contentResultMsg{content: content, err: err}
+	}
+}
+
+// runJobCmd creates a command to run a job through RunJobWith. The stages
+// are reported with stageMsg and the upscaling with upscaleProgressMsg until
+// the result arrives. content is the analysis done while the settings were
+// edited, if any
+func runJobCmd(ctx context.Context, videoPath string, options Options, content *classify.Result) tea.Cmd {
+	updates := make(chan tea.Msg, 16)
+	options.Upscaler.OnProgress = func(progress upscaler.Progress) {
+		// Skip updates the UI has not caught up with, a newer one follows
+		select {
+		case updates <- upscaleProgressMsg{progress: progress, updates: updates}:
+		default:
 		}
-		return upscaleResultMsg{upscaledDir: upscaledDir}
 	}
+	onStage := func(stage string) {
+		updates <- stageMsg{stage: stage, updates: updates}
+	}
+
+	go func() {
+		result, err := RunJobWith(ctx, videoPath, options, JobSetup{OnStage: onStage, Content: content})
+		updates <- jobResultMsg{result: result, err: err}
+	}()
+
+	return waitForJobCmd(updates)
 }
 
-// combineFramesCmd creates a command to combine frames into a video
-func combineFramesCmd(upscaledDir, videoPath string) tea.Cmd {
+// waitForJobCmd creates a command that waits for the next update of a job
+func waitForJobCmd(updates <-chan tea.Msg) tea.Cmd {
 	return func() tea.Msg {
-		outputVideoPath, err := CombineFramesToVideo(upscaledDir, videoPath)
-		if err != nil {
-			return errMsg{err}
-		}
-		return combineResultMsg{outputVideoPath: outputVideoPath}
+		return <-updates
 	}
 }
 
-// cleanupFilesCmd creates a command to clean up temporary files
-func cleanupFilesCmd(outputDir, upscaledDir string) tea.Cmd {
+// renderPreviewCmd creates a command to render a preview
+func renderPreviewCmd(videoPath string, options Options) tea.Cmd {
 	return func() tea.Msg {
-		err := CleanupTempFiles(outputDir, upscaledDir)
+		previewDir, err := RenderPreview(videoPath, options)
 		if err != nil {
-			return errMsg{err}
+			return previewErrMsg{err}
 		}
-		return cleanupResultMsg{success: true}
+		return previewResultMsg{previewDir: previewDir}
 	}
 }
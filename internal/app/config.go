@@ -0,0 +1,43 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// LoadConfig reads a config file: a JSON object of flag names without the
+// dash and their values, e.g. {"tile-size": 256, "threads": "1:2:2"}. The
+// values are returned as they would be written on the command line. A
+// missing file gives no values when optional is set
+func LoadConfig(path string, optional bool) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if optional && errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	config := map[string]string{}
+	for name, value := range values {
+		switch value := value.(type) {
+		case string:
+			config[name] = value
+		case float64:
+			config[name] = strconv.FormatFloat(value, 'f', -1, 64)
+		case bool:
+			config[name] = strconv.FormatBool(value)
+		default:
+			return nil, fmt.Errorf("invalid config file %s: %s must be a string, number or boolean", path, name)
+		}
+	}
+	return config, nil
+}
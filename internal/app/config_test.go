@@ -0,0 +1,64 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	data := `{"tile-size": 256, "threads": "1:2:2", "metrics": true, "scene-threshold": 0.3, "scale": 1000000}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Values come back as they would be written on the command line
+	config, err := LoadConfig(path, false)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	want := map[string]string{
+		"tile-size":       "256",
+		"threads":         "1:2:2",
+		"metrics":         "true",
+		"scene-threshold": "0.3",
+		"scale":           "1000000",
+	}
+	for name, value := range want {
+		if config[name] != value {
+			t.Errorf("LoadConfig()[%q] = %q, want %q", name, config[name], value)
+		}
+	}
+	if len(config) != len(want) {
+		t.Errorf("LoadConfig() = %v, want %d values", config, len(want))
+	}
+}
+
+func TestLoadConfigMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if config, err := LoadConfig(path, true); err != nil || len(config) != 0 {
+		t.Errorf("LoadConfig() of a missing optional file = %v, %v, want nothing", config, err)
+	}
+	if _, err := LoadConfig(path, false); err == nil {
+		t.Error("LoadConfig() of a missing file given with -config succeeded")
+	}
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	for data, reason := range map[string]string{
+		`{"tile-size": 256,}`:         "malformed JSON",
+		`["tile-size", 256]`:          "not an object",
+		`{"gpus": [0, 1]}`:            "list value",
+		`{"output": {"dir": "/out"}}`: "nested object",
+	} {
+		path := filepath.Join(t.TempDir(), "config.json")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if config, err := LoadConfig(path, false); err == nil {
+			t.Errorf("LoadConfig(%s) = %v, want an error (%s)", data, config, reason)
+		}
+	}
+}
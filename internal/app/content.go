@@ -0,0 +1,72 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"videoup/internal/classify"
+	"videoup/internal/cleanup"
+	"videoup/internal/ffmpeg"
+	"videoup/internal/upscaler"
+)
+
+// ModelAuto picks the model from the content of the video
+const ModelAuto = "auto"
+
+// Models recommended for each kind of content. The general model copes with
+// drawn scenes, the anime model smears camera footage, so mixed content gets
+// the general one
+const (
+	animationModel = "realesrgan-x4plus-anime"
+	generalModel   = "realesrgan-x4plus"
+)
+
+// Frames sampled for the content analysis and their width
+const (
+	contentSamples = 8
+	contentWidth   = 640
+)
+
+// AnalyzeContent samples frames from the selected range of a video and
+// classifies them as animation or live action
+func AnalyzeContent(videoPath string, info *ffmpeg.VideoInfo, options ffmpeg.Options) (*classify.Result, error) {
+	tempDir, err := os.MkdirTemp("", "videoup_content_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sample directory: %w", err)
+	}
+	cleanup.RegisterDirectory(tempDir)
+	defer func() {
+		if err := os.RemoveAll(tempDir); err == nil {
+			cleanup.RemoveDirectory(tempDir)
+		}
+	}()
+
+	paths, err := ffmpeg.SampleFrames(videoPath, info, tempDir, contentSamples, contentWidth, options)
+	if err != nil {
+		return nil, err
+	}
+	result, err := classify.Classify(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	// The samples are gone once this returns
+	for i := range result.Frames {
+		result.Frames[i].Path = filepath.Base(result.Frames[i].Path)
+	}
+	return result, nil
+}
+
+// RecommendModel returns the model suited to the content, or the default
+// model when the content is unknown
+func RecommendModel(content *classify.Result) string {
+	switch {
+	case content == nil:
+		return upscaler.DefaultOptions().Model
+	case content.Kind == classify.KindAnimation:
+		return animationModel
+	default:
+		return generalModel
+	}
+}
@@ -0,0 +1,275 @@
+package app
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"videoup/internal/ffmpeg"
+	"videoup/internal/upscaler"
+)
+
+// CheckStatus is the outcome of a doctor check
+type CheckStatus string
+
+const (
+	// CheckOK means the check passed
+	CheckOK CheckStatus = "ok"
+	// CheckWarn means an optional feature is unavailable
+	CheckWarn CheckStatus = "warn"
+	// CheckFail means videoup cannot work until it is fixed
+	CheckFail CheckStatus = "fail"
+)
+
+// DoctorCheck is the result of one doctor check
+type DoctorCheck struct {
+	Name   string
+	Status CheckStatus
+	Detail string
+	// What to do about a warning or failure
+	Fix string
+}
+
+// optionalEncoders are encoders used for some sources, with what they are for
+var optionalEncoders = []struct{ name, purpose string }{
+	{"libx265", "HDR10 output with -hdr preserve"},
+}
+
+// optionalDecoders are decoders used for some sources
+var optionalDecoders = []struct{ name, purpose string }{
+	{"libvpx-vp9", "VP9 WebM sources with alpha"},
+}
+
+// optionalFilters are filters used for some options
+var optionalFilters = []struct{ name, purpose string }{
+	{"zscale", "-hdr tonemap"},
+	{"libvmaf", "VMAF scores with -metrics"},
+}
+
+// RunDoctor checks that each dependency is installed and works: ffmpeg and
+// ffprobe versions and components, the realesrgan executable and models, the
+// Vulkan devices realesrgan sees and a one frame upscale on each configured GPU
+func RunDoctor(options Options) []DoctorCheck {
+	var checks []DoctorCheck
+
+	ffmpegOK := false
+	for _, program := range []string{"ffmpeg", "ffprobe"} {
+		info, err := ffmpeg.GetBuildInfo(program)
+		if err != nil {
+			checks = append(checks, DoctorCheck{
+				Name:   program,
+				Status: CheckFail,
+				Detail: err.Error(),
+				Fix:    fmt.Sprintf("Install FFmpeg from https://ffmpeg.org/download.html and make sure %s is in your PATH", program),
+			})
+			continue
+		}
+		checks = append(checks, DoctorCheck{
+			Name:   program,
+			Status: CheckOK,
+			Detail: fmt.Sprintf("version %s (%s)", info.Version, info.Path),
+		})
+		if program == "ffmpeg" {
+			ffmpegOK = true
+			checks = append(checks, DoctorCheck{
+				Name:   "ffmpeg build",
+				Status: CheckOK,
+				Detail: info.Configuration,
+			})
+		}
+	}
+
+	if ffmpegOK {
+		checks = append(checks, ffmpegComponentChecks()...)
+	}
+
+	checks = append(checks, upscalerChecks(options.Upscaler)...)
+
+	return checks
+}
+
+// ffmpegComponentChecks checks the encoders, decoders and filters videoup uses
+func ffmpegComponentChecks() []DoctorCheck {
+	var checks []DoctorCheck
+
+	if ffmpeg.HasEncoder("prores_ks") {
+		checks = append(checks, DoctorCheck{Name: "encoder prores_ks", Status: CheckOK, Detail: "ProRes output"})
+	} else {
+		checks = append(checks, DoctorCheck{
+			Name:   "encoder prores_ks",
+			Status: CheckFail,
+			Detail: "ffmpeg has no ProRes encoder, which every output uses",
+			Fix:    "Install a full FFmpeg build (for example from https://ffmpeg.org/download.html)",
+		})
+	}
+
+	for _, encoder := range optionalEncoders {
+		checks = append(checks, optionalCheck("encoder "+encoder.name, ffmpeg.HasEncoder(encoder.name), encoder.purpose))
+	}
+	for _, decoder := range optionalDecoders {
+		checks = append(checks, optionalCheck("decoder "+decoder.name, ffmpeg.HasDecoder(decoder.name), decoder.purpose))
+	}
+	for _, filter := range optionalFilters {
+		checks = append(checks, optionalCheck("filter "+filter.name, ffmpeg.HasFilter(filter.name), filter.purpose))
+	}
+
+	return checks
+}
+
+// optionalCheck reports an ffmpeg component that only some jobs need
+func optionalCheck(name string, available bool, purpose string) DoctorCheck {
+	if available {
+		return DoctorCheck{Name: name, Status: CheckOK, Detail: purpose}
+	}
+	component := strings.Fields(name)[1]
+	return DoctorCheck{
+		Name:   name,
+		Status: CheckWarn,
+		Detail: "not available, needed for " + purpose,
+		Fix:    fmt.Sprintf("Use an FFmpeg build configured with %s if you need %s", component, purpose),
+	}
+}
+
+// upscalerChecks checks the realesrgan executable, its models and the GPUs
+func upscalerChecks(options upscaler.UpscalerOptions) []DoctorCheck {
+	exePath, err := upscaler.RealesrganPath()
+	if err != nil {
+		return []DoctorCheck{{
+			Name:   "realesrgan",
+			Status: CheckFail,
+			Detail: err.Error(),
+			Fix: fmt.Sprintf("Download realesrgan-ncnn-vulkan for %s from https://github.com/xinntao/Real-ESRGAN/releases and put it in the realesrgan_%s directory",
+				runtime.GOOS, realesrganDirSuffix()),
+		}}
+	}
+	checks := []DoctorCheck{{Name: "realesrgan", Status: CheckOK, Detail: exePath}}
+
+	installed := upscaler.InstalledModelScales()
+	var names []string
+	for _, model := range installed {
+		names = append(names, model.String())
+	}
+	if len(installed) == 0 {
+		return append(checks, DoctorCheck{
+			Name:   "models",
+			Status: CheckFail,
+			Detail: "no complete model (.param and .bin) found",
+			Fix:    "Copy the models directory from the realesrgan-ncnn-vulkan release next to the executable",
+		})
+	}
+	checks = append(checks, DoctorCheck{Name: "models", Status: CheckOK, Detail: strings.Join(names, ", ")})
+	if !upscaler.IsModelInstalled(options.Model, upscaler.NativeScales(options.Model)[0]) {
+		checks = append(checks, DoctorCheck{
+			Name:   "model " + options.Model,
+			Status: CheckFail,
+			Detail: "the default model is missing its .param or .bin file",
+			Fix:    "Copy " + options.Model + ".param and .bin from the realesrgan-ncnn-vulkan release into the models directory",
+		})
+	}
+
+	// Smoke test with the configured model when it is installed, at the
+	// smallest installed scale so it is quick
+	test := installed[0]
+	for _, candidate := range installed[1:] {
+		configured := candidate.Model == options.Model
+		if configured != (test.Model == options.Model) {
+			if configured {
+				test = candidate
+			}
+			continue
+		}
+		if candidate.Scale < test.Scale {
+			test = candidate
+		}
+	}
+
+	devices := options.GPUs
+	if len(devices) == 0 {
+		devices = []upscaler.GPUDevice{{ID: 0, Weight: 1}}
+	}
+	for _, device := range devices {
+		name := fmt.Sprintf("GPU %d", device.ID)
+		result, err := upscaler.SmokeTest(test.Model, test.Scale, device.ID)
+
+		// The devices are listed once, from the first run
+		if device.ID == devices[0].ID && result != nil {
+			checks = append(checks, deviceListCheck(result))
+		}
+
+		if err != nil {
+			check := DoctorCheck{Name: name, Status: CheckFail, Detail: "one frame test failed: " + err.Error()}
+			if result != nil {
+				check.Fix = smokeTestFix(result, device.ID)
+			}
+			checks = append(checks, check)
+			continue
+		}
+		checks = append(checks, DoctorCheck{
+			Name:   name,
+			Status: CheckOK,
+			Detail: fmt.Sprintf("upscaled a test frame with %s", test),
+		})
+	}
+
+	return checks
+}
+
+// deviceListCheck reports the Vulkan devices realesrgan enumerated
+func deviceListCheck(result *upscaler.SmokeTestResult) DoctorCheck {
+	if len(result.Devices) == 0 {
+		return DoctorCheck{
+			Name:   "vulkan devices",
+			Status: CheckWarn,
+			Detail: "realesrgan did not list any Vulkan device",
+			Fix:    vulkanFix(),
+		}
+	}
+	var names []string
+	for _, device := range result.Devices {
+		names = append(names, fmt.Sprintf("%d: %s", device.ID, device.Name))
+	}
+	return DoctorCheck{Name: "vulkan devices", Status: CheckOK, Detail: strings.Join(names, "; ")}
+}
+
+// smokeTestFix suggests a fix based on the realesrgan output of a failed test
+func smokeTestFix(result *upscaler.SmokeTestResult, gpu int) string {
+	output := strings.ToLower(result.Output)
+	switch {
+	case strings.Contains(output, "invalid gpu device"):
+		return fmt.Sprintf("GPU %d does not exist; pass one of the device IDs listed above with -gpus", gpu)
+	case strings.Contains(output, "vkcreateinstance failed") || strings.Contains(output, "vulkan"):
+		return vulkanFix()
+	case strings.Contains(output, "out of memory"):
+		return "Close other programs using the GPU, or try another device with -gpus"
+	case strings.Contains(output, "fopen") || strings.Contains(output, "no such file"):
+		return "A model file is missing or unreadable; copy the models directory from the realesrgan-ncnn-vulkan release"
+	default:
+		return "Run realesrgan-ncnn-vulkan by hand to see its full output:\n" + strings.TrimSpace(result.Output)
+	}
+}
+
+// vulkanFix describes how to get a working Vulkan driver on this OS
+func vulkanFix() string {
+	switch runtime.GOOS {
+	case "linux":
+		return "Install the Vulkan loader and your GPU's Vulkan driver (e.g. libvulkan1 and mesa-vulkan-drivers or the NVIDIA driver), then check with vulkaninfo"
+	case "windows":
+		return "Update your GPU driver from the vendor's website; Vulkan support comes with it"
+	case "darwin":
+		return "Vulkan runs through MoltenVK on macOS; make sure the bundled realesrgan build is used and macOS is up to date"
+	default:
+		return "Install a Vulkan driver for your GPU"
+	}
+}
+
+// realesrganDirSuffix returns the suffix of the realesrgan directory for this OS
+func realesrganDirSuffix() string {
+	switch runtime.GOOS {
+	case "windows":
+		return "win"
+	case "darwin":
+		return "mac"
+	default:
+		return runtime.GOOS
+	}
+}
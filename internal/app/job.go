@@ -0,0 +1,240 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"videoup/internal/classify"
+	"videoup/internal/cleanup"
+	"videoup/internal/errs"
+	"videoup/internal/ffmpeg"
+	"videoup/internal/upscaler"
+)
+
+// Job stages, in the order RunJob goes through them
+const (
+	StageProbing    = "probing"
+	StageAnalyzing  = "analyzing content"
+	StageScenes     = "detecting scenes"
+	StageExtracting = "extracting"
+	StageUpscaling  = "upscaling"
+	StageEncoding   = "encoding"
+	StageComparing  = "comparing"
+	StageMeasuring  = "measuring"
+	StageReporting  = "reporting"
+	StageCleaning   = "cleaning"
+)
+
+// JobResult holds the files a job produced
+type JobResult struct {
+	Output        string   `json:"output"`
+	Skipped       bool     `json:"skipped,omitempty"`
+	Dropped       []string `json:"dropped,omitempty"`
+	Comparison    string   `json:"comparison,omitempty"`
+	QualityReport string   `json:"quality_report,omitempty"`
+	JobReport     string   `json:"job_report,omitempty"`
+	// Why the job report could not be written, the output is fine
+	ReportError string `json:"report_error,omitempty"`
+	// The measured quality, also written to QualityReport
+	Quality *ffmpeg.QualityReport `json:"-"`
+}
+
+// UpscaleFunc upscales the frames in framesDir and returns the directory
+// holding the upscaled frames. scenes are numbered by frame file (see
+// FrameScenes) and nil when the video is not split into scenes
+type UpscaleFunc func(framesDir string, scenes []Scene, options upscaler.UpscalerOptions) (string, error)
+
+// JobSetup changes how RunJobWith runs a job, fields left zero keep the
+// behaviour of RunJob
+type JobSetup struct {
+	// OnStage is called as each stage starts
+	OnStage func(stage string)
+	// Upscale upscales the frames instead of UpscaleScenes, e.g. on other
+	// machines
+	Upscale UpscaleFunc
+	// Content is the analysis of the video when it was already done, used
+	// for the auto model and the report instead of analyzing again
+	Content *classify.Result
+}
+
+// RunJob upscales a video. This is the pipeline behind the UI as well as
+// serve, watch and coordinate. onStage is called as each stage starts (may be
+// nil) and upscaling progress goes to options.Upscaler.OnProgress. Cancelling
+// ctx stops the running command and returns errs.ErrCancelled. The temporary
+// frames are removed unless the output failed verification
+func RunJob(ctx context.Context, videoPath string, options Options, onStage func(stage string)) (*JobResult, error) {
+	return RunJobWith(ctx, videoPath, options, JobSetup{OnStage: onStage})
+}
+
+// RunJobWith is RunJob changed by setup
+func RunJobWith(ctx context.Context, videoPath string, options Options, setup JobSetup) (*JobResult, error) {
+	onStage, upscale, content := setup.OnStage, setup.Upscale, setup.Content
+	if upscale == nil {
+		upscale = UpscaleScenes
+	}
+
+	options.FFmpeg.Context = ctx
+	options.Upscaler.Context = ctx
+
+	var timings []StageTiming
+	var current string
+	started := time.Now()
+	stage := func(name string) {
+		if current != "" {
+			timings = append(timings, StageTiming{Stage: current, Seconds: time.Since(started).Seconds()})
+		}
+		current, started = name, time.Now()
+		if onStage != nil {
+			onStage(name)
+		}
+	}
+
+	// The progress of the last pass is kept for the report. Workers report
+	// from their own goroutines
+	var mu sync.Mutex
+	var progress upscaler.Progress
+	onProgress := options.Upscaler.OnProgress
+	options.Upscaler.OnProgress = func(p upscaler.Progress) {
+		mu.Lock()
+		progress = p
+		mu.Unlock()
+		if onProgress != nil {
+			onProgress(p)
+		}
+	}
+
+	stage(StageProbing)
+	info, err := ffmpeg.GetVideoInfo(videoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Upscaler.Model == ModelAuto {
+		if content == nil {
+			stage(StageAnalyzing)
+			if content, err = AnalyzeContent(videoPath, info, options.FFmpeg); err != nil {
+				return nil, jobError(ctx, err)
+			}
+		}
+		options.Upscaler.Model = RecommendModel(content)
+	}
+	plan, err := PlanOutput(info, options)
+	if err != nil {
+		return nil, err
+	}
+	options.Upscaler.Scale = plan.Scale
+
+	// Don't spend hours on a video whose output would be skipped anyway
+	outputPath, err := OutputPath(videoPath, info, options)
+	if errors.Is(err, ErrOutputExists) {
+		return &JobResult{Output: outputPath, Skipped: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Scenes come from the source, so they are known before extracting
+	var scenes []Scene
+	if options.Scenes.File != "" || options.Scenes.Threshold > 0 {
+		stage(StageScenes)
+		if scenes, err = FindScenes(videoPath, info, options); err != nil {
+			return nil, jobError(ctx, err)
+		}
+	}
+	start, _, err := ffmpeg.ResolveRange(info, options.FFmpeg)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputDir, upscaledDir string
+	keep := false
+	defer func() {
+		if !keep {
+			removeTempDirs(outputDir, upscaledDir)
+		}
+	}()
+
+	stage(StageExtracting)
+	outputDir, err = ProcessVideo(videoPath, options.FFmpeg)
+	if err != nil {
+		return nil, jobError(ctx, err)
+	}
+
+	stage(StageUpscaling)
+	if upscaledDir, err = upscaleDepth(upscale, outputDir, FrameScenes(scenes, start), options.Upscaler); err != nil {
+		return nil, jobError(ctx, err)
+	}
+
+	stage(StageEncoding)
+	result := &JobResult{}
+	result.Output, result.Dropped, err = CombineFramesToVideo(upscaledDir, videoPath, options)
+	if err != nil {
+		// Keep the frames of an output that failed verification, so it can
+		// be encoded again without upscaling
+		var verifyErr *errs.VerifyError
+		if errors.As(err, &verifyErr) {
+			keep = true
+			cleanup.KeepDirectory(outputDir)
+			verifyErr.Kept = append(verifyErr.Kept, outputDir)
+		}
+		return nil, jobError(ctx, err)
+	}
+
+	if options.FFmpeg.Compare != ffmpeg.CompareNone {
+		stage(StageComparing)
+		if result.Comparison, err = RenderComparison(videoPath, result.Output, options.FFmpeg); err != nil {
+			return nil, jobError(ctx, err)
+		}
+	}
+
+	if options.FFmpeg.Metrics {
+		stage(StageMeasuring)
+		if result.Quality, result.QualityReport, err = MeasureQuality(videoPath, result.Output, options.FFmpeg); err != nil {
+			return nil, jobError(ctx, err)
+		}
+	}
+
+	// The output is done even if its report cannot be written
+	stage(StageReporting)
+	report, err := NewJobReport(result.Output, info, options)
+	if err == nil {
+		report.Timings = timings
+		report.Scenes = scenes
+		report.Content = content
+		mu.Lock()
+		report.SetDevices(progress.Devices)
+		mu.Unlock()
+		report.Dropped = result.Dropped
+		report.Comparison = result.Comparison
+		report.QualityReport = result.QualityReport
+		result.JobReport, err = WriteJobReport(report)
+	}
+	if err != nil {
+		result.ReportError = err.Error()
+	}
+
+	stage(StageCleaning)
+	return result, nil
+}
+
+// jobError reports an error caused by a cancel as errs.ErrCancelled
+func jobError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return errs.ErrCancelled
+	}
+	return err
+}
+
+// removeTempDirs removes the frame directories of a job that were created
+func removeTempDirs(outputDir, upscaledDir string) {
+	if outputDir == "" {
+		return
+	}
+	if upscaledDir == "" {
+		// The upscaled frames live inside the extracted frames directory
+		upscaledDir = outputDir
+	}
+	CleanupTempFiles(outputDir, upscaledDir)
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"videoup/internal/ffmpeg"
+)
+
+// MeasureQuality computes quality metrics of an upscaled video against its
+// source and saves them as "<output>_metrics.json" and "<output>_metrics.csv".
+// It returns the report and the path of the JSON file
+func MeasureQuality(videoPath, outputVideoPath string, options ffmpeg.Options) (*ffmpeg.QualityReport, string, error) {
+	report, err := ffmpeg.ComputeQualityMetrics(videoPath, outputVideoPath, options)
+	if err != nil {
+		return nil, "", err
+	}
+
+	basePath := strings.TrimSuffix(outputVideoPath, filepath.Ext(outputVideoPath)) + "_metrics"
+	if err := WriteQualityReport(report, basePath); err != nil {
+		return nil, "", err
+	}
+
+	return report, basePath + ".json", nil
+}
+
+// WriteQualityReport writes a quality report as basePath.json, with the
+// summary and per-frame values, and basePath.csv, with one row per frame
+func WriteQualityReport(report *ffmpeg.QualityReport, basePath string) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal quality report: %w", err)
+	}
+	if err := os.WriteFile(basePath+".json", data, 0644); err != nil {
+		return fmt.Errorf("failed to write quality report: %w", err)
+	}
+
+	file, err := os.Create(basePath + ".csv")
+	if err != nil {
+		return fmt.Errorf("failed to create quality report: %w", err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"frame", "psnr", "ssim", "vmaf"})
+	for _, frame := range report.Frames {
+		vmaf := ""
+		if frame.VMAF != nil {
+			vmaf = strconv.FormatFloat(*frame.VMAF, 'f', 4, 64)
+		}
+		writer.Write([]string{
+			strconv.Itoa(frame.Frame),
+			strconv.FormatFloat(frame.PSNR, 'f', 4, 64),
+			strconv.FormatFloat(frame.SSIM, 'f', 6, 64),
+			vmaf,
+		})
+	}
+	writer.Flush()
+
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write quality report: %w", err)
+	}
+	return nil
+}
+
+// FormatQualitySummary formats the summary of a quality report for display
+func FormatQualitySummary(report *ffmpeg.QualityReport) string {
+	summary := fmt.Sprintf(
+		"Quality (%d frames at %dx%d):\n"+
+			"  PSNR: %.2f dB (min %.2f)\n"+
+			"  SSIM: %.4f (min %.4f)\n",
+		len(report.Frames), report.Width, report.Height,
+		report.PSNR.Mean, report.PSNR.Min,
+		report.SSIM.Mean, report.SSIM.Min,
+	)
+	if report.VMAF != nil {
+		summary += fmt.Sprintf("  VMAF: %.2f (min %.2f)\n", report.VMAF.Mean, report.VMAF.Min)
+	} else {
+		summary += "  VMAF: not available (ffmpeg built without libvmaf)\n"
+	}
+	if report.ToneMapped {
+		summary += "  Measured against the source tone-mapped to SDR, like the output\n"
+	}
+	return summary
+}
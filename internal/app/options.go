@@ -0,0 +1,29 @@
+package app
+
+import (
+	"videoup/internal/ffmpeg"
+	"videoup/internal/upscaler"
+)
+
+// Options contains the settings for a complete upscaling job
+type Options struct {
+	// Options for the upscaling step
+	Upscaler upscaler.UpscalerOptions
+	// Options for frame extraction and encoding
+	FFmpeg ffmpeg.Options
+	// Options for previews rendered before the full job
+	Preview PreviewOptions
+	// Options for where the output is written
+	Output OutputOptions
+	// Options for splitting the video into scenes
+	Scenes SceneOptions
+}
+
+// DefaultOptions returns default job options
+func DefaultOptions() Options {
+	return Options{
+		Upscaler: upscaler.DefaultOptions(),
+		FFmpeg:   ffmpeg.DefaultOptions(),
+		Output:   OutputOptions{Overwrite: OverwriteIncrement},
+	}
+}
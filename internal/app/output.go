@@ -0,0 +1,164 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"videoup/internal/ffmpeg"
+)
+
+// OverwritePolicy decides what happens when the output file already exists
+type OverwritePolicy string
+
+const (
+	// OverwriteSkip leaves the existing output alone and skips the job
+	OverwriteSkip OverwritePolicy = "skip"
+	// OverwriteReplace replaces the existing output once the new one is done
+	OverwriteReplace OverwritePolicy = "overwrite"
+	// OverwriteIncrement writes to the first free "<name>_2", "<name>_3"...
+	OverwriteIncrement OverwritePolicy = "increment"
+)
+
+// ErrOutputExists is returned by OutputPath when the output exists and the
+// policy is OverwriteSkip
+var ErrOutputExists = errors.New("output already exists")
+
+// DefaultTemplate is the name given to outputs when no template is set
+const DefaultTemplate = "{name}_upscaled.{ext}"
+
+// templateField matches a {field} in a naming template
+var templateField = regexp.MustCompile(`\{([a-z]*)\}`)
+
+// OutputOptions contains the settings for where the output video is written
+type OutputOptions struct {
+	// What to do when the output already exists
+	Overwrite OverwritePolicy
+	// Directory the output is written to. Empty for the source's directory,
+	// relative paths are relative to the source's directory
+	Dir string
+	// Name of the output, with fields such as {name} and {scale} (see
+	// RenderTemplate). Empty for DefaultTemplate
+	Template string
+}
+
+// ParseOverwritePolicy parses an overwrite policy name
+func ParseOverwritePolicy(value string) (OverwritePolicy, error) {
+	switch policy := OverwritePolicy(value); policy {
+	case OverwriteSkip, OverwriteReplace, OverwriteIncrement:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid overwrite policy %q (expected skip, overwrite or increment)", value)
+	}
+}
+
+// OutputPath returns the path the upscaled video is written to, following
+// the output directory, naming template and overwrite policy. With
+// OverwriteSkip it returns the existing path and an error matching
+// ErrOutputExists
+func OutputPath(videoPath string, info *ffmpeg.VideoInfo, options Options) (string, error) {
+	name, err := RenderTemplate(options.Output.Template, videoPath, info, options)
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Dir(videoPath)
+	if options.Output.Dir != "" {
+		dir = options.Output.Dir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(videoPath), dir)
+		}
+	}
+
+	outputPath := filepath.Join(dir, name)
+	if !fileExists(outputPath) {
+		return outputPath, nil
+	}
+
+	switch options.Output.Overwrite {
+	case OverwriteReplace:
+		return outputPath, nil
+	case OverwriteIncrement:
+		ext := filepath.Ext(outputPath)
+		base := strings.TrimSuffix(outputPath, ext)
+		for i := 2; ; i++ {
+			candidate := fmt.Sprintf("%s_%d%s", base, i, ext)
+			if !fileExists(candidate) {
+				return candidate, nil
+			}
+		}
+	default:
+		return outputPath, fmt.Errorf("%w: %s", ErrOutputExists, outputPath)
+	}
+}
+
+// RenderTemplate fills in an output naming template. The fields are:
+//
+//	{name}       source file name without extension
+//	{model}      upscaler model
+//	{scale}      upscale factor
+//	{width}      output width
+//	{height}     output height
+//	{ext}        container extension without the dot (mov or mkv)
+//	{date}       date of the job as YYYY-MM-DD
+//
+// The container must hold ProRes, so the extension is always the
+// container's, added when the template does not end with it
+func RenderTemplate(template, videoPath string, info *ffmpeg.VideoInfo, options Options) (string, error) {
+	if template == "" {
+		template = DefaultTemplate
+	}
+
+	plan, err := PlanOutput(info, options)
+	if err != nil {
+		return "", err
+	}
+
+	baseName := filepath.Base(videoPath)
+	ext := strings.TrimPrefix(options.FFmpeg.Container.Extension(), ".")
+	values := map[string]string{
+		"name":   strings.TrimSuffix(baseName, filepath.Ext(baseName)),
+		"model":  options.Upscaler.Model,
+		"scale":  strconv.Itoa(plan.Scale),
+		"width":  strconv.Itoa(plan.Width),
+		"height": strconv.Itoa(plan.Height),
+		"ext":    ext,
+		"date":   time.Now().Format("2006-01-02"),
+	}
+
+	var unknown []string
+	name := templateField.ReplaceAllStringFunc(template, func(field string) string {
+		key := strings.Trim(field, "{}")
+		value, ok := values[key]
+		if !ok {
+			unknown = append(unknown, field)
+		}
+		return value
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown field(s) in output template: %s", strings.Join(unknown, ", "))
+	}
+
+	if strings.TrimSpace(strings.TrimSuffix(name, "."+ext)) == "" {
+		return "", fmt.Errorf("output template %q gives an empty name", template)
+	}
+	if !strings.HasSuffix(name, "."+ext) {
+		name += "." + ext
+	}
+	// The output goes into the output directory, not next to or below it
+	if filepath.Base(name) != name {
+		return "", fmt.Errorf("output template %q gives a path instead of a file name: %s", template, name)
+	}
+	return name, nil
+}
+
+// fileExists reports whether a file exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
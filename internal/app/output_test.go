@@ -0,0 +1,165 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"videoup/internal/ffmpeg"
+)
+
+// clip is the probe of a small source used by the output tests
+var clip = &ffmpeg.VideoInfo{Width: 640, Height: 360}
+
+func TestOutputPath(t *testing.T) {
+	dir := t.TempDir()
+	videoPath := filepath.Join(dir, "clip.mp4")
+	touch := func(name string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	outputPath := func(policy OverwritePolicy) (string, error) {
+		options := DefaultOptions()
+		options.FFmpeg.Container = ffmpeg.ContainerMOV
+		options.Output.Overwrite = policy
+		return OutputPath(videoPath, clip, options)
+	}
+
+	// Nothing written yet, every policy uses the plain name
+	for _, policy := range []OverwritePolicy{OverwriteSkip, OverwriteReplace, OverwriteIncrement} {
+		if got, err := outputPath(policy); err != nil || got != filepath.Join(dir, "clip_upscaled.mov") {
+			t.Errorf("OutputPath(%s) = %q, %v, want clip_upscaled.mov", policy, got, err)
+		}
+	}
+
+	touch("clip_upscaled.mov")
+	got, err := outputPath(OverwriteSkip)
+	if !errors.Is(err, ErrOutputExists) {
+		t.Errorf("OutputPath(skip) error = %v, want ErrOutputExists", err)
+	}
+	if got != filepath.Join(dir, "clip_upscaled.mov") {
+		t.Errorf("OutputPath(skip) = %q, want the existing output", got)
+	}
+	if got, err := outputPath(OverwriteReplace); err != nil || got != filepath.Join(dir, "clip_upscaled.mov") {
+		t.Errorf("OutputPath(overwrite) = %q, %v, want the existing output", got, err)
+	}
+	if got, err := outputPath(OverwriteIncrement); err != nil || got != filepath.Join(dir, "clip_upscaled_2.mov") {
+		t.Errorf("OutputPath(increment) = %q, %v, want clip_upscaled_2.mov", got, err)
+	}
+
+	// Increment takes the first free number, even with a gap after it
+	touch("clip_upscaled_2.mov")
+	touch("clip_upscaled_4.mov")
+	if got, err := outputPath(OverwriteIncrement); err != nil || got != filepath.Join(dir, "clip_upscaled_3.mov") {
+		t.Errorf("OutputPath(increment) = %q, %v, want clip_upscaled_3.mov", got, err)
+	}
+
+	// Outputs in another container do not count as existing
+	options := DefaultOptions()
+	options.FFmpeg.Container = ffmpeg.ContainerMKV
+	if got, err := OutputPath(videoPath, clip, options); err != nil || got != filepath.Join(dir, "clip_upscaled.mkv") {
+		t.Errorf("OutputPath(mkv) = %q, %v, want clip_upscaled.mkv", got, err)
+	}
+}
+
+func TestOutputPathDir(t *testing.T) {
+	dir := t.TempDir()
+	videoPath := filepath.Join(dir, "in", "clip.mp4")
+
+	options := DefaultOptions()
+	options.FFmpeg.Container = ffmpeg.ContainerMKV
+	options.Output.Template = "{name}_x{scale}"
+
+	// A relative output directory is taken from the source's directory
+	options.Output.Dir = "../out"
+	if got, _ := OutputPath(videoPath, clip, options); got != filepath.Join(dir, "out", "clip_x4.mkv") {
+		t.Errorf("OutputPath(../out) = %q, want %q", got, filepath.Join(dir, "out", "clip_x4.mkv"))
+	}
+
+	options.Output.Dir = filepath.Join(dir, "elsewhere")
+	if got, _ := OutputPath(videoPath, clip, options); got != filepath.Join(dir, "elsewhere", "clip_x4.mkv") {
+		t.Errorf("OutputPath(absolute) = %q, want %q", got, filepath.Join(dir, "elsewhere", "clip_x4.mkv"))
+	}
+
+	options.Output.Template = "{name}_{nope}"
+	if _, err := OutputPath(videoPath, clip, options); err == nil {
+		t.Error("OutputPath() with an invalid template succeeded")
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	options := DefaultOptions()
+	options.Upscaler.Model = "realesrgan-x4plus"
+	options.Upscaler.Scale = 4
+	options.FFmpeg.Container = ffmpeg.ContainerMOV
+	today := time.Now().Format("2006-01-02")
+
+	rendered := map[string]string{
+		"":                               "clip_upscaled.mov",
+		DefaultTemplate:                  "clip_upscaled.mov",
+		"{name}_{scale}x":                "clip_4x.mov",
+		"{name}.mov":                     "clip.mov",
+		"{name}.mp4":                     "clip.mp4.mov",
+		"{name}_{date}":                  "clip_" + today + ".mov",
+		"{model}_{width}x{height}.{ext}": "realesrgan-x4plus_2560x1440.mov",
+	}
+	for template, want := range rendered {
+		got, err := RenderTemplate(template, "/videos/clip.mp4", clip, options)
+		if err != nil || got != want {
+			t.Errorf("RenderTemplate(%q) = %q, %v, want %q", template, got, err, want)
+		}
+	}
+
+	// The extension follows the container
+	options.FFmpeg.Container = ffmpeg.ContainerMKV
+	if got, _ := RenderTemplate("{name}.{ext}", "/videos/clip.mp4", clip, options); got != "clip.mkv" {
+		t.Errorf("RenderTemplate() with mkv = %q, want clip.mkv", got)
+	}
+
+	for template, reason := range map[string]string{
+		"{name}_{fps}": "unknown field",
+		"{}":           "empty name",
+		".{ext}":       "only the extension",
+		"../{name}":    "parent directory",
+		"a/{name}":     "subdirectory",
+	} {
+		if got, err := RenderTemplate(template, "/videos/clip.mp4", clip, options); err == nil {
+			t.Errorf("RenderTemplate(%q) = %q, want an error (%s)", template, got, reason)
+		} else if reason == "unknown field" && !strings.Contains(err.Error(), "{fps}") {
+			t.Errorf("RenderTemplate(%q) error = %v, want it to name the field", template, err)
+		}
+	}
+}
+
+func TestRenderTemplateRoundTrip(t *testing.T) {
+	options := DefaultOptions()
+
+	// A rendered name used as a template renders to itself
+	for _, template := range []string{DefaultTemplate, "{name}_x{scale}", "{model}_{width}x{height}"} {
+		name, err := RenderTemplate(template, "/videos/clip.mp4", clip, options)
+		if err != nil {
+			t.Fatalf("RenderTemplate(%q) error = %v", template, err)
+		}
+		if again, err := RenderTemplate(name, "/videos/clip.mp4", clip, options); err != nil || again != name {
+			t.Errorf("RenderTemplate(%q) = %q, %v, want it unchanged", name, again, err)
+		}
+	}
+}
+
+func TestParseOverwritePolicy(t *testing.T) {
+	for _, value := range []string{"skip", "overwrite", "increment"} {
+		if policy, err := ParseOverwritePolicy(value); err != nil || string(policy) != value {
+			t.Errorf("ParseOverwritePolicy(%q) = %q, %v", value, policy, err)
+		}
+	}
+	for _, value := range []string{"", "replace", "Skip"} {
+		if _, err := ParseOverwritePolicy(value); err == nil {
+			t.Errorf("ParseOverwritePolicy(%q) succeeded, want an error", value)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"videoup/internal/cleanup"
+	"videoup/internal/ffmpeg"
+)
+
+// PreviewOptions contains options for rendering a preview
+type PreviewOptions struct {
+	// Points in the video to preview (frame number, "60.5s" or timecode).
+	// Empty means three points spread over the video
+	Timestamps []string
+	// Number of frames rendered at each point (0 for two seconds)
+	Frames int
+}
+
+// RenderPreview upscales a few frames at each preview point with the job's
+// options and writes a still PNG and a short clip per point into a
+// "<name>_preview" directory next to the video. With a pre-filter it also
+// writes the source before and after the pre-filter. It returns that directory
+func RenderPreview(videoPath string, options Options) (string, error) {
+	info, err := ffmpeg.GetVideoInfo(videoPath)
+	if err != nil {
+		return "", err
+	}
+	if err := ffmpeg.ValidatePreFilter(info, options.FFmpeg); err != nil {
+		return "", err
+	}
+
+	timestamps := options.Preview.Timestamps
+	if len(timestamps) == 0 {
+		// Spread the preview over the video, avoiding intros and credits
+		for _, fraction := range []float64{0.25, 0.5, 0.75} {
+			timestamps = append(timestamps, strconv.Itoa(int(float64(info.TotalFrames)*fraction)))
+		}
+	}
+
+	frames := options.Preview.Frames
+	if frames <= 0 {
+		frames = int(info.FrameRate * 2)
+	}
+	if frames <= 0 {
+		frames = 1
+	}
+
+	// Create the preview output directory next to the video
+	baseName := filepath.Base(videoPath)
+	nameWithoutExt := strings.TrimSuffix(baseName, filepath.Ext(baseName))
+	previewDir := filepath.Join(filepath.Dir(videoPath), nameWithoutExt+"_preview")
+	if err := os.MkdirAll(previewDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create preview directory: %w", err)
+	}
+
+	// Frames are extracted into a temp directory in the working directory,
+	// next to those of jobs, that is removed afterwards
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current working directory: %w", err)
+	}
+	tempDir, err := os.MkdirTemp(cwd, fmt.Sprintf("temp_frames_%s_preview_", nameWithoutExt))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	cleanup.RegisterDirectory(tempDir)
+	defer func() {
+		if err := os.RemoveAll(tempDir); err == nil {
+			cleanup.RemoveDirectory(tempDir)
+		}
+	}()
+
+	for i, timestamp := range timestamps {
+		start, err := ffmpeg.ParseRangePoint(timestamp, info.FrameRate)
+		if err != nil {
+			return "", fmt.Errorf("invalid preview point: %w", err)
+		}
+
+		// Preview a short range starting at this point
+		ffmpegOptions := options.FFmpeg
+		ffmpegOptions.Start = strconv.Itoa(start)
+		ffmpegOptions.End = strconv.Itoa(start + frames)
+		ffmpegOptions.Splice = false
+
+		if !options.FFmpeg.PreFilter.IsEmpty() {
+			stillPath := filepath.Join(previewDir, fmt.Sprintf("prefilter_%02d.png", i+1))
+			if err := ffmpeg.RenderPreFilterStill(videoPath, info, start, stillPath, ffmpegOptions); err != nil {
+				return "", err
+			}
+		}
+
+		framesDir := filepath.Join(tempDir, fmt.Sprintf("point_%02d", i+1))
+		if err := ffmpeg.ExtractFrames(videoPath, framesDir, ffmpegOptions); err != nil {
+			return "", err
+		}
+
+		upscaledDir, err := upscaleDepth(UpscaleScenes, framesDir, nil, options.Upscaler)
+		if err != nil {
+			return "", err
+		}
+
+		// The first upscaled frame is kept as a still
+		stillPath := filepath.Join(previewDir, fmt.Sprintf("preview_%02d.png", i+1))
+		if err := copyFile(filepath.Join(upscaledDir, "frame_0001.png"), stillPath); err != nil {
+			return "", err
+		}
+
+		if frames > 1 {
+			clipPath := filepath.Join(previewDir, fmt.Sprintf("preview_%02d%s", i+1, options.FFmpeg.Container.Extension()))
+			// Replace the clip of an earlier preview
+			if err := os.Remove(clipPath); err != nil && !os.IsNotExist(err) {
+				return "", fmt.Errorf("failed to remove old preview: %w", err)
+			}
+			if err := ffmpeg.CombineFramesToVideo(upscaledDir, clipPath, info, ffmpegOptions); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return previewDir, nil
+}
+
+// copyFile copies a file, replacing the destination if it exists
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dst, err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return out.Close()
+}
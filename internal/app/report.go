@@ -0,0 +1,165 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"videoup/internal/classify"
+	"videoup/internal/ffmpeg"
+	"videoup/internal/upscaler"
+)
+
+// StageTiming is how long one stage of a job took
+type StageTiming struct {
+	Stage   string  `json:"stage"`
+	Seconds float64 `json:"seconds"`
+}
+
+// ToolVersions lists the programs a job ran with
+type ToolVersions struct {
+	Videoup          string `json:"videoup"`
+	FFmpeg           string `json:"ffmpeg"`
+	FFprobe          string `json:"ffprobe"`
+	Realesrgan       string `json:"realesrgan"`
+	RealesrganSHA256 string `json:"realesrgan_sha256"`
+}
+
+// JobReport describes how an output was made. It is written next to the
+// output as "<output>_job.json"
+type JobReport struct {
+	Output  string            `json:"output"`
+	Created time.Time         `json:"created"`
+	Source  *ffmpeg.VideoInfo `json:"source"`
+	Plan    OutputPlan        `json:"plan"`
+	Options Options           `json:"options"`
+	// SHA-256 of the model files, keyed by file name (empty when the models
+	// are not installed on the machine that wrote the report)
+	Models  map[string]string `json:"model_sha256"`
+	Tools   ToolVersions      `json:"tools"`
+	Timings []StageTiming     `json:"timings"`
+	// Scenes with their model and scale, in source frames
+	Scenes []Scene `json:"scenes,omitempty"`
+	// What the sampled frames looked like, when they were analyzed
+	Content *classify.Result `json:"content,omitempty"`
+	// Frames done and failed attempts on each device
+	Devices      []upscaler.DeviceProgress `json:"devices,omitempty"`
+	FailedFrames int                       `json:"failed_frames"`
+	// Source streams the output container could not hold
+	Dropped       []string `json:"dropped,omitempty"`
+	Comparison    string   `json:"comparison,omitempty"`
+	QualityReport string   `json:"quality_report,omitempty"`
+}
+
+// NewJobReport starts the report of a job from its source and options
+func NewJobReport(outputPath string, info *ffmpeg.VideoInfo, options Options) (*JobReport, error) {
+	plan, err := PlanOutput(info, options)
+	if err != nil {
+		return nil, err
+	}
+	return &JobReport{
+		Output:  outputPath,
+		Created: time.Now(),
+		Source:  info,
+		Plan:    plan,
+		Options: options,
+	}, nil
+}
+
+// SetDevices records the final upscaling progress of each device
+func (r *JobReport) SetDevices(devices []upscaler.DeviceProgress) {
+	r.Devices = devices
+	r.FailedFrames = 0
+	for _, device := range devices {
+		r.FailedFrames += device.Failed
+	}
+}
+
+// SidecarPath returns the path of the job report for an output
+func SidecarPath(outputPath string) string {
+	return strings.TrimSuffix(outputPath, filepath.Ext(outputPath)) + "_job.json"
+}
+
+// WriteJobReport fills in the model hashes and tool versions and writes the
+// report next to its output, returning the path of the report
+func WriteJobReport(report *JobReport) (string, error) {
+	// Left empty when realesrgan is not installed here, as on a coordinator
+	// whose workers did the upscaling
+	report.Models, _ = upscaler.ModelHashes(report.Plan.Passes)
+	report.Tools = toolVersions()
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal job report: %w", err)
+	}
+	path := SidecarPath(report.Output)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write job report: %w", err)
+	}
+	return path, nil
+}
+
+// toolVersions returns the versions of the programs a job uses. Tools that
+// cannot be queried are left empty
+func toolVersions() ToolVersions {
+	tools := ToolVersions{Videoup: videoupVersion()}
+	if build, err := ffmpeg.GetBuildInfo("ffmpeg"); err == nil {
+		tools.FFmpeg = build.Version
+	}
+	if build, err := ffmpeg.GetBuildInfo("ffprobe"); err == nil {
+		tools.FFprobe = build.Version
+	}
+	// realesrgan has no version flag, its hash identifies the build
+	if path, err := upscaler.RealesrganPath(); err == nil {
+		tools.Realesrgan = path
+		tools.RealesrganSHA256, _ = upscaler.FileHash(path)
+	}
+	return tools
+}
+
+// videoupVersion returns the module version, or the VCS revision for
+// development builds
+func videoupVersion() string {
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	if build.Main.Version != "" && build.Main.Version != "(devel)" {
+		return build.Main.Version
+	}
+	for _, setting := range build.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return build.Main.Version
+}
+
+// ProvenanceTags returns the container tags that record how an output was
+// made, the key fields of the job report
+func ProvenanceTags(info *ffmpeg.VideoInfo, options Options) (map[string]string, error) {
+	plan, err := PlanOutput(info, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var passes []string
+	for _, pass := range plan.Passes {
+		passes = append(passes, pass.String())
+	}
+
+	return map[string]string{
+		"comment":          fmt.Sprintf("Upscaled by videoup from %s: %s", info.FileName, plan),
+		"videoup_version":  videoupVersion(),
+		"videoup_source":   info.FileName,
+		"videoup_model":    strings.Join(passes, ", "),
+		"videoup_scale":    strconv.Itoa(plan.Scale),
+		"videoup_upscaled": fmt.Sprintf("%dx%d", plan.UpscaledWidth, plan.UpscaledHeight),
+		"creation_time":    time.Now().UTC().Format(time.RFC3339),
+	}, nil
+}
@@ -0,0 +1,372 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"videoup/internal/cleanup"
+	"videoup/internal/errs"
+	"videoup/internal/ffmpeg"
+	"videoup/internal/upscaler"
+)
+
+// DefaultSceneThreshold is the scene score used by "videoup scenes" when
+// none is given. Hard cuts score well above it, fades and pans below
+const DefaultSceneThreshold = 0.3
+
+// minSceneFrames is the shortest scene detection produces. Flashes and fast
+// cuts shorter than this are merged into the scene before them
+const minSceneFrames = 12
+
+// SceneOptions contains options for splitting a video into scenes
+type SceneOptions struct {
+	// Scene score (0-1) a frame must exceed to start a new scene, 0 to not
+	// detect scenes
+	Threshold float64
+	// Scene file with per-scene models and scales, as written by
+	// "videoup scenes" (empty for none). It is used instead of detection
+	File string
+}
+
+// Scene is a run of source frames from Start up to End (exclusive). Model
+// and Scale override the job's settings for the scene when set
+type Scene struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+	// Timecode of the first frame, to find the scene in a player
+	Time  string `json:"time,omitempty"`
+	Model string `json:"model,omitempty"`
+	Scale int    `json:"scale,omitempty"`
+}
+
+// Frames returns the number of frames in the scene
+func (s Scene) Frames() int {
+	return s.End - s.Start
+}
+
+// HasOverride reports whether the scene is upscaled differently from the job
+func (s Scene) HasOverride() bool {
+	return s.Model != "" || s.Scale != 0
+}
+
+// Upscaler returns the upscaler options for the scene
+func (s Scene) Upscaler(options upscaler.UpscalerOptions) upscaler.UpscalerOptions {
+	if s.Model != "" {
+		options.Model = s.Model
+	}
+	if s.Scale != 0 {
+		options.Scale = s.Scale
+	}
+	return options
+}
+
+// SceneList is the content of a scene file
+type SceneList struct {
+	Video     string  `json:"video"`
+	FrameRate float64 `json:"frame_rate"`
+	Threshold float64 `json:"threshold,omitempty"`
+	Scenes    []Scene `json:"scenes"`
+}
+
+// ScenesPath returns the default scene file of a video
+func ScenesPath(videoPath string) string {
+	return strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + "_scenes.json"
+}
+
+// DetectScenes splits the selected range of a video into scenes at the cuts
+// ffmpeg finds with the given threshold
+func DetectScenes(videoPath string, info *ffmpeg.VideoInfo, threshold float64, options ffmpeg.Options) ([]Scene, error) {
+	start, end, err := ffmpeg.ResolveRange(info, options)
+	if err != nil {
+		return nil, err
+	}
+	cuts, err := ffmpeg.SceneCuts(videoPath, info, threshold, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var scenes []Scene
+	sceneStart := start
+	for _, cut := range cuts {
+		if cut <= sceneStart || cut >= end || cut-sceneStart < minSceneFrames {
+			continue
+		}
+		scenes = append(scenes, Scene{Start: sceneStart, End: cut})
+		sceneStart = cut
+	}
+	scenes = append(scenes, Scene{Start: sceneStart, End: end})
+
+	// A short last scene goes to the one before it
+	if n := len(scenes); n > 1 && scenes[n-1].Frames() < minSceneFrames {
+		scenes[n-2].End = end
+		scenes = scenes[:n-1]
+	}
+
+	for i := range scenes {
+		scenes[i].Time = frameTimecode(scenes[i].Start, info.FrameRate)
+	}
+	return scenes, nil
+}
+
+// frameTimecode returns the time of a frame as "HH:MM:SS.mmm"
+func frameTimecode(frame int, frameRate float64) string {
+	if frameRate <= 0 {
+		return ""
+	}
+	millis := int64(float64(frame) / frameRate * 1000)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", millis/3600000, millis/60000%60, millis/1000%60, millis%1000)
+}
+
+// LoadScenes reads a scene file
+func LoadScenes(path string) (*SceneList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scene file: %w", err)
+	}
+	var list SceneList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid scene file %s: %w", path, err)
+	}
+	return &list, nil
+}
+
+// WriteScenes writes a scene file
+func WriteScenes(path string, list *SceneList) error {
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal scenes: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write scene file: %w", err)
+	}
+	return nil
+}
+
+// FindScenes returns the scenes of the selected range of a video, from the
+// scene file or by detection. Scenes are clipped to the range and frames no
+// scene covers get one with the job's settings. Without scene options it
+// returns nil
+func FindScenes(videoPath string, info *ffmpeg.VideoInfo, options Options) ([]Scene, error) {
+	start, end, err := ffmpeg.ResolveRange(info, options.FFmpeg)
+	if err != nil {
+		return nil, err
+	}
+
+	var scenes []Scene
+	switch {
+	case options.Scenes.File != "":
+		list, err := LoadScenes(options.Scenes.File)
+		if err != nil {
+			return nil, err
+		}
+		scenes = list.Scenes
+	case options.Scenes.Threshold > 0:
+		if scenes, err = DetectScenes(videoPath, info, options.Scenes.Threshold, options.FFmpeg); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+
+	scenes, err = fitScenes(scenes, start, end, info.FrameRate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Catch models and scales that cannot be upscaled before extracting
+	for _, scene := range scenes {
+		if !scene.HasOverride() {
+			continue
+		}
+		if scene.Model == ModelAuto {
+			return nil, fmt.Errorf("scene at frame %d: the model of a scene cannot be auto", scene.Start)
+		}
+		if _, err := upscaler.PlanPasses(scene.Upscaler(options.Upscaler)); err != nil {
+			return nil, fmt.Errorf("scene at frame %d: %w", scene.Start, err)
+		}
+	}
+	return scenes, nil
+}
+
+// fitScenes sorts scenes, clips them to start-end and fills the gaps between
+// them. Overlapping scenes are an error
+func fitScenes(scenes []Scene, start, end int, frameRate float64) ([]Scene, error) {
+	sorted := append([]Scene(nil), scenes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var fitted []Scene
+	next := start
+	for _, scene := range sorted {
+		if scene.End <= scene.Start {
+			return nil, fmt.Errorf("scene at frame %d ends before it starts", scene.Start)
+		}
+		if scene.Scale < 0 || scene.Scale == 1 {
+			return nil, fmt.Errorf("scene at frame %d: invalid scale %d", scene.Start, scene.Scale)
+		}
+		scene.Start, scene.End = max(scene.Start, start), min(scene.End, end)
+		if scene.Start >= scene.End {
+			// Outside the range
+			continue
+		}
+		if scene.Start < next {
+			return nil, fmt.Errorf("scene at frame %d overlaps the scene before it", scene.Start)
+		}
+		if scene.Start > next {
+			fitted = append(fitted, Scene{Start: next, End: scene.Start})
+		}
+		fitted = append(fitted, scene)
+		next = scene.End
+	}
+	if next < end {
+		fitted = append(fitted, Scene{Start: next, End: end})
+	}
+
+	for i := range fitted {
+		fitted[i].Time = frameTimecode(fitted[i].Start, frameRate)
+	}
+	return fitted, nil
+}
+
+// FrameScenes renumbers scenes of source frames to the extracted frames of a
+// range starting at start, whose first frame file is frame_0001.png
+func FrameScenes(scenes []Scene, start int) []Scene {
+	if scenes == nil {
+		return nil
+	}
+	framed := make([]Scene, len(scenes))
+	for i, scene := range scenes {
+		scene.Start += 1 - start
+		scene.End += 1 - start
+		framed[i] = scene
+	}
+	return framed
+}
+
+// HasSceneOverrides reports whether any scene is upscaled differently from the job
+func HasSceneOverrides(scenes []Scene) bool {
+	for _, scene := range scenes {
+		if scene.HasOverride() {
+			return true
+		}
+	}
+	return false
+}
+
+// UpscaleScenes upscales the frames in a directory, each scene with its own
+// model and scale. Scenes are numbered by frame file (see FrameScenes). The
+// frames of scenes with another scale are resized to the job's output size
+func UpscaleScenes(inputDir string, scenes []Scene, options upscaler.UpscalerOptions) (string, error) {
+	if !HasSceneOverrides(scenes) {
+		return UpscaleFrames(inputDir, options)
+	}
+
+	upscaledDir, err := upscaler.CreateUpscaledDir(inputDir)
+	if err != nil {
+		return "", err
+	}
+	cleanup.RegisterDirectory(upscaledDir)
+
+	// Consecutive scenes with the same settings are upscaled together. The
+	// frames are linked into a directory per group, inside inputDir so they
+	// are removed with it
+	groups := groupScenes(scenes)
+	for i, group := range groups {
+		groupOptions := group.Upscaler(options)
+		fmt.Printf("Scene group %d/%d: frames %d-%d with %s x%d\n", i+1, len(groups), group.Start, group.End-1, groupOptions.Model, groupOptions.Scale)
+
+		groupDir := filepath.Join(inputDir, "scenes", fmt.Sprintf("%03d", i+1))
+		if err := linkFrames(inputDir, groupDir, group.Start, group.End); err != nil {
+			return "", err
+		}
+		err := upscaler.UpscaleFrames(groupDir, upscaledDir, groupOptions)
+		os.RemoveAll(groupDir)
+		if err != nil {
+			return "", errs.Upscale(err)
+		}
+	}
+
+	if err := ResizeScenes(inputDir, upscaledDir, scenes, options); err != nil {
+		return "", err
+	}
+
+	info, err := ffmpeg.ReadVideoInfo(inputDir)
+	if err != nil {
+		return "", err
+	}
+	if info.HasAlpha {
+		return upscaleAlpha(inputDir, upscaledDir, options)
+	}
+
+	return upscaledDir, nil
+}
+
+// groupScenes merges consecutive scenes with the same settings
+func groupScenes(scenes []Scene) []Scene {
+	var groups []Scene
+	for _, scene := range scenes {
+		if n := len(groups); n > 0 && groups[n-1].End == scene.Start &&
+			groups[n-1].Model == scene.Model && groups[n-1].Scale == scene.Scale {
+			groups[n-1].End = scene.End
+			continue
+		}
+		groups = append(groups, scene)
+	}
+	return groups
+}
+
+// linkFrames links the frame files first up to end (exclusive) of inputDir
+// into outputDir, copying them where links are not supported
+func linkFrames(inputDir, outputDir string, first, end int) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create scene directory: %w", err)
+	}
+	for frame := first; frame < end; frame++ {
+		name := fmt.Sprintf("frame_%04d.png", frame)
+		src, dst := filepath.Join(inputDir, name), filepath.Join(outputDir, name)
+		if err := os.Link(src, dst); err != nil {
+			if err := copyFile(src, dst); err != nil {
+				return fmt.Errorf("failed to link %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ResizeScenes resizes the upscaled frames of scenes with another scale than
+// the job to the size of the others. Scenes are numbered by frame file
+func ResizeScenes(inputDir, upscaledDir string, scenes []Scene, options upscaler.UpscalerOptions) error {
+	width, height, err := ffmpeg.FrameSize(inputDir)
+	if err != nil {
+		return err
+	}
+	width, height = width*options.Scale, height*options.Scale
+
+	for _, scene := range scenes {
+		if scene.Scale == 0 || scene.Scale == options.Scale {
+			continue
+		}
+		fmt.Printf("Resizing frames %d-%d from x%d to %dx%d\n", scene.Start, scene.End-1, scene.Scale, width, height)
+
+		// Move the frames aside, the resized ones take their place
+		resizeDir := filepath.Join(inputDir, "resize")
+		if err := os.MkdirAll(resizeDir, 0755); err != nil {
+			return fmt.Errorf("failed to create resize directory: %w", err)
+		}
+		for frame := scene.Start; frame < scene.End; frame++ {
+			name := fmt.Sprintf("frame_%04d.png", frame)
+			if err := os.Rename(filepath.Join(upscaledDir, name), filepath.Join(resizeDir, name)); err != nil {
+				return fmt.Errorf("failed to move %s: %w", name, err)
+			}
+		}
+		err := ffmpeg.ResizeFrames(resizeDir, upscaledDir, scene.Start, scene.Frames(), width, height, "lanczos", ffmpeg.Options{Context: options.Context})
+		os.RemoveAll(resizeDir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"videoup/internal/ffmpeg"
+)
+
+func TestFitScenes(t *testing.T) {
+	anime := "realesr-animevideov3"
+
+	// Gaps before, between and after the scenes get the job's settings
+	got, err := fitScenes([]Scene{{Start: 50, End: 60, Model: anime}, {Start: 10, End: 20, Scale: 2}}, 0, 100, 0)
+	if err != nil {
+		t.Fatalf("fitScenes() error = %v", err)
+	}
+	want := []Scene{
+		{Start: 0, End: 10},
+		{Start: 10, End: 20, Scale: 2},
+		{Start: 20, End: 50},
+		{Start: 50, End: 60, Model: anime},
+		{Start: 60, End: 100},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fitScenes() = %v\nwant %v", got, want)
+	}
+
+	// Scenes are clipped to the range and dropped when outside it
+	got, _ = fitScenes([]Scene{{Start: 0, End: 10, Scale: 3}, {Start: 10, End: 30, Scale: 2}, {Start: 30, End: 80}, {Start: 80, End: 120, Scale: 4}}, 20, 90, 0)
+	want = []Scene{{Start: 20, End: 30, Scale: 2}, {Start: 30, End: 80}, {Start: 80, End: 90, Scale: 4}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("fitScenes() of a range = %v\nwant %v", got, want)
+	}
+
+	// No scenes is one scene with the job's settings
+	if got, _ := fitScenes(nil, 5, 40, 0); !reflect.DeepEqual(got, []Scene{{Start: 5, End: 40}}) {
+		t.Errorf("fitScenes(nil) = %v, want one scene", got)
+	}
+
+	// The first frame of each scene gets a timecode at 25 fps
+	got, _ = fitScenes([]Scene{{Start: 50, End: 100}}, 0, 100, 25)
+	if len(got) != 2 || got[0].Time != "00:00:00.000" || got[1].Time != "00:00:02.000" {
+		t.Errorf("fitScenes() timecodes = %v", got)
+	}
+
+	for reason, scenes := range map[string][]Scene{
+		"overlap":         {{Start: 0, End: 30}, {Start: 20, End: 40}},
+		"empty scene":     {{Start: 30, End: 30}},
+		"backwards scene": {{Start: 30, End: 10}},
+		"scale 1":         {{Start: 0, End: 40, Scale: 1}},
+		"negative scale":  {{Start: 0, End: 40, Scale: -2}},
+	} {
+		if got, err := fitScenes(scenes, 0, 40, 0); err == nil {
+			t.Errorf("fitScenes() with %s = %v, want an error", reason, got)
+		}
+	}
+}
+
+func TestFrameScenes(t *testing.T) {
+	scenes := []Scene{{Start: 100, End: 150, Model: "realesrgan-x4plus"}, {Start: 150, End: 200}}
+	got := FrameScenes(scenes, 100)
+	want := []Scene{{Start: 1, End: 51, Model: "realesrgan-x4plus"}, {Start: 51, End: 101}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FrameScenes() = %v, want %v", got, want)
+	}
+	if scenes[0].Start != 100 {
+		t.Errorf("FrameScenes() changed its input to %v", scenes)
+	}
+	if got := FrameScenes([]Scene{{Start: 0, End: 10}}, 0); got[0].Start != 1 || got[0].End != 11 {
+		t.Errorf("FrameScenes() from the first frame = %v, want frames 1-11", got)
+	}
+	if FrameScenes(nil, 10) != nil {
+		t.Error("FrameScenes(nil) is not nil")
+	}
+}
+
+func TestGroupScenes(t *testing.T) {
+	anime := Scene{Model: "realesr-animevideov3"}
+	at := func(scene Scene, start, end int) Scene {
+		scene.Start, scene.End = start, end
+		return scene
+	}
+
+	got := groupScenes([]Scene{
+		at(Scene{}, 1, 10), at(Scene{}, 10, 20),
+		at(Scene{Scale: 2}, 20, 30), at(Scene{Scale: 2}, 30, 40),
+		at(anime, 40, 50), at(Scene{}, 50, 60), at(anime, 60, 70),
+		at(anime, 75, 80),
+	})
+	want := []Scene{
+		at(Scene{}, 1, 20),
+		at(Scene{Scale: 2}, 20, 40),
+		at(anime, 40, 50), at(Scene{}, 50, 60), at(anime, 60, 70),
+		// Not joined across the gap
+		at(anime, 75, 80),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groupScenes() = %v\nwant %v", got, want)
+	}
+}
+
+func TestFindScenesFromFile(t *testing.T) {
+	dir := t.TempDir()
+	info := &ffmpeg.VideoInfo{Width: 640, Height: 360, FrameRate: 24, TotalFrames: 240}
+	options := DefaultOptions()
+	options.Upscaler.Model = "realesrgan-x4plus"
+	options.Upscaler.Scale = 4
+	options.FFmpeg.Start, options.FFmpeg.End = "48", "192"
+
+	// A scene file written by "videoup scenes" and edited by hand
+	options.Scenes.File = filepath.Join(dir, "clip_scenes.json")
+	list := &SceneList{Video: "clip.mp4", FrameRate: 24, Scenes: []Scene{
+		{Start: 0, End: 96},
+		{Start: 96, End: 144, Model: "realesr-animevideov3", Scale: 2},
+		{Start: 144, End: 240},
+	}}
+	if err := WriteScenes(options.Scenes.File, list); err != nil {
+		t.Fatal(err)
+	}
+
+	scenes, err := FindScenes(filepath.Join(dir, "clip.mp4"), info, options)
+	if err != nil {
+		t.Fatalf("FindScenes() error = %v", err)
+	}
+	want := []Scene{
+		{Start: 48, End: 96, Time: "00:00:02.000"},
+		{Start: 96, End: 144, Time: "00:00:04.000", Model: "realesr-animevideov3", Scale: 2},
+		{Start: 144, End: 192, Time: "00:00:06.000"},
+	}
+	if !reflect.DeepEqual(scenes, want) {
+		t.Errorf("FindScenes() = %v\nwant %v", scenes, want)
+	}
+
+	// A scene the model cannot reach is caught before any work is done
+	list.Scenes[1].Model = "realesrgan-x4plus"
+	if err := WriteScenes(options.Scenes.File, list); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindScenes(filepath.Join(dir, "clip.mp4"), info, options); err == nil || !strings.Contains(err.Error(), "frame 96") {
+		t.Errorf("FindScenes() with an unreachable scale error = %v, want it to name the scene", err)
+	}
+
+	if err := os.WriteFile(options.Scenes.File, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindScenes(filepath.Join(dir, "clip.mp4"), info, options); err == nil {
+		t.Error("FindScenes() with a broken scene file succeeded")
+	}
+
+	// Without a scene file or threshold there are no scenes
+	options.Scenes = SceneOptions{}
+	if scenes, err := FindScenes(filepath.Join(dir, "clip.mp4"), info, options); scenes != nil || err != nil {
+		t.Errorf("FindScenes() without scene options = %v, %v", scenes, err)
+	}
+}
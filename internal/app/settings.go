@@ -0,0 +1,359 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"videoup/internal/ffmpeg"
+	"videoup/internal/ui"
+	"videoup/internal/upscaler"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// settingField is an option that can be edited on the settings screen
+type settingField struct {
+	label string
+	hint  string
+	input textinput.Model
+	apply func(options *Options, value string) error
+}
+
+// settingsForm is the settings screen shown after a video is selected
+type settingsForm struct {
+	fields  []settingField
+	focused int
+}
+
+// newSettingsForm creates a settings form filled in with the given options
+func newSettingsForm(options Options) settingsForm {
+	fields := []settingField{
+		{
+			label: "Start",
+			hint:  "frame number, 60.5s or HH:MM:SS.mmm (blank for the beginning)",
+			apply: func(options *Options, value string) error {
+				options.FFmpeg.Start = value
+				return nil
+			},
+		},
+		{
+			label: "End",
+			hint:  "frame number, 60.5s or HH:MM:SS.mmm (blank for the end)",
+			apply: func(options *Options, value string) error {
+				options.FFmpeg.End = value
+				return nil
+			},
+		},
+		{
+			label: "Splice",
+			hint:  "y/n, put the upscaled range back into a full-length video",
+			apply: func(options *Options, value string) error {
+				splice, err := parseYesNo(value)
+				options.FFmpeg.Splice = splice
+				return err
+			},
+		},
+		{
+			label: "Pre-filter",
+			hint:  "preset (" + strings.Join(ffmpeg.PreFilterPresets(), ", ") + ") and/or yadif, bwdif, hqdn3d:strong, deblock, crop=W:H:X:Y",
+			apply: func(options *Options, value string) error {
+				preFilter, err := ffmpeg.ParsePreFilter(value)
+				options.FFmpeg.PreFilter = preFilter
+				return err
+			},
+		},
+		{
+			label: "Model",
+			hint:  "realesrgan model, or auto to use the recommendation above",
+			apply: func(options *Options, value string) error {
+				if value == "" {
+					return fmt.Errorf("expected a model or auto")
+				}
+				options.Upscaler.Model = value
+				return nil
+			},
+		},
+		{
+			label: "Scale",
+			hint:  "upscale factor, above 4 runs several passes (ignored with a target)",
+			apply: func(options *Options, value string) error {
+				scale, err := parseInt(value)
+				options.Upscaler.Scale = scale
+				return err
+			},
+		},
+		{
+			label: "Later models",
+			hint:  "comma separated models for the passes after the first (blank for the same model)",
+			apply: func(options *Options, value string) error {
+				options.Upscaler.IntermediateModels = splitList(value)
+				return nil
+			},
+		},
+		{
+			label: "Scene threshold",
+			hint:  "scene change score 0-1 that starts a new scene, e.g. 0.3 (0 to not split)",
+			apply: func(options *Options, value string) error {
+				threshold, err := parseFloat(value)
+				if err == nil && threshold >= 1 {
+					err = fmt.Errorf("expected a score below 1, got %q", value)
+				}
+				options.Scenes.Threshold = threshold
+				return err
+			},
+		},
+		{
+			label: "Scene file",
+			hint:  "scene file with per-scene models and scales, from videoup scenes (blank for none)",
+			apply: func(options *Options, value string) error {
+				options.Scenes.File = value
+				return nil
+			},
+		},
+		{
+			label: "Tile size",
+			hint:  "realesrgan tile size, lower uses less GPU memory (0 for auto)",
+			apply: func(options *Options, value string) error {
+				tileSize, err := parseInt(value)
+				options.Upscaler.TileSize = tileSize
+				return err
+			},
+		},
+		{
+			label: "Threads",
+			hint:  "realesrgan threads as load:proc:save, e.g. 1:2:2 (blank for the default)",
+			apply: func(options *Options, value string) error {
+				options.Upscaler.Threads = value
+				return upscaler.ValidateThreads(value)
+			},
+		},
+		{
+			label: "GPUs",
+			hint:  "comma separated GPU IDs with optional weights, e.g. 0:2,1 (-1 for CPU)",
+			apply: func(options *Options, value string) error {
+				gpus, err := upscaler.ParseGPUs(value)
+				if err == nil {
+					options.Upscaler.GPUs = gpus
+				}
+				return err
+			},
+		},
+		{
+			label: "Target",
+			hint:  "output resolution, e.g. 3840x2160, 4k or 1440p (blank to keep the scale)",
+			apply: func(options *Options, value string) error {
+				options.FFmpeg.TargetWidth, options.FFmpeg.TargetHeight = 0, 0
+				if value == "" {
+					return nil
+				}
+				width, height, err := ffmpeg.ParseResolution(value)
+				options.FFmpeg.TargetWidth, options.FFmpeg.TargetHeight = width, height
+				return err
+			},
+		},
+		{
+			label: "Target mode",
+			hint:  "fit (pad to the target) or fill (crop to the target)",
+			apply: func(options *Options, value string) error {
+				mode := ffmpeg.TargetMode(value)
+				if mode != ffmpeg.TargetFit && mode != ffmpeg.TargetFill {
+					return fmt.Errorf("expected fit or fill, got %q", value)
+				}
+				options.FFmpeg.TargetMode = mode
+				return nil
+			},
+		},
+		{
+			label: "Output dir",
+			hint:  "directory for the output, relative to the video's (blank for the video's directory)",
+			apply: func(options *Options, value string) error {
+				options.Output.Dir = value
+				return nil
+			},
+		},
+		{
+			label: "Output name",
+			hint:  "fields: {name} {model} {scale} {width} {height} {ext} {date}",
+			apply: func(options *Options, value string) error {
+				options.Output.Template = value
+				return nil
+			},
+		},
+		{
+			label: "If output exists",
+			hint:  "skip, overwrite or increment (write <name>_upscaled_2...)",
+			apply: func(options *Options, value string) error {
+				policy, err := ParseOverwritePolicy(value)
+				if err == nil {
+					options.Output.Overwrite = policy
+				}
+				return err
+			},
+		},
+		{
+			label: "Preview at",
+			hint:  "comma separated points to preview (blank for 25%, 50% and 75%)",
+			apply: func(options *Options, value string) error {
+				options.Preview.Timestamps = splitList(value)
+				return nil
+			},
+		},
+		{
+			label: "Preview frames",
+			hint:  "frames rendered at each preview point (0 for two seconds)",
+			apply: func(options *Options, value string) error {
+				frames, err := parseInt(value)
+				options.Preview.Frames = frames
+				return err
+			},
+		},
+	}
+
+	values := []string{
+		options.FFmpeg.Start,
+		options.FFmpeg.End,
+		formatYesNo(options.FFmpeg.Splice),
+		options.FFmpeg.PreFilter.String(),
+		options.Upscaler.Model,
+		strconv.Itoa(options.Upscaler.Scale),
+		strings.Join(options.Upscaler.IntermediateModels, ","),
+		strconv.FormatFloat(options.Scenes.Threshold, 'f', -1, 64),
+		options.Scenes.File,
+		strconv.Itoa(options.Upscaler.TileSize),
+		options.Upscaler.Threads,
+		upscaler.FormatGPUs(options.Upscaler.GPUs),
+		formatResolution(options.FFmpeg.TargetWidth, options.FFmpeg.TargetHeight),
+		string(options.FFmpeg.TargetMode),
+		options.Output.Dir,
+		options.Output.Template,
+		string(options.Output.Overwrite),
+		strings.Join(options.Preview.Timestamps, ","),
+		strconv.Itoa(options.Preview.Frames),
+	}
+
+	for i := range fields {
+		input := textinput.New()
+		input.Prompt = ""
+		input.SetValue(values[i])
+		fields[i].input = input
+	}
+	fields[0].input.Focus()
+
+	return settingsForm{fields: fields}
+}
+
+// Update moves the focus between fields and passes other keys to the
+// focused field
+func (f settingsForm) Update(msg tea.Msg) (settingsForm, tea.Cmd) {
+	if keyMsg, ok := msg.(tea.KeyMsg); ok {
+		switch keyMsg.String() {
+		case "up", "shift+tab":
+			f.focus(f.focused - 1)
+			return f, nil
+		case "down", "tab":
+			f.focus(f.focused + 1)
+			return f, nil
+		}
+	}
+
+	var cmd tea.Cmd
+	f.fields[f.focused].input, cmd = f.fields[f.focused].input.Update(msg)
+	return f, cmd
+}
+
+// focus moves the focus to the given field, wrapping around at the ends
+func (f *settingsForm) focus(index int) {
+	f.fields[f.focused].input.Blur()
+	f.focused = (index + len(f.fields)) % len(f.fields)
+	f.fields[f.focused].input.Focus()
+}
+
+// Apply returns a copy of options with the values entered in the form
+func (f settingsForm) Apply(options Options) (Options, error) {
+	for _, field := range f.fields {
+		if err := field.apply(&options, strings.TrimSpace(field.input.Value())); err != nil {
+			return options, fmt.Errorf("%s: %w", field.label, err)
+		}
+	}
+	return options, nil
+}
+
+// View renders the form
+func (f settingsForm) View() string {
+	var b strings.Builder
+	for i, field := range f.fields {
+		cursor := "  "
+		if i == f.focused {
+			cursor = "> "
+		}
+		b.WriteString(fmt.Sprintf("%s%-16s %s\n", cursor, field.label+":", field.input.View()))
+		if i == f.focused {
+			b.WriteString(ui.FormatInfo(strings.Repeat(" ", 19)+field.hint) + "\n")
+		}
+	}
+	return b.String()
+}
+
+// parseYesNo parses a y/n answer, treating an empty answer as no
+func parseYesNo(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "y", "yes", "true":
+		return true, nil
+	case "", "n", "no", "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("expected y or n, got %q", value)
+}
+
+// parseInt parses a non-negative number, treating an empty value as zero
+func parseInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a non-negative number, got %q", value)
+	}
+	return n, nil
+}
+
+// parseFloat parses a non-negative number, treating an empty value as zero
+func parseFloat(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a non-negative number, got %q", value)
+	}
+	return n, nil
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// formatResolution formats a target resolution, empty when there is none
+func formatResolution(width, height int) string {
+	if width == 0 && height == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", width, height)
+}
+
+// formatYesNo formats a boolean as a y/n answer
+func formatYesNo(value bool) string {
+	if value {
+		return "y"
+	}
+	return "n"
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+
+	"videoup/internal/ffmpeg"
+	"videoup/internal/upscaler"
+)
+
+// OutputPlan describes the frames and the video a job produces
+type OutputPlan struct {
+	// Scale passed to the upscaler
+	Scale int `json:"scale"`
+	// Upscaler passes that make up the scale
+	Passes []upscaler.ModelScale `json:"passes"`
+	// Size of the upscaled frames
+	UpscaledWidth  int `json:"upscaled_width"`
+	UpscaledHeight int `json:"upscaled_height"`
+	// Size of the output video
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// String describes the plan for display
+func (p OutputPlan) String() string {
+	upscale := fmt.Sprintf("%dx upscale", p.Scale)
+	if len(p.Passes) > 1 {
+		var passes []string
+		for _, pass := range p.Passes {
+			passes = append(passes, pass.String())
+		}
+		upscale += " (" + strings.Join(passes, ", then ") + ")"
+	}
+
+	if p.Width == p.UpscaledWidth && p.Height == p.UpscaledHeight {
+		return fmt.Sprintf("%s to %dx%d", upscale, p.Width, p.Height)
+	}
+	return fmt.Sprintf("%s to %dx%d, resampled to %dx%d",
+		upscale, p.UpscaledWidth, p.UpscaledHeight, p.Width, p.Height)
+}
+
+// PlanOutput works out the upscaler passes and output size of a job. With a
+// target resolution, the smallest scale the models support that reaches the
+// target is used so the final resample only ever scales down
+func PlanOutput(info *ffmpeg.VideoInfo, options Options) (OutputPlan, error) {
+	if err := ffmpeg.ValidatePreFilter(info, options.FFmpeg); err != nil {
+		return OutputPlan{}, err
+	}
+
+	scale := options.Upscaler.Scale
+	if options.FFmpeg.HasTarget() {
+		scale = chooseScale(ffmpeg.TargetFactor(info, options.FFmpeg), upscaler.SupportedScales(options.Upscaler))
+	}
+
+	upscalerOptions := options.Upscaler
+	upscalerOptions.Scale = scale
+	passes, err := upscaler.PlanPasses(upscalerOptions)
+	if err != nil {
+		return OutputPlan{}, err
+	}
+
+	// The upscaler gets the frames as cropped by the pre-filter
+	width, height := ffmpeg.SourceSize(info, options.FFmpeg)
+	plan := OutputPlan{
+		Scale:          scale,
+		Passes:         passes,
+		UpscaledWidth:  width * scale,
+		UpscaledHeight: height * scale,
+	}
+	plan.Width, plan.Height = plan.UpscaledWidth, plan.UpscaledHeight
+	if options.FFmpeg.HasTarget() {
+		_, _, plan.Width, plan.Height = ffmpeg.TargetSize(info, options.FFmpeg)
+	}
+
+	return plan, nil
+}
+
+// chooseScale returns the smallest scale that reaches the factor, or the
+// largest scale if none does
+func chooseScale(factor float64, scales []int) int {
+	best := 0
+	for _, scale := range scales {
+		if float64(scale) >= factor && (best == 0 || scale < best) {
+			best = scale
+		}
+	}
+	if best != 0 {
+		return best
+	}
+	for _, scale := range scales {
+		if scale > best {
+			best = scale
+		}
+	}
+	return best
+}
@@ -1,12 +1,12 @@
 package app
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"os"
-	"path/filepath"
 
+	"videoup/internal/classify"
 	"videoup/internal/cleanup"
+	"videoup/internal/errs"
 	"videoup/internal/ffmpeg"
 	"videoup/internal/filepicker"
 	"videoup/internal/ui"
@@ -15,29 +15,33 @@ import (
 	tea "github.com/charmbracelet/bubbletea"
 )
 
-// UIModel represents the application UI state
+// UIModel represents the application UI state. The job itself runs through
+// RunJobWith, the UI shows its stages and result
 type UIModel struct {
-	filepicker      filepicker.Model
-	state           string // "picking", "processing", "upscaling", "combining", "done", "error", "cleaning"
-	videoPath       string
-	outputDir       string
-	upscaledDir     string
-	outputVideoPath string
-	upscalerOptions upscaler.UpscalerOptions
-	err             error
-	cleanupComplete bool
+	filepicker  filepicker.Model
+	settings    settingsForm
+	settingsErr error
+	previewDir  string
+	state       string // "picking", "settings", "previewing", "running", "cancelling", "done", "error"
+	stage       string // the stage of the running job, see StageProbing
+	videoPath   string
+	info        *ffmpeg.VideoInfo
+	content     *classify.Result
+	contentErr  error
+	progress    *upscaler.Progress
+	cancel      context.CancelFunc
+	result      *JobResult
+	options     Options
+	err         error
 }
 
-// NewUIModel creates a new UI model with default options
-func NewUIModel() UIModel {
-	// Get default options
-	options := upscaler.DefaultOptions()
-
+// NewUIModel creates a new UI model with the given options
+func NewUIModel(options Options) UIModel {
 	// Prompt for batch size
 	fmt.Println(ui.FormatTitle("VideoUp - Configuration"))
 	fmt.Println(ui.FormatInfo("Enter batch size for upscaling (higher values use more GPU memory):"))
 	fmt.Println(ui.FormatInfo("Recommended values: 5-10 for 4GB GPU, 10-20 for 8GB GPU, 20-30 for 16GB+ GPU"))
-	fmt.Printf("Batch size [%d]: ", options.BatchSize)
+	fmt.Printf("Batch size [%d]: ", options.Upscaler.BatchSize)
 
 	var input string
 	fmt.Scanln(&input)
@@ -47,21 +51,25 @@ func NewUIModel() UIModel {
 		var batchSize int
 		_, err := fmt.Sscanf(input, "%d", &batchSize)
 		if err == nil && batchSize > 0 {
-			options.BatchSize = batchSize
+			options.Upscaler.BatchSize = batchSize
 		}
 	}
 
-	fmt.Println(ui.FormatInfo(fmt.Sprintf("Using batch size: %d", options.BatchSize)))
+	fmt.Println(ui.FormatInfo(fmt.Sprintf("Using batch size: %d", options.Upscaler.BatchSize)))
 	fmt.Println()
 
 	return UIModel{
-		filepicker:      filepicker.New(),
-		state:           "picking",
-		upscalerOptions: options,
-		cleanupComplete: false,
+		filepicker: filepicker.New(),
+		state:      "picking",
+		options:    options,
 	}
 }
 
+// Err returns the error the job ended with, if any
+func (m UIModel) Err() error {
+	return m.err
+}
+
 // Init initializes the UI model
 func (m UIModel) Init() tea.Cmd {
 	return m.filepicker.Init()
@@ -71,26 +79,32 @@ func (m UIModel) Init() tea.Cmd {
 func (m UIModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	// Handle global key events first
 	if keyMsg, ok := msg.(tea.KeyMsg); ok {
-		// Handle quit commands (ctrl+c, q, esc)
-		if keyMsg.String() == "ctrl+c" || keyMsg.String() == "q" || keyMsg.String() == "esc" {
-			// Always clean up before quitting, regardless of state
-			fmt.Println(ui.FormatInfo("\nCleaning up before exit..."))
-			cleanup.CleanupAll()
-			return m, tea.Quit
+		// Handle quit commands (ctrl+c, q, esc); q is typed text on the settings screen
+		quit := keyMsg.String() == "ctrl+c" || keyMsg.String() == "esc" ||
+			(keyMsg.String() == "q" && m.state != "settings" && m.state != "previewing")
+		if quit {
+			return m.quit()
 		}
 	}
+	if _, ok := msg.(InterruptMsg); ok {
+		return m.quit()
+	}
+
+	// The content analysis may finish on any screen
+	if msg, ok := msg.(contentResultMsg); ok {
+		m.content, m.contentErr = msg.content, msg.err
+		return m, nil
+	}
 
 	switch m.state {
 	case "picking":
 		return m.handlePickingState(msg)
-	case "processing":
-		return m.handleProcessingState(msg)
-	case "upscaling":
-		return m.handleUpscalingState(msg)
-	case "combining":
-		return m.handleCombiningState(msg)
-	case "cleaning":
-		return m.handleCleaningState(msg)
+	case "settings":
+		return m.handleSettingsState(msg)
+	case "previewing":
+		return m.handlePreviewingState(msg)
+	case "running", "cancelling":
+		return m.handleRunningState(msg)
 	case "done", "error":
 		return m.handleFinalState(msg)
 	}
@@ -105,39 +119,32 @@ func (m UIModel) View() string {
 		return ui.FormatTitle("VideoUp - Select a Video File") + "\n\n" +
 			m.filepicker.View()
 
-	case "processing":
-		return ui.FormatTitle("VideoUp - Processing Video") + "\n\n" +
-			ui.FormatInfo("Extracting frames from video...") + "\n" +
-			ui.FormatInfo("This may take a while depending on the video size.") + "\n\n" +
-			"Press Ctrl+C to cancel."
+	case "settings":
+		return m.renderSettingsView()
 
-	case "upscaling":
-		return ui.FormatTitle("VideoUp - Upscaling Frames") + "\n\n" +
-			ui.FormatInfo("Upscaling frames using Real-ESRGAN...") + "\n" +
-			ui.FormatInfo(fmt.Sprintf("Using model: %s with scale: %d", m.upscalerOptions.Model, m.upscalerOptions.Scale)) + "\n" +
-			ui.FormatInfo("This may take a while depending on the number of frames and your GPU.") + "\n\n" +
+	case "previewing":
+		return ui.FormatTitle("VideoUp - Rendering Preview") + "\n\n" +
+			ui.FormatInfo("Upscaling preview frames...") + "\n" +
+			ui.FormatInfo(fmt.Sprintf("Using model: %s with scale: %d", m.options.Upscaler.Model, m.options.Upscaler.Scale)) + "\n\n" +
 			"Press Ctrl+C to cancel."
 
-	case "combining":
-		return ui.FormatTitle("VideoUp - Creating Video") + "\n\n" +
-			ui.FormatInfo("Combining upscaled frames into a video file...") + "\n" +
-			ui.FormatInfo("Using ProRes codec for Adobe compatibility.") + "\n" +
-			ui.FormatInfo("This may take a while depending on the number of frames.") + "\n\n" +
-			"Press Ctrl+C to cancel."
+	case "running":
+		return m.renderStageView()
 
-	case "cleaning":
-		return ui.FormatTitle("VideoUp - Cleaning Up") + "\n\n" +
-			ui.FormatInfo("Cleaning up temporary files...") + "\n" +
-			ui.FormatInfo("Removing extracted frames and upscaled frames.") + "\n\n" +
-			"Press Ctrl+C to cancel."
+	case "cancelling":
+		return ui.FormatTitle("VideoUp - Cancelling") + "\n\n" +
+			ui.FormatInfo("Stopping the job and removing its temporary files...")
 
 	case "done":
 		return m.renderDoneView()
 
 	case "error":
-		return ui.FormatTitle("VideoUp - Error") + "\n\n" +
-			ui.FormatError(fmt.Sprintf("Error: %v", m.err)) + "\n\n" +
-			"Press Enter or q to exit."
+		result := ui.FormatTitle("VideoUp - Error") + "\n\n" +
+			ui.FormatError(fmt.Sprintf("Error: %v", m.err)) + "\n\n"
+		if remedy := errs.Remedy(m.err); remedy != "" {
+			result += ui.FormatInfo(remedy) + "\n\n"
+		}
+		return result + "Press Enter or q to exit."
 
 	default:
 		return "Unknown state"
@@ -157,10 +164,9 @@ func (m UIModel) handlePickingState(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// Verify it's a video file
 		if filepicker.VideoFileFilter(m.filepicker.Selected) {
 			m.videoPath = m.filepicker.Selected
-			m.state = "processing"
-
-			// Start processing the video
-			return m, processVideoCmd(m.videoPath)
+			m.state = "settings"
+			m.settings = newSettingsForm(m.options)
+			return m, probeVideoCmd(m.videoPath)
 		} else {
 			// Not a video file, show error
 			m.err = fmt.Errorf("selected file is not a video: %s", m.filepicker.Selected)
@@ -179,65 +185,127 @@ func (m UIModel) handlePickingState(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
-func (m UIModel) handleProcessingState(msg tea.Msg) (tea.Model, tea.Cmd) {
-	// Handle processing state
+func (m UIModel) handleSettingsState(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// Handle settings state
 	switch msg := msg.(type) {
 	case errMsg:
 		m.err = msg.err
 		m.state = "error"
 		return m, nil
-	case processResultMsg:
-		m.outputDir = msg.outputDir
-		m.state = "upscaling"
+	case probeResultMsg:
+		m.info = msg.info
+		// Recommend a model while the settings are edited
+		return m, analyzeContentCmd(m.videoPath, m.info, m.options.FFmpeg)
+	}
 
-		// Start upscaling the frames
-		return m, upscaleFramesCmd(m.outputDir, m.upscalerOptions)
+	if keyMsg, ok := msg.(tea.KeyMsg); ok && (keyMsg.String() == "enter" || keyMsg.String() == "ctrl+p") {
+		// The jobs are planned from the video's properties
+		if m.info == nil {
+			m.settingsErr = fmt.Errorf("still reading the video, try again in a moment")
+			return m, nil
+		}
+		options, err := m.settings.Apply(m.options)
+		if err != nil {
+			m.settingsErr = err
+			return m, nil
+		}
+		// Use the recommended model for auto, unless the analysis failed
+		if options.Upscaler.Model == ModelAuto {
+			if m.content == nil && m.contentErr == nil {
+				m.settingsErr = fmt.Errorf("still analyzing the video for the auto model, try again in a moment")
+				return m, nil
+			}
+			options.Upscaler.Model = RecommendModel(m.content)
+		}
+		// Pick the scale that reaches the target resolution
+		plan, err := PlanOutput(m.info, options)
+		if err != nil {
+			m.settingsErr = err
+			return m, nil
+		}
+		options.Upscaler.Scale = plan.Scale
+		m.options = options
+		m.settingsErr = nil
+
+		// Render a preview and come back to the settings
+		if keyMsg.String() == "ctrl+p" {
+			m.state = "previewing"
+			return m, renderPreviewCmd(m.videoPath, m.options)
+		}
+
+		// The job skips an output that already exists by itself
+		ctx, cancel := context.WithCancel(context.Background())
+		m.cancel = cancel
+		m.state = "running"
+		return m, runJobCmd(ctx, m.videoPath, m.options, m.content)
 	}
-	return m, nil
+
+	var cmd tea.Cmd
+	m.settings, cmd = m.settings.Update(msg)
+	return m, cmd
 }
 
-func (m UIModel) handleUpscalingState(msg tea.Msg) (tea.Model, tea.Cmd) {
-	// Handle upscaling state
+func (m UIModel) handlePreviewingState(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// Handle previewing state, a failed preview is reported on the settings screen
 	switch msg := msg.(type) {
-	case errMsg:
-		m.err = msg.err
-		m.state = "error"
+	case previewErrMsg:
+		m.settingsErr = fmt.Errorf("preview failed: %w", msg.err)
+		m.state = "settings"
+		return m, nil
+	case previewResultMsg:
+		m.previewDir = msg.previewDir
+		m.state = "settings"
 		return m, nil
-	case upscaleResultMsg:
-		m.upscaledDir = msg.upscaledDir
-		m.state = "combining"
-
-		// Start combining frames into video
-		return m, combineFramesCmd(m.upscaledDir, m.videoPath)
 	}
 	return m, nil
 }
 
-func (m UIModel) handleCombiningState(msg tea.Msg) (tea.Model, tea.Cmd) {
-	// Handle combining state
-	switch msg := msg.(type) {
-	case errMsg:
-		m.err = msg.err
-		m.state = "error"
+// quit leaves the UI. A running job is cancelled first, the UI quits once
+// it has stopped and removed its frames
+func (m UIModel) quit() (tea.Model, tea.Cmd) {
+	switch m.state {
+	case "running":
+		m.cancel()
+		m.state = "cancelling"
+		return m, nil
+	case "cancelling":
 		return m, nil
-	case combineResultMsg:
-		m.outputVideoPath = msg.outputVideoPath
-		m.state = "cleaning"
-		return m, cleanupFilesCmd(m.outputDir, m.upscaledDir)
 	}
-	return m, nil
+
+	// Always clean up before quitting, regardless of state
+	fmt.Println(ui.FormatInfo("\nCleaning up before exit..."))
+	cleanup.CleanupAll()
+	if m.state == "previewing" {
+		m.err = errs.ErrCancelled
+	}
+	return m, tea.Quit
 }
 
-func (m UIModel) handleCleaningState(msg tea.Msg) (tea.Model, tea.Cmd) {
-	// Handle cleanup state
+func (m UIModel) handleRunningState(msg tea.Msg) (tea.Model, tea.Cmd) {
+	// Handle the running job, its updates are waited for until the result
+	// arrives, also while cancelling
 	switch msg := msg.(type) {
-	case errMsg:
-		// If cleanup fails, just log the error but still proceed to done state
-		fmt.Printf("Warning: Failed to clean up temporary files: %v\n", msg.err)
-		m.state = "done"
-		return m, nil
-	case cleanupResultMsg:
-		m.cleanupComplete = true
+	case stageMsg:
+		if m.state == "running" {
+			m.stage = msg.stage
+		}
+		return m, waitForJobCmd(msg.updates)
+	case upscaleProgressMsg:
+		m.progress = &msg.progress
+		return m, waitForJobCmd(msg.updates)
+	case jobResultMsg:
+		m.cancel()
+		if m.state == "cancelling" {
+			cleanup.CleanupAll()
+			m.err = errs.ErrCancelled
+			return m, tea.Quit
+		}
+		if msg.err != nil {
+			m.err = msg.err
+			m.state = "error"
+			return m, nil
+		}
+		m.result = msg.result
 		m.state = "done"
 		return m, nil
 	}
@@ -250,58 +318,195 @@ func (m UIModel) handleFinalState(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		if msg.String() == "enter" {
 			// Always clean up before quitting
-			if !m.cleanupComplete {
-				cleanup.CleanupAll()
-			}
+			cleanup.CleanupAll()
 			return m, tea.Quit
 		}
 	}
 	return m, nil
 }
 
-func (m UIModel) renderDoneView() string {
-	// Try to read the video info file
-	infoText := ""
-	infoPath := filepath.Join(m.outputDir, "video_info.json")
-	if infoData, err := os.ReadFile(infoPath); err == nil {
-		var info ffmpeg.VideoInfo
-		if err := json.Unmarshal(infoData, &info); err == nil {
-			infoText = fmt.Sprintf(
-				"Video Information:\n"+
-					"  Resolution: %dx%d\n"+
-					"  Frame Rate: %.2f fps\n"+
-					"  Duration: %.2f seconds\n"+
-					"  Total Frames: %d\n"+
-					"  Format: %s\n"+
-					"  Codec: %s\n",
-				info.Width, info.Height,
-				info.FrameRate,
-				info.Duration,
-				info.TotalFrames,
-				info.FormatName,
-				info.CodecName,
-			)
+// renderStageView renders the stage the running job is in
+func (m UIModel) renderStageView() string {
+	var title string
+	var lines []string
+	switch m.stage {
+	case StageAnalyzing:
+		title = "VideoUp - Analyzing Content"
+		lines = []string{"Classifying sampled frames to pick a model..."}
+	case StageScenes:
+		title = "VideoUp - Detecting Scenes"
+		lines = []string{"Splitting the video into scenes..."}
+	case StageExtracting:
+		title = "VideoUp - Processing Video"
+		lines = []string{
+			"Extracting frames from video...",
+			"This may take a while depending on the video size.",
+		}
+	case StageUpscaling:
+		title = "VideoUp - Upscaling Frames"
+		lines = []string{
+			"Upscaling frames using Real-ESRGAN...",
+			fmt.Sprintf("Using model: %s with scale: %d", m.options.Upscaler.Model, m.options.Upscaler.Scale),
+			"This may take a while depending on the number of frames and your GPU.",
+		}
+	case StageEncoding:
+		title = "VideoUp - Creating Video"
+		lines = []string{
+			"Combining upscaled frames into a video file...",
+			"Using ProRes codec for Adobe compatibility.",
+			"This may take a while depending on the number of frames.",
 		}
+	case StageComparing:
+		title = "VideoUp - Rendering Comparison"
+		lines = []string{fmt.Sprintf("Rendering %s comparison against the original...", m.options.FFmpeg.Compare)}
+	case StageMeasuring:
+		title = "VideoUp - Measuring Quality"
+		lines = []string{"Computing PSNR, SSIM and VMAF against the original..."}
+	case StageReporting:
+		title = "VideoUp - Writing Report"
+		lines = []string{"Writing the job report next to the output..."}
+	case StageCleaning:
+		title = "VideoUp - Cleaning Up"
+		lines = []string{
+			"Cleaning up temporary files...",
+			"Removing extracted frames and upscaled frames.",
+		}
+	default:
+		title = "VideoUp - Processing Video"
+		lines = []string{"Reading the video..."}
 	}
 
+	result := ui.FormatTitle(title) + "\n\n"
+	for _, line := range lines {
+		result += ui.FormatInfo(line) + "\n"
+	}
+	if m.stage == StageUpscaling {
+		result += m.renderProgress()
+	}
+	return result + "\nPress Ctrl+C to cancel."
+}
+
+// renderProgress renders the upscaling progress of each device
+func (m UIModel) renderProgress() string {
+	if m.progress == nil {
+		return ""
+	}
+
+	result := "\n" + ui.FormatInfo(fmt.Sprintf("Pass %d/%d: %d/%d frames",
+		m.progress.Pass, m.progress.Passes, m.progress.Done, m.progress.Frames)) + "\n"
+	for _, device := range m.progress.Devices {
+		line := fmt.Sprintf("  GPU %d: %d frames done, %d failed, %d workers", device.ID, device.Done, device.Failed, device.Workers)
+		if device.Excluded {
+			result += ui.FormatError(line+" (excluded)") + "\n"
+		} else {
+			result += ui.FormatInfo(line) + "\n"
+		}
+	}
+	return result
+}
+
+func (m UIModel) renderSettingsView() string {
+	result := ui.FormatTitle("VideoUp - Settings") + "\n\n" +
+		ui.FormatInfo(fmt.Sprintf("Video: %s", m.videoPath)) + "\n" +
+		ui.FormatInfo(fmt.Sprintf("Model: %s with scale: %d", m.options.Upscaler.Model, m.options.Upscaler.Scale)) + "\n"
+
+	// Recommend a model from the content
+	switch {
+	case m.contentErr != nil:
+		result += ui.FormatError(fmt.Sprintf("Content analysis failed: %v", m.contentErr)) + "\n"
+	case m.content != nil:
+		result += ui.FormatInfo(fmt.Sprintf("Content: %s, recommended model: %s", m.content, RecommendModel(m.content))) + "\n"
+	case m.info != nil:
+		result += ui.FormatInfo("Analyzing the content...") + "\n"
+	}
+
+	// Show what the current settings produce
+	if m.info != nil {
+		if options, err := m.settings.Apply(m.options); err == nil {
+			if options.Upscaler.Model == ModelAuto {
+				options.Upscaler.Model = RecommendModel(m.content)
+			}
+			if plan, err := PlanOutput(m.info, options); err == nil {
+				result += ui.FormatInfo(fmt.Sprintf("Source: %dx%d, output: %s", m.info.Width, m.info.Height, plan)) + "\n"
+				if outputPath, err := OutputPath(m.videoPath, m.info, options); err == nil {
+					result += ui.FormatInfo(fmt.Sprintf("Output file: %s", outputPath)) + "\n"
+				} else {
+					result += ui.FormatError(err.Error()) + "\n"
+				}
+			} else {
+				result += ui.FormatError(err.Error()) + "\n"
+			}
+		}
+	}
+
+	result += "\n" + m.settings.View() + "\n"
+
+	if m.settingsErr != nil {
+		result += ui.FormatError(fmt.Sprintf("Error: %v", m.settingsErr)) + "\n\n"
+	} else if m.previewDir != "" {
+		result += ui.FormatSuccess(fmt.Sprintf("Preview written to %s", m.previewDir)) + "\n\n"
+	}
+
+	result += "Use up/down to move between fields. Press Enter to start, Ctrl+P to preview, Esc to quit."
+	return result
+}
+
+func (m UIModel) renderDoneView() string {
+	if m.result.Skipped {
+		return ui.FormatTitle("VideoUp - Skipped") + "\n\n" +
+			ui.FormatInfo(fmt.Sprintf("The output already exists: %s", m.result.Output)) + "\n" +
+			ui.FormatInfo("Set \"If output exists\" to overwrite or increment to upscale it again.") + "\n\n" +
+			"Press Enter or q to exit."
+	}
+
+	info := m.info
+	infoText := fmt.Sprintf(
+		"Video Information:\n"+
+			"  Resolution: %dx%d\n"+
+			"  Frame Rate: %.2f fps\n"+
+			"  Duration: %.2f seconds\n"+
+			"  Total Frames: %d\n"+
+			"  Format: %s\n"+
+			"  Codec: %s\n",
+		info.Width, info.Height,
+		info.FrameRate,
+		info.Duration,
+		info.TotalFrames,
+		info.FormatName,
+		info.CodecName,
+	)
+
 	result := ui.FormatTitle("VideoUp - Processing Complete") + "\n\n" +
 		ui.FormatSuccess("Successfully extracted, upscaled, and combined frames!") + "\n\n" +
 		ui.FormatInfo(fmt.Sprintf("Original video: %s", m.videoPath)) + "\n" +
-		ui.FormatInfo(fmt.Sprintf("Upscaled video: %s", m.outputVideoPath)) + "\n"
-
-	// Only show temp directories if cleanup failed
-	if !m.cleanupComplete {
-		result += ui.FormatInfo(fmt.Sprintf("Original frames: %s", m.outputDir)) + "\n" +
-			ui.FormatInfo(fmt.Sprintf("Upscaled frames: %s", m.upscaledDir)) + "\n"
-	} else {
-		result += ui.FormatSuccess("Temporary files have been cleaned up.") + "\n"
+		ui.FormatInfo(fmt.Sprintf("Upscaled video: %s", m.result.Output)) + "\n"
+
+	if m.result.Comparison != "" {
+		result += ui.FormatInfo(fmt.Sprintf("Comparison video: %s", m.result.Comparison)) + "\n"
+	}
+	if m.result.QualityReport != "" {
+		result += ui.FormatInfo(fmt.Sprintf("Quality report: %s", m.result.QualityReport)) + "\n"
+	}
+	if m.result.JobReport != "" {
+		result += ui.FormatInfo(fmt.Sprintf("Job report: %s", m.result.JobReport)) + "\n"
+	}
+	if m.result.ReportError != "" {
+		result += ui.FormatError(fmt.Sprintf("Job report not written: %s", m.result.ReportError)) + "\n"
+	}
+	result += ui.FormatSuccess("Temporary files have been cleaned up.") + "\n"
+
+	// Report source streams the output container could not hold
+	if len(m.result.Dropped) > 0 {
+		result += ui.FormatError("Not carried over to the output:") + "\n"
+		for _, dropped := range m.result.Dropped {
+			result += ui.FormatInfo("  "+dropped) + "\n"
+		}
 	}
 
-	result += "\n"
+	result += "\n" + ui.FormatInfo(infoText) + "\n\n"
 
-	// Add video info if available
-	if infoText != "" {
-		result += ui.FormatInfo(infoText) + "\n\n"
+	if m.result.Quality != nil {
+		result += ui.FormatInfo(FormatQualitySummary(m.result.Quality)) + "\n\n"
 	}
 
 	result += "Press Enter or q to exit."
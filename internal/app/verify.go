@@ -0,0 +1,116 @@
+package app
+
+import (
+	"fmt"
+	"math"
+
+	"videoup/internal/errs"
+	"videoup/internal/ffmpeg"
+)
+
+// frameTolerance is the number of frames the output may differ from the
+// source by, for timestamp rounding at the ends of a range
+const frameTolerance = 1
+
+// VerifyOutput probes an encoded output and compares its dimensions, frame
+// count, duration, frame rate and streams against what the source and the
+// options should produce. framesDir holds the frames that were encoded.
+// Mismatches are returned as an *errs.VerifyError
+func VerifyOutput(outputPath string, source *ffmpeg.VideoInfo, framesDir string, options Options) error {
+	output, err := ffmpeg.GetVideoInfo(outputPath)
+	if err != nil {
+		return &errs.VerifyError{Path: outputPath, Mismatches: []string{"could not be probed: " + err.Error()}}
+	}
+
+	// A partial output only holds the selected range
+	frames := source.TotalFrames
+	if options.FFmpeg.HasRange() && !options.FFmpeg.Splice {
+		start, end, err := ffmpeg.ResolveRange(source, options.FFmpeg)
+		if err != nil {
+			return err
+		}
+		frames = end - start
+	}
+	// Without nb_frames the source count is estimated from the container
+	// duration, which includes audio that often runs longer than the video.
+	// The frames that were extracted are the real count then, except for a
+	// splice, whose frames outside the range are never counted
+	if source.FramesEstimated {
+		frames = 0
+		if !options.FFmpeg.Splice {
+			if frames, err = ffmpeg.CountFrames(framesDir); err != nil {
+				return err
+			}
+		}
+	}
+
+	mismatches, err := compareOutput(output, source, frames, options)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return &errs.VerifyError{Path: outputPath, Mismatches: mismatches}
+	}
+	return nil
+}
+
+// compareOutput lists how a probed output differs from what the source and
+// the options should produce. frames is the expected frame count, 0 when it
+// is unknown
+func compareOutput(output, source *ffmpeg.VideoInfo, frames int, options Options) ([]string, error) {
+	plan, err := PlanOutput(source, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var mismatches []string
+	mismatch := func(format string, args ...interface{}) {
+		mismatches = append(mismatches, fmt.Sprintf(format, args...))
+	}
+
+	if output.Width == 0 || output.Height == 0 {
+		mismatch("no video stream")
+	} else if output.Width != plan.Width || output.Height != plan.Height {
+		mismatch("dimensions: expected %dx%d, got %dx%d", plan.Width, plan.Height, output.Width, output.Height)
+	}
+
+	if math.Abs(output.FrameRate-source.FrameRate) > 0.01 {
+		mismatch("frame rate: expected %.3f, got %.3f", source.FrameRate, output.FrameRate)
+	}
+
+	if frames > 0 && abs(output.TotalFrames-frames) > frameTolerance {
+		mismatch("frames: expected %d, got %d", frames, output.TotalFrames)
+	}
+
+	// The duration follows from the frames at the output's own rate, a
+	// frame rate mismatch is reported above
+	rate := output.FrameRate
+	if rate <= 0 {
+		rate = source.FrameRate
+	}
+	if frames > 0 && rate > 0 && output.Duration > 0 {
+		duration := float64(frames) / rate
+		tolerance := float64(frameTolerance+1) / rate
+		if math.Abs(output.Duration-duration) > tolerance {
+			mismatch("duration: expected %.2fs, got %.2fs", duration, output.Duration)
+		}
+	}
+
+	passthrough := ffmpeg.PlanPassthrough(source, options.FFmpeg.Container)
+	if len(output.Subtitles) != passthrough.Subtitles {
+		mismatch("subtitle streams: expected %d, got %d", passthrough.Subtitles, len(output.Subtitles))
+	}
+	if len(output.Attachments) != passthrough.Attachments {
+		mismatch("attachments: expected %d, got %d", passthrough.Attachments, len(output.Attachments))
+	}
+
+	return mismatches, nil
+}
+
+// abs returns the absolute value of an int
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
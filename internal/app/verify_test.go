@@ -0,0 +1,98 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"videoup/internal/ffmpeg"
+)
+
+func TestCompareOutput(t *testing.T) {
+	source := &ffmpeg.VideoInfo{Width: 640, Height: 360, FrameRate: 24, TotalFrames: 240, Duration: 10}
+	options := DefaultOptions()
+	options.Upscaler.Model = "realesrgan-x4plus"
+	options.Upscaler.Scale = 4
+	options.FFmpeg.Container = ffmpeg.ContainerMOV
+
+	// good is what a correct 4x encode of the source probes as
+	good := func() *ffmpeg.VideoInfo {
+		return &ffmpeg.VideoInfo{Width: 2560, Height: 1440, FrameRate: 24, TotalFrames: 240, Duration: 10}
+	}
+
+	tests := []struct {
+		name   string
+		change func(output *ffmpeg.VideoInfo)
+		frames int
+		// Kinds of mismatch expected, the text before the colon
+		want []string
+	}{
+		{name: "matching", change: func(*ffmpeg.VideoInfo) {}, frames: 240},
+		{name: "one frame short", change: func(o *ffmpeg.VideoInfo) { o.TotalFrames, o.Duration = 239, 239.0/24 }, frames: 240},
+		{name: "one frame over", change: func(o *ffmpeg.VideoInfo) { o.TotalFrames = 241 }, frames: 240},
+		{name: "two frames short", change: func(o *ffmpeg.VideoInfo) { o.TotalFrames = 238 }, frames: 240, want: []string{"frames"}},
+		{name: "truncated", change: func(o *ffmpeg.VideoInfo) { o.TotalFrames, o.Duration = 120, 5 }, frames: 240,
+			want: []string{"frames", "duration"}},
+		{name: "duration within two frames", change: func(o *ffmpeg.VideoInfo) { o.Duration = 10.08 }, frames: 240},
+		{name: "duration off by three frames", change: func(o *ffmpeg.VideoInfo) { o.Duration = 10.125 }, frames: 240,
+			want: []string{"duration"}},
+		{name: "unknown frame count", change: func(o *ffmpeg.VideoInfo) { o.TotalFrames, o.Duration = 12, 0.5 }, frames: 0},
+		{name: "unknown output duration", change: func(o *ffmpeg.VideoInfo) { o.Duration = 0 }, frames: 240},
+		{name: "wrong size", change: func(o *ffmpeg.VideoInfo) { o.Width, o.Height = 1280, 720 }, frames: 240,
+			want: []string{"dimensions"}},
+		{name: "no video", change: func(o *ffmpeg.VideoInfo) { o.Width, o.Height = 0, 0 }, frames: 240,
+			want: []string{"no video stream"}},
+		// The duration is checked at the output's rate, so a wrong rate is
+		// reported once
+		{name: "frame rate", change: func(o *ffmpeg.VideoInfo) { o.FrameRate, o.Duration = 25, 9.6 }, frames: 240,
+			want: []string{"frame rate"}},
+		{name: "NTSC rate rounding", change: func(o *ffmpeg.VideoInfo) { o.FrameRate = 24.005 }, frames: 240},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := good()
+			tt.change(output)
+			mismatches, err := compareOutput(output, source, tt.frames, options)
+			if err != nil {
+				t.Fatalf("compareOutput() error = %v", err)
+			}
+			var kinds []string
+			for _, mismatch := range mismatches {
+				kind, _, _ := strings.Cut(mismatch, ":")
+				kinds = append(kinds, kind)
+			}
+			if !reflect.DeepEqual(kinds, tt.want) {
+				t.Errorf("compareOutput() = %q, want mismatches of %q", mismatches, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareOutputStreams(t *testing.T) {
+	source := &ffmpeg.VideoInfo{
+		Width: 640, Height: 360, FrameRate: 24, TotalFrames: 24,
+		Subtitles: []ffmpeg.StreamInfo{{Codec: "subrip"}, {Codec: "hdmv_pgs_subtitle"}},
+	}
+	output := &ffmpeg.VideoInfo{Width: 2560, Height: 1440, FrameRate: 24, TotalFrames: 24}
+	options := DefaultOptions()
+	options.Upscaler.Model = "realesrgan-x4plus"
+	options.Upscaler.Scale = 4
+
+	// QuickTime drops the bitmap subtitles, so only one is expected
+	options.FFmpeg.Container = ffmpeg.ContainerMOV
+	mismatches, _ := compareOutput(output, source, 24, options)
+	if len(mismatches) != 1 || mismatches[0] != "subtitle streams: expected 1, got 0" {
+		t.Errorf("compareOutput(mov) = %q", mismatches)
+	}
+	output.Subtitles = []ffmpeg.StreamInfo{{Codec: "mov_text"}}
+	if mismatches, _ := compareOutput(output, source, 24, options); len(mismatches) != 0 {
+		t.Errorf("compareOutput(mov) = %q, want no mismatches", mismatches)
+	}
+
+	// Matroska keeps both
+	options.FFmpeg.Container = ffmpeg.ContainerMKV
+	if mismatches, _ := compareOutput(output, source, 24, options); len(mismatches) != 1 {
+		t.Errorf("compareOutput(mkv) = %q, want the missing subtitle reported", mismatches)
+	}
+}
@@ -0,0 +1,191 @@
+package classify
+
+import (
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+	"sort"
+)
+
+// Kind is what a video looks like
+type Kind string
+
+const (
+	// KindAnimation is drawn content: flat colors and line art
+	KindAnimation Kind = "animation"
+	// KindLiveAction is camera footage or photorealistic rendering
+	KindLiveAction Kind = "live-action"
+	// KindMixed has both, e.g. anime with live-action inserts
+	KindMixed Kind = "mixed"
+)
+
+// Gradient thresholds on 0-255 luma. Below flatGradient a pixel is in a flat
+// area (compression noise included), from edgeGradient on it is on a hard edge
+const (
+	flatGradient = 6
+	edgeGradient = 48
+)
+
+// paletteColors is how many of the most common colors FrameStats.Palette counts
+const paletteColors = 32
+
+// drawnScore is the score from which a frame counts as drawn
+const drawnScore = 0.5
+
+// mixedShare is the share of drawn (or photographic) frames below which a
+// video counts as mixed rather than one kind
+const mixedShare = 0.75
+
+// FrameStats are the statistics of one frame. Drawn frames have large flat
+// areas bounded by hard edges and few distinct colors, natural images have
+// soft gradients and texture everywhere
+type FrameStats struct {
+	Path string `json:"path"`
+	// Share of pixels in flat areas, on hard edges and in soft gradients
+	Flat    float64 `json:"flat"`
+	Edges   float64 `json:"edges"`
+	Texture float64 `json:"texture"`
+	// Share of pixels covered by the most common colors
+	Palette float64 `json:"palette"`
+	// 0 (photographic) to 1 (drawn)
+	Score float64 `json:"score"`
+}
+
+// Drawn reports whether the frame looks like animation
+func (s FrameStats) Drawn() bool {
+	return s.Score >= drawnScore
+}
+
+// Result is the classification of a set of frames
+type Result struct {
+	Kind Kind `json:"kind"`
+	// Mean score of the frames, 0 (photographic) to 1 (drawn)
+	Score  float64      `json:"score"`
+	Drawn  int          `json:"drawn"`
+	Frames []FrameStats `json:"frames"`
+}
+
+// String describes the result as e.g. "animation (7 of 8 samples look drawn)"
+func (r *Result) String() string {
+	return fmt.Sprintf("%s (%d of %d samples look drawn)", r.Kind, r.Drawn, len(r.Frames))
+}
+
+// Analyze computes the statistics of an image
+func Analyze(img image.Image) FrameStats {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 3 || height < 3 {
+		return FrameStats{}
+	}
+
+	// Luma and a 5-bit per channel color of each pixel
+	luma := make([]int, width*height)
+	colors := map[int]int{}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			luma[y*width+x] = int(299*r+587*g+114*b) / 1000 >> 8
+			colors[int(r>>11)<<10|int(g>>11)<<5|int(b>>11)]++
+		}
+	}
+
+	// Central differences, skipping the border
+	var flat, edges, texture int
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			i := y*width + x
+			gradient := abs(luma[i+1]-luma[i-1]) + abs(luma[i+width]-luma[i-width])
+			switch {
+			case gradient < flatGradient:
+				flat++
+			case gradient >= edgeGradient:
+				edges++
+			default:
+				texture++
+			}
+		}
+	}
+	inner := float64((width - 2) * (height - 2))
+
+	counts := make([]int, 0, len(colors))
+	for _, count := range colors {
+		counts = append(counts, count)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(counts)))
+	common := 0
+	for _, count := range counts[:min(paletteColors, len(counts))] {
+		common += count
+	}
+
+	stats := FrameStats{
+		Flat:    float64(flat) / inner,
+		Edges:   float64(edges) / inner,
+		Texture: float64(texture) / inner,
+		Palette: float64(common) / float64(width*height),
+	}
+	// Flat areas only count against the texture, so frames with many or
+	// few edges are judged alike. The palette says less, smooth footage in
+	// one hue also has few colors
+	if flat+texture > 0 {
+		stats.Score = 0.7*float64(flat)/float64(flat+texture) + 0.3*stats.Palette
+	}
+	return stats
+}
+
+// AnalyzeFile computes the statistics of an image file
+func AnalyzeFile(path string) (FrameStats, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FrameStats{}, fmt.Errorf("failed to open frame: %w", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return FrameStats{}, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	stats := Analyze(img)
+	stats.Path = path
+	return stats, nil
+}
+
+// Classify decides what kind of content the frames show
+func Classify(paths []string) (*Result, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no frames to classify")
+	}
+
+	result := &Result{}
+	for _, path := range paths {
+		stats, err := AnalyzeFile(path)
+		if err != nil {
+			return nil, err
+		}
+		result.Frames = append(result.Frames, stats)
+		result.Score += stats.Score
+		if stats.Drawn() {
+			result.Drawn++
+		}
+	}
+	result.Score /= float64(len(paths))
+
+	share := float64(result.Drawn) / float64(len(paths))
+	switch {
+	case share >= mixedShare:
+		result.Kind = KindAnimation
+	case share <= 1-mixedShare:
+		result.Kind = KindLiveAction
+	default:
+		result.Kind = KindMixed
+	}
+	return result, nil
+}
+
+// abs returns the absolute value of n
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -3,6 +3,8 @@ package cleanup
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"videoup/internal/ui"
 )
@@ -33,6 +35,24 @@ func RemoveDirectory(dir string) {
 	}
 }
 
+// KeepDirectory removes a directory and every registered path inside it
+// from the registry, so they are kept when cleaning up
+func KeepDirectory(dir string) {
+	createdDirsMutex.Lock()
+	defer createdDirsMutex.Unlock()
+
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	kept := createdDirs[:0]
+	for _, registeredDir := range createdDirs {
+		clean := filepath.Clean(registeredDir)
+		if clean == filepath.Clean(dir) || strings.HasPrefix(clean, prefix) {
+			continue
+		}
+		kept = append(kept, registeredDir)
+	}
+	createdDirs = kept
+}
+
 // CleanupAll removes all registered directories
 func CleanupAll() {
 	createdDirsMutex.Lock()
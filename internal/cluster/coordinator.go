@@ -0,0 +1,422 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"videoup/internal/app"
+	"videoup/internal/cleanup"
+	"videoup/internal/errs"
+	"videoup/internal/ffmpeg"
+	"videoup/internal/upscaler"
+)
+
+// maxChunkFailures is how often the upscaler may fail on a chunk before the
+// job is given up. Expired leases don't count, the worker may just have gone
+// away or lost its connection
+const maxChunkFailures = 3
+
+// CoordinatorOptions contains options for the coordinator
+type CoordinatorOptions struct {
+	// Address the workers connect to, e.g. ":8090"
+	Addr string
+	// Number of frames handed out at once
+	ChunkFrames int
+	// How long a worker has to renew or complete its chunk before it is
+	// given to another worker
+	Lease time.Duration
+	// Options of the job
+	Job app.Options
+}
+
+// DefaultCoordinatorOptions returns default coordinator options
+func DefaultCoordinatorOptions() CoordinatorOptions {
+	return CoordinatorOptions{
+		Addr:        ":8090",
+		ChunkFrames: 100,
+		Lease:       2 * time.Minute,
+		Job:         app.DefaultOptions(),
+	}
+}
+
+// chunkState is where a chunk is in its life
+type chunkState int
+
+const (
+	chunkPending chunkState = iota
+	chunkLeased
+	chunkDone
+)
+
+// chunk is a range of frames upscaled by one worker. Chunks never span
+// scenes, the scene sets their model and scale
+type chunk struct {
+	id       int
+	frames   []string
+	scene    app.Scene
+	state    chunkState
+	token    string
+	worker   string
+	expires  time.Time
+	failures int
+}
+
+// coordinator hands out the chunks of one upscale and collects the results
+type coordinator struct {
+	mu        sync.Mutex
+	framesDir string
+	outputDir string
+	lease     time.Duration
+	options   upscaler.UpscalerOptions
+	chunks    []*chunk
+	done      int
+	frames    int
+	framesUp  int
+	// finished is closed when every chunk is done, err is set if the job
+	// was given up
+	finished chan struct{}
+	err      error
+}
+
+// Coordinate runs a job with the frames upscaled by workers connecting to
+// options.Addr instead of on this machine. The server keeps running until
+// the output is written, so idle workers learn that the job is over
+func Coordinate(ctx context.Context, videoPath string, options CoordinatorOptions, onStage func(stage string)) (*app.JobResult, error) {
+	var server *http.Server
+	defer func() {
+		if server != nil {
+			server.Close()
+		}
+	}()
+
+	upscale := func(framesDir string, scenes []app.Scene, upscalerOptions upscaler.UpscalerOptions) (string, error) {
+		info, err := ffmpeg.ReadVideoInfo(framesDir)
+		if err != nil {
+			return "", err
+		}
+		if info.HasAlpha {
+			return "", fmt.Errorf("videos with an alpha channel cannot be upscaled by workers")
+		}
+
+		c, err := newCoordinator(framesDir, scenes, options, upscalerOptions)
+		if err != nil {
+			return "", err
+		}
+
+		server = &http.Server{Addr: options.Addr, Handler: c.handler()}
+		listenErr := make(chan error, 1)
+		go func() {
+			fmt.Printf("Waiting for workers on %s: %d frames in %d chunks\n", options.Addr, c.frames, len(c.chunks))
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				listenErr <- err
+			}
+		}()
+
+		select {
+		case <-c.finished:
+			if c.err != nil {
+				return "", errs.Upscale(c.err)
+			}
+			if err := app.ResizeScenes(framesDir, c.outputDir, scenes, upscalerOptions); err != nil {
+				return "", err
+			}
+			return c.outputDir, nil
+		case err := <-listenErr:
+			return "", fmt.Errorf("failed to listen on %s: %w", options.Addr, err)
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+
+	return app.RunJobWith(ctx, videoPath, options.Job, app.JobSetup{OnStage: onStage, Upscale: upscale})
+}
+
+// newCoordinator splits the frames in framesDir into chunks, within the
+// scenes when there are any
+func newCoordinator(framesDir string, scenes []app.Scene, options CoordinatorOptions, upscalerOptions upscaler.UpscalerOptions) (*coordinator, error) {
+	files, err := filepath.Glob(filepath.Join(framesDir, "*.png"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list input files: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no PNG files found in input directory: %s", framesDir)
+	}
+
+	outputDir, err := upscaler.CreateUpscaledDir(framesDir)
+	if err != nil {
+		return nil, err
+	}
+	cleanup.RegisterDirectory(outputDir)
+
+	size := options.ChunkFrames
+	if size <= 0 {
+		size = DefaultCoordinatorOptions().ChunkFrames
+	}
+	c := &coordinator{
+		framesDir: framesDir,
+		outputDir: outputDir,
+		lease:     options.Lease,
+		options:   upscalerOptions,
+		frames:    len(files),
+		finished:  make(chan struct{}),
+	}
+	if scenes == nil {
+		scenes = []app.Scene{{Start: 1, End: len(files) + 1}}
+	}
+	for _, scene := range scenes {
+		// Frame files are numbered from 1
+		first, end := max(scene.Start-1, 0), min(scene.End-1, len(files))
+		for start := first; start < end; start += size {
+			chunk := &chunk{id: len(c.chunks), scene: scene}
+			// Names are built from the frame numbers, sorting them would put
+			// frame_10000 before frame_1001
+			for frame := start + 1; frame <= min(start+size, end); frame++ {
+				chunk.frames = append(chunk.frames, fmt.Sprintf("frame_%04d.png", frame))
+			}
+			c.chunks = append(c.chunks, chunk)
+		}
+	}
+	return c, nil
+}
+
+// handler returns the HTTP API the workers use
+func (c *coordinator) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /lease", c.handleLease)
+	mux.HandleFunc("GET /frames/{name}", c.handleFrame)
+	mux.HandleFunc("PUT /chunks/{id}/frames/{name}", c.handleUpload)
+	mux.HandleFunc("POST /chunks/{id}/renew", c.handleRenew)
+	mux.HandleFunc("POST /chunks/{id}/complete", c.handleComplete)
+	mux.HandleFunc("POST /chunks/{id}/fail", c.handleFail)
+	return mux
+}
+
+// isFinished reports whether the upscale is over. The caller holds mu
+func (c *coordinator) isFinished() bool {
+	select {
+	case <-c.finished:
+		return true
+	default:
+		return false
+	}
+}
+
+// leaseFor returns the lease of a chunk as sent to its worker. The caller holds mu
+func (c *coordinator) leaseFor(chunk *chunk) Lease {
+	options := chunk.scene.Upscaler(c.options)
+	return Lease{
+		Chunk:              chunk.id,
+		Token:              chunk.token,
+		Frames:             chunk.frames,
+		Expires:            chunk.expires,
+		Seconds:            c.lease.Seconds(),
+		Model:              options.Model,
+		Scale:              options.Scale,
+		IntermediateModels: options.IntermediateModels,
+	}
+}
+
+// leasedChunk returns the chunk of a request if the request holds its
+// current lease, writing the error response otherwise. The caller holds mu
+func (c *coordinator) leasedChunk(w http.ResponseWriter, r *http.Request) *chunk {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 0 || id >= len(c.chunks) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown chunk %q", r.PathValue("id")))
+		return nil
+	}
+	chunk := c.chunks[id]
+	if chunk.state != chunkLeased || chunk.token != r.URL.Query().Get("token") {
+		writeError(w, http.StatusConflict, fmt.Errorf("the lease of chunk %d is no longer held", id))
+		return nil
+	}
+	return chunk
+}
+
+func (c *coordinator) handleLease(w http.ResponseWriter, r *http.Request) {
+	var request leaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.isFinished() {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	// Chunks whose worker went quiet are handed out again
+	now := time.Now()
+	for _, chunk := range c.chunks {
+		if chunk.state == chunkLeased && now.After(chunk.expires) {
+			fmt.Printf("Lease of chunk %d expired on %s, reassigning it\n", chunk.id, chunk.worker)
+			chunk.state = chunkPending
+		}
+	}
+
+	for _, chunk := range c.chunks {
+		if chunk.state != chunkPending {
+			continue
+		}
+		chunk.state = chunkLeased
+		chunk.token = newToken()
+		chunk.worker = request.Worker
+		chunk.expires = now.Add(c.lease)
+		fmt.Printf("Chunk %d (%d frames) leased to %s\n", chunk.id, len(chunk.frames), chunk.worker)
+		writeJSON(w, http.StatusOK, c.leaseFor(chunk))
+		return
+	}
+
+	// Everything is leased, the worker asks again later
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *coordinator) handleFrame(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if filepath.Base(name) != name || filepath.Ext(name) != ".png" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid frame name %q", name))
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(c.framesDir, name))
+}
+
+func (c *coordinator) handleUpload(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	c.mu.Lock()
+	chunk := c.leasedChunk(w, r)
+	c.mu.Unlock()
+	if chunk == nil {
+		return
+	}
+	found := false
+	for _, frame := range chunk.frames {
+		found = found || frame == name
+	}
+	if !found {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("frame %q is not part of chunk %d", name, chunk.id))
+		return
+	}
+
+	// Write under a temporary name of its own, so a broken upload never looks
+	// like a frame and a worker whose lease expired mid-upload doesn't write
+	// into the upload of the chunk's new worker
+	file, err := os.CreateTemp(c.outputDir, name+".*.part")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to create frame: %w", err))
+		return
+	}
+	tempPath := file.Name()
+	defer os.Remove(tempPath)
+	_, err = io.Copy(file, r.Body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to write frame: %w", err))
+		return
+	}
+
+	// Only the current holder of the lease may replace the frame
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.leasedChunk(w, r) != chunk {
+		return
+	}
+	if err := os.Rename(tempPath, filepath.Join(c.outputDir, name)); err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to write frame: %w", err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *coordinator) handleRenew(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	chunk := c.leasedChunk(w, r)
+	if chunk == nil {
+		return
+	}
+	chunk.expires = time.Now().Add(c.lease)
+	writeJSON(w, http.StatusOK, c.leaseFor(chunk))
+}
+
+func (c *coordinator) handleComplete(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	chunk := c.leasedChunk(w, r)
+	if chunk == nil {
+		return
+	}
+	for _, frame := range chunk.frames {
+		if _, err := os.Stat(filepath.Join(c.outputDir, frame)); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("frame %s of chunk %d was not uploaded", frame, chunk.id))
+			return
+		}
+	}
+
+	chunk.state = chunkDone
+	c.done++
+	c.framesUp += len(chunk.frames)
+	fmt.Printf("Chunk %d done by %s: %d/%d frames (%.0f%%)\n", chunk.id, chunk.worker,
+		c.framesUp, c.frames, float64(c.framesUp)*100/float64(c.frames))
+	if c.done == len(c.chunks) {
+		close(c.finished)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *coordinator) handleFail(w http.ResponseWriter, r *http.Request) {
+	var request failRequest
+	json.NewDecoder(r.Body).Decode(&request)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	chunk := c.leasedChunk(w, r)
+	if chunk == nil {
+		return
+	}
+	chunk.state = chunkPending
+	chunk.failures++
+	fmt.Printf("Chunk %d failed on %s: %s\n", chunk.id, chunk.worker, request.Error)
+	if chunk.failures >= maxChunkFailures && !c.isFinished() {
+		c.err = fmt.Errorf("chunk %d failed %d times, last on %s: %s", chunk.id, chunk.failures, chunk.worker, request.Error)
+		close(c.finished)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newToken returns a random lease token
+func newToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeJSON writes v as the JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error as {"error": "..."}
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,320 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"videoup/internal/app"
+	"videoup/internal/upscaler"
+)
+
+// testCluster is a coordinator served over HTTP with frames on disk
+type testCluster struct {
+	t      *testing.T
+	c      *coordinator
+	server *httptest.Server
+}
+
+// writeFrames writes n placeholder source frames and returns their directory
+func writeFrames(t *testing.T, n int) string {
+	t.Helper()
+	framesDir := t.TempDir()
+	for i := 1; i <= n; i++ {
+		name := fmt.Sprintf("frame_%04d.png", i)
+		if err := os.WriteFile(filepath.Join(framesDir, name), []byte("source "+name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return framesDir
+}
+
+// newTestCluster writes frames source frames and starts a coordinator
+// handing them out in chunks of chunkFrames
+func newTestCluster(t *testing.T, frames, chunkFrames int, lease time.Duration) *testCluster {
+	t.Helper()
+	framesDir := writeFrames(t, frames)
+	options := CoordinatorOptions{ChunkFrames: chunkFrames, Lease: lease}
+	c, err := newCoordinator(framesDir, nil, options, upscaler.UpscalerOptions{Model: "realesrgan-x4plus", Scale: 4})
+	if err != nil {
+		t.Fatalf("newCoordinator() error = %v", err)
+	}
+	server := httptest.NewServer(c.handler())
+	t.Cleanup(server.Close)
+	return &testCluster{t: t, c: c, server: server}
+}
+
+// worker returns a worker of the cluster
+func (tc *testCluster) worker(name string) *worker {
+	return &worker{
+		options: WorkerOptions{Coordinator: tc.server.URL, Name: name},
+		client:  tc.server.Client(),
+	}
+}
+
+// lease leases a chunk for w, failing the test on errors
+func (tc *testCluster) lease(w *worker) *Lease {
+	tc.t.Helper()
+	lease, err := w.lease(tc.t.Context())
+	if err != nil {
+		tc.t.Fatalf("%s: lease() error = %v", w.options.Name, err)
+	}
+	return lease
+}
+
+// upload downloads every frame of a lease and uploads it back marked with
+// the worker's name, standing in for the upscaler
+func (tc *testCluster) upload(w *worker, lease *Lease) error {
+	dir := tc.t.TempDir()
+	for _, frame := range lease.Frames {
+		path := filepath.Join(dir, frame)
+		if err := w.download(tc.t.Context(), frame, path); err != nil {
+			return err
+		}
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, append(source, " by "+w.options.Name...), 0o644); err != nil {
+			return err
+		}
+		if err := w.upload(tc.t.Context(), lease, frame, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// post sends a chunk action for a lease and returns the status code
+func (tc *testCluster) post(w *worker, lease *Lease, action string) int {
+	tc.t.Helper()
+	resp, err := w.post(tc.t.Context(), w.chunkPath(lease, action), nil)
+	if err != nil {
+		tc.t.Fatalf("%s: %s error = %v", w.options.Name, action, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// finished reports whether the coordinator considers the upscale over
+func (tc *testCluster) finished() bool {
+	tc.c.mu.Lock()
+	defer tc.c.mu.Unlock()
+	return tc.c.isFinished()
+}
+
+func TestCoordinatorSpreadsChunks(t *testing.T) {
+	tc := newTestCluster(t, 5, 2, time.Minute)
+	alice, bob := tc.worker("alice"), tc.worker("bob")
+
+	first, second, third := tc.lease(alice), tc.lease(bob), tc.lease(alice)
+	if first == nil || second == nil || third == nil {
+		t.Fatalf("leases = %v, %v, %v, want three chunks", first, second, third)
+	}
+	if first.Chunk != 0 || second.Chunk != 1 || third.Chunk != 2 || len(third.Frames) != 1 {
+		t.Fatalf("leased chunks %d, %d, %d (last with %d frames), want 0, 1, 2 (1 frame)",
+			first.Chunk, second.Chunk, third.Chunk, len(third.Frames))
+	}
+	if first.Model != "realesrgan-x4plus" || first.Scale != 4 {
+		t.Errorf("lease upscales with %s x%d, want the coordinator's model and scale", first.Model, first.Scale)
+	}
+
+	// Every chunk is out, so a worker has to wait
+	if lease := tc.lease(bob); lease != nil {
+		t.Fatalf("lease() = chunk %d with every chunk leased, want none", lease.Chunk)
+	}
+
+	for _, job := range []struct {
+		w     *worker
+		lease *Lease
+	}{{alice, first}, {bob, second}, {alice, third}} {
+		if err := tc.upload(job.w, job.lease); err != nil {
+			t.Fatalf("upload of chunk %d error = %v", job.lease.Chunk, err)
+		}
+		if status := tc.post(job.w, job.lease, "complete"); status != http.StatusNoContent {
+			t.Fatalf("complete of chunk %d = %d, want %d", job.lease.Chunk, status, http.StatusNoContent)
+		}
+	}
+
+	if !tc.finished() {
+		t.Fatal("coordinator not finished after every chunk was completed")
+	}
+	if _, err := bob.lease(t.Context()); !errors.Is(err, errJobDone) {
+		t.Errorf("lease() after the job error = %v, want errJobDone", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(tc.c.outputDir, "frame_0003.png"))
+	if err != nil || string(got) != "source frame_0003.png by bob" {
+		t.Errorf("upscaled frame_0003.png = %q, %v, want bob's upload", got, err)
+	}
+}
+
+func TestCoordinatorReassignsExpiredLease(t *testing.T) {
+	tc := newTestCluster(t, 2, 2, 200*time.Millisecond)
+	slow, fast := tc.worker("slow"), tc.worker("fast")
+
+	stale := tc.lease(slow)
+	if stale == nil {
+		t.Fatal("lease() = none, want the only chunk")
+	}
+	if lease := tc.lease(fast); lease != nil {
+		t.Fatalf("lease() = chunk %d while it is held, want none", lease.Chunk)
+	}
+
+	// Renewing keeps the chunk past the original expiry
+	time.Sleep(120 * time.Millisecond)
+	if status := tc.post(slow, stale, "renew"); status != http.StatusOK {
+		t.Fatalf("renew = %d, want %d", status, http.StatusOK)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if lease := tc.lease(fast); lease != nil {
+		t.Fatalf("lease() = chunk %d after it was renewed, want none", lease.Chunk)
+	}
+
+	// Once the lease runs out the chunk goes to the next worker that asks
+	time.Sleep(300 * time.Millisecond)
+	fresh := tc.lease(fast)
+	if fresh == nil || fresh.Chunk != stale.Chunk {
+		t.Fatalf("lease() after expiry = %v, want chunk %d again", fresh, stale.Chunk)
+	}
+	if fresh.Token == stale.Token {
+		t.Fatal("reassigned lease kept the old token")
+	}
+
+	// The worker that lost the lease is turned away
+	if err := tc.upload(slow, stale); !errors.Is(err, errLeaseLost) {
+		t.Errorf("upload with an expired lease error = %v, want errLeaseLost", err)
+	}
+	for _, action := range []string{"renew", "complete", "fail"} {
+		if status := tc.post(slow, stale, action); status != http.StatusConflict {
+			t.Errorf("%s with an expired lease = %d, want %d", action, status, http.StatusConflict)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(tc.c.outputDir, "frame_0001.png")); err == nil {
+		t.Error("upload with an expired lease was written")
+	}
+
+	if err := tc.upload(fast, fresh); err != nil {
+		t.Fatalf("upload with the new lease error = %v", err)
+	}
+	if status := tc.post(fast, fresh, "complete"); status != http.StatusNoContent {
+		t.Fatalf("complete with the new lease = %d, want %d", status, http.StatusNoContent)
+	}
+	if !tc.finished() || tc.c.err != nil {
+		t.Errorf("coordinator finished = %v, err = %v, want finished without error", tc.finished(), tc.c.err)
+	}
+}
+
+func TestCoordinatorGivesUpOnFailingChunk(t *testing.T) {
+	tc := newTestCluster(t, 3, 10, time.Minute)
+	w := tc.worker("broken")
+
+	for i := 1; i <= maxChunkFailures; i++ {
+		if tc.finished() {
+			t.Fatalf("coordinator gave up after %d failures, want %d", i-1, maxChunkFailures)
+		}
+		lease := tc.lease(w)
+		if lease == nil {
+			t.Fatalf("failed chunk not handed out again after %d failures", i-1)
+		}
+		if status := tc.post(w, lease, "fail"); status != http.StatusNoContent {
+			t.Fatalf("fail = %d, want %d", status, http.StatusNoContent)
+		}
+	}
+
+	if !tc.finished() || tc.c.err == nil {
+		t.Fatalf("coordinator finished = %v, err = %v, want it to give up", tc.finished(), tc.c.err)
+	}
+	if _, err := w.lease(t.Context()); !errors.Is(err, errJobDone) {
+		t.Errorf("lease() after giving up error = %v, want errJobDone", err)
+	}
+}
+
+func TestCoordinatorRejectsForeignFrames(t *testing.T) {
+	tc := newTestCluster(t, 4, 2, time.Minute)
+	w := tc.worker("w")
+	lease := tc.lease(w)
+
+	// frame_0003.png belongs to the second chunk
+	other := *lease
+	other.Frames = []string{"frame_0003.png"}
+	if err := tc.upload(w, &other); err == nil {
+		t.Error("upload of a frame outside the chunk succeeded")
+	}
+
+	// Completing before every frame is uploaded is refused
+	if status := tc.post(w, lease, "complete"); status != http.StatusBadRequest {
+		t.Errorf("complete without uploads = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	resp, err := tc.server.Client().Get(tc.server.URL + "/frames/..%2Fsecret.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		t.Error("download outside the frames directory succeeded")
+	}
+}
+
+func TestCoordinatorChunksFollowScenes(t *testing.T) {
+	framesDir := writeFrames(t, 10)
+	scenes := []app.Scene{
+		{Start: 1, End: 4},
+		{Start: 4, End: 9, Model: "realesr-animevideov3", Scale: 2},
+		{Start: 9, End: 11},
+	}
+	c, err := newCoordinator(framesDir, scenes, CoordinatorOptions{ChunkFrames: 3, Lease: time.Minute},
+		upscaler.UpscalerOptions{Model: "realesrgan-x4plus", Scale: 4})
+	if err != nil {
+		t.Fatalf("newCoordinator() error = %v", err)
+	}
+
+	// Chunks never span two scenes, so each is upscaled with one model
+	want := []struct {
+		first, last string
+		model       string
+		scale       int
+	}{
+		{"frame_0001.png", "frame_0003.png", "realesrgan-x4plus", 4},
+		{"frame_0004.png", "frame_0006.png", "realesr-animevideov3", 2},
+		{"frame_0007.png", "frame_0008.png", "realesr-animevideov3", 2},
+		{"frame_0009.png", "frame_0010.png", "realesrgan-x4plus", 4},
+	}
+	if len(c.chunks) != len(want) {
+		t.Fatalf("newCoordinator() made %d chunks, want %d", len(c.chunks), len(want))
+	}
+	for i, chunk := range c.chunks {
+		lease := c.leaseFor(chunk)
+		first, last := chunk.frames[0], chunk.frames[len(chunk.frames)-1]
+		if first != want[i].first || last != want[i].last || lease.Model != want[i].model || lease.Scale != want[i].scale {
+			t.Errorf("chunk %d = %s-%s with %s x%d, want %s-%s with %s x%d", i, first, last, lease.Model, lease.Scale,
+				want[i].first, want[i].last, want[i].model, want[i].scale)
+		}
+	}
+}
+
+func TestCoordinatorNumbersFramesPastFourDigits(t *testing.T) {
+	framesDir := writeFrames(t, 10002)
+	c, err := newCoordinator(framesDir, nil, CoordinatorOptions{ChunkFrames: 1000, Lease: time.Minute},
+		upscaler.UpscalerOptions{Model: "realesrgan-x4plus", Scale: 4})
+	if err != nil {
+		t.Fatalf("newCoordinator() error = %v", err)
+	}
+
+	// Sorted by name frame_10000.png would land between frame_1000.png and
+	// frame_1001.png
+	second := c.chunks[1].frames
+	if second[0] != "frame_1001.png" || second[len(second)-1] != "frame_2000.png" {
+		t.Errorf("chunk 1 = %s-%s, want frame_1001.png-frame_2000.png", second[0], second[len(second)-1])
+	}
+	last := c.chunks[len(c.chunks)-1].frames
+	if want := []string{"frame_10001.png", "frame_10002.png"}; fmt.Sprint(last) != fmt.Sprint(want) {
+		t.Errorf("last chunk = %v, want %v", last, want)
+	}
+}
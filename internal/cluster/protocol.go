@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"time"
+)
+
+// The protocol between the coordinator and its workers:
+//
+//	POST /lease                           {"worker": "name"} -> Lease, 204 when
+//	                                      every chunk is leased, 410 when done
+//	GET  /frames/{name}                   source frame
+//	PUT  /chunks/{id}/frames/{name}?token upload an upscaled frame
+//	POST /chunks/{id}/renew?token         extend the lease -> Lease
+//	POST /chunks/{id}/complete?token      all frames of the chunk are uploaded
+//	POST /chunks/{id}/fail?token          {"error": "..."}, hand the chunk back
+//
+// Requests with a lease that expired and was given to another worker get
+// 409 Conflict, the worker drops the chunk
+
+// Lease is a chunk of frames handed to a worker until Expires. The worker
+// upscales them with the model and scale of the chunk's scene, and its own GPUs.
+// Seconds is the length of the lease, which does not depend on the clocks of
+// the two machines agreeing
+type Lease struct {
+	Chunk   int       `json:"chunk"`
+	Token   string    `json:"token"`
+	Frames  []string  `json:"frames"`
+	Expires time.Time `json:"expires"`
+	Seconds float64   `json:"seconds"`
+
+	Model              string   `json:"model"`
+	Scale              int      `json:"scale"`
+	IntermediateModels []string `json:"intermediate_models,omitempty"`
+}
+
+// leaseRequest is the body of POST /lease
+type leaseRequest struct {
+	Worker string `json:"worker"`
+}
+
+// failRequest is the body of POST /chunks/{id}/fail
+type failRequest struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,331 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"videoup/internal/cleanup"
+	"videoup/internal/errs"
+	"videoup/internal/upscaler"
+)
+
+// maxConnectFailures is how many requests in a row may fail to reach the
+// coordinator before the worker gives up
+const maxConnectFailures = 10
+
+// errLeaseLost is returned when the coordinator gave the chunk to another worker
+var errLeaseLost = errors.New("lease lost")
+
+// errJobDone is returned when the coordinator has no more work
+var errJobDone = errors.New("job done")
+
+// WorkerOptions contains options for a worker
+type WorkerOptions struct {
+	// Base URL of the coordinator, e.g. "http://render1:8090"
+	Coordinator string
+	// Name shown in the coordinator's log
+	Name string
+	// How long to wait before asking again when every chunk is leased
+	Poll time.Duration
+	// Local upscaler settings: GPUs, tile size, threads and batch size. The
+	// model and scale come from the coordinator
+	Upscaler upscaler.UpscalerOptions
+}
+
+// DefaultWorkerOptions returns default worker options
+func DefaultWorkerOptions() WorkerOptions {
+	name, err := os.Hostname()
+	if err != nil {
+		name = "worker"
+	}
+	return WorkerOptions{
+		Name:     fmt.Sprintf("%s-%d", name, os.Getpid()),
+		Poll:     5 * time.Second,
+		Upscaler: upscaler.DefaultOptions(),
+	}
+}
+
+// worker talks to one coordinator
+type worker struct {
+	options WorkerOptions
+	client  *http.Client
+}
+
+// Work upscales chunks leased from the coordinator until it has no more
+// work or ctx is cancelled
+func Work(ctx context.Context, options WorkerOptions) error {
+	options.Coordinator = strings.TrimSuffix(options.Coordinator, "/")
+	if !strings.Contains(options.Coordinator, "://") {
+		options.Coordinator = "http://" + options.Coordinator
+	}
+	w := &worker{options: options, client: &http.Client{Timeout: 5 * time.Minute}}
+
+	failures := 0
+	for ctx.Err() == nil {
+		lease, err := w.lease(ctx)
+		switch {
+		case errors.Is(err, errJobDone):
+			fmt.Println("The coordinator has no more work")
+			return nil
+		case err != nil:
+			failures++
+			if failures >= maxConnectFailures {
+				return fmt.Errorf("coordinator unreachable: %w", err)
+			}
+			fmt.Printf("Warning: %v\n", err)
+		case lease != nil:
+			failures = 0
+			if err := w.runChunk(ctx, lease); err != nil && ctx.Err() == nil {
+				fmt.Printf("Chunk %d failed: %v\n", lease.Chunk, err)
+			}
+			continue
+		default:
+			failures = 0
+		}
+
+		// Every chunk is leased, or the coordinator could not be reached
+		select {
+		case <-ctx.Done():
+		case <-time.After(options.Poll):
+		}
+	}
+	return ctx.Err()
+}
+
+// lease asks the coordinator for a chunk. It returns nil when every chunk
+// is leased and errJobDone when the job is over
+func (w *worker) lease(ctx context.Context) (*Lease, error) {
+	body, _ := json.Marshal(leaseRequest{Worker: w.options.Name})
+	resp, err := w.post(ctx, "/lease", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var lease Lease
+		if err := json.NewDecoder(resp.Body).Decode(&lease); err != nil {
+			return nil, fmt.Errorf("invalid lease: %w", err)
+		}
+		return &lease, nil
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusGone:
+		return nil, errJobDone
+	default:
+		return nil, responseError(resp)
+	}
+}
+
+// runChunk downloads the frames of a chunk, upscales them and uploads the
+// results, renewing the lease meanwhile. A chunk the upscaler fails on is
+// handed back to the coordinator, which gives up on it after a few failures.
+// A chunk that could not be transferred is not held against it, the
+// coordinator hands it out again once its lease expires
+func (w *worker) runChunk(ctx context.Context, lease *Lease) error {
+	fmt.Printf("Upscaling chunk %d: %d frames with %s x%d\n", lease.Chunk, len(lease.Frames), lease.Model, lease.Scale)
+
+	// Stop working on the chunk if the lease is lost
+	chunkCtx, cancel := context.WithCancelCause(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		w.keepLease(chunkCtx, lease, cancel)
+	}()
+	defer func() {
+		cancel(nil)
+		<-renewed
+	}()
+
+	err := w.upscaleChunk(chunkCtx, lease)
+	if cause := context.Cause(chunkCtx); errors.Is(cause, errLeaseLost) {
+		return cause
+	}
+	if errors.Is(err, errLeaseLost) {
+		return err
+	}
+	if err != nil {
+		if ctx.Err() == nil && errors.Is(err, errs.ErrUpscale) {
+			body, _ := json.Marshal(failRequest{Error: err.Error()})
+			if resp, postErr := w.post(ctx, w.chunkPath(lease, "fail"), body); postErr == nil {
+				resp.Body.Close()
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// upscaleChunk does the work of runChunk in a temporary directory
+func (w *worker) upscaleChunk(ctx context.Context, lease *Lease) error {
+	workDir, err := os.MkdirTemp("", fmt.Sprintf("videoup_chunk%d_", lease.Chunk))
+	if err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+	cleanup.RegisterDirectory(workDir)
+	defer func() {
+		os.RemoveAll(workDir)
+		cleanup.RemoveDirectory(workDir)
+	}()
+
+	inputDir := filepath.Join(workDir, "frames")
+	outputDir := filepath.Join(workDir, "upscaled")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+
+	for _, frame := range lease.Frames {
+		if err := w.download(ctx, frame, filepath.Join(inputDir, frame)); err != nil {
+			return err
+		}
+	}
+
+	options := w.options.Upscaler
+	options.Model = lease.Model
+	options.Scale = lease.Scale
+	options.IntermediateModels = lease.IntermediateModels
+	options.Context = ctx
+	if err := upscaler.UpscaleFrames(inputDir, outputDir, options); err != nil {
+		return errs.Upscale(err)
+	}
+
+	for _, frame := range lease.Frames {
+		if err := w.upload(ctx, lease, frame, filepath.Join(outputDir, frame)); err != nil {
+			return err
+		}
+	}
+
+	resp, err := w.post(ctx, w.chunkPath(lease, "complete"), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return responseError(resp)
+	}
+	fmt.Printf("Chunk %d done\n", lease.Chunk)
+	return nil
+}
+
+// keepLease renews the lease well before it expires until ctx is done. If
+// the coordinator gave the chunk away, the work is cancelled with errLeaseLost
+func (w *worker) keepLease(ctx context.Context, lease *Lease, cancel context.CancelCauseFunc) {
+	interval := time.Duration(lease.Seconds * float64(time.Second) / 3)
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		resp, err := w.post(ctx, w.chunkPath(lease, "renew"), nil)
+		if err != nil {
+			// Try again on the next tick, the lease has not expired yet
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusConflict {
+			fmt.Printf("Lease of chunk %d was lost, dropping it\n", lease.Chunk)
+			cancel(errLeaseLost)
+			return
+		}
+	}
+}
+
+// download fetches a source frame
+func (w *worker) download(ctx context.Context, frame, path string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.options.Coordinator+"/frames/"+url.PathEscape(frame), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", frame, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %w", frame, responseError(resp))
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", frame, err)
+	}
+	return nil
+}
+
+// upload sends an upscaled frame to the coordinator
+func (w *worker) upload(ctx context.Context, lease *Lease, frame, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open upscaled frame: %w", err)
+	}
+	defer file.Close()
+
+	target := fmt.Sprintf("%s/chunks/%d/frames/%s?token=%s", w.options.Coordinator,
+		lease.Chunk, url.PathEscape(frame), url.QueryEscape(lease.Token))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, file)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "image/png")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", frame, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return errLeaseLost
+	}
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to upload %s: %w", frame, responseError(resp))
+	}
+	return nil
+}
+
+// chunkPath returns the path of a chunk action with the lease token
+func (w *worker) chunkPath(lease *Lease, action string) string {
+	return fmt.Sprintf("/chunks/%d/%s?token=%s", lease.Chunk, action, url.QueryEscape(lease.Token))
+}
+
+// post sends a JSON request to the coordinator
+func (w *worker) post(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.options.Coordinator+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return w.client.Do(req)
+}
+
+// responseError turns an error response of the coordinator into an error
+func responseError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && body.Error != "" {
+		return fmt.Errorf("coordinator: %s (%s)", body.Error, resp.Status)
+	}
+	return fmt.Errorf("coordinator: %s", resp.Status)
+}
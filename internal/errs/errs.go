@@ -0,0 +1,223 @@
+// Package errs defines the kinds of errors a job can fail with, so callers
+// can tell them apart with errors.Is and errors.As instead of comparing
+// messages, and map them to exit codes and advice
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Sentinel errors for each kind of failure. Errors of a kind match its
+// sentinel with errors.Is
+var (
+	ErrMissingDependency = errors.New("missing dependency")
+	ErrProbe             = errors.New("probe failed")
+	ErrExtract           = errors.New("frame extraction failed")
+	ErrUpscale           = errors.New("upscaling failed")
+	ErrEncode            = errors.New("encoding failed")
+	ErrVerify            = errors.New("output verification failed")
+	ErrCancelled         = errors.New("cancelled")
+)
+
+// Exit codes for each kind of failure. 2 is used for invalid flags
+const (
+	ExitOK                = 0
+	ExitFailure           = 1
+	ExitUsage             = 2
+	ExitMissingDependency = 3
+	ExitProbe             = 4
+	ExitExtract           = 5
+	ExitUpscale           = 6
+	ExitEncode            = 7
+	ExitVerify            = 8
+	ExitCancelled         = 130
+)
+
+// maxListedFrames is the number of failed frames named in a remedy
+const maxListedFrames = 5
+
+// DependencyError reports a program videoup needs that is missing
+type DependencyError struct {
+	// Name of the program
+	Name string
+	// Where it was looked for
+	Location string
+	// What to do about it
+	Remedy string
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("%s is not installed or not found in %s", e.Name, e.Location)
+}
+
+// Is makes the error match ErrMissingDependency
+func (e *DependencyError) Is(target error) bool {
+	return target == ErrMissingDependency
+}
+
+// StageError is the failure of one stage of a job: probing, extraction or
+// encoding. It matches both its stage sentinel and the underlying error
+type StageError struct {
+	Stage error
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the stage sentinel and the underlying error
+func (e *StageError) Unwrap() []error {
+	return []error{e.Stage, e.Err}
+}
+
+// Probe marks err as a failure to read a video's properties
+func Probe(err error) error {
+	return &StageError{Stage: ErrProbe, Err: err}
+}
+
+// Extract marks err as a failure to extract frames
+func Extract(err error) error {
+	return &StageError{Stage: ErrExtract, Err: err}
+}
+
+// Encode marks err as a failure to write a video
+func Encode(err error) error {
+	return &StageError{Stage: ErrEncode, Err: err}
+}
+
+// UpscaleError is a failure of the upscaling stage, with the frames that
+// could not be upscaled when known
+type UpscaleError struct {
+	// Frames that failed
+	Failed []string
+	// Number of frames in the pass
+	Total int
+	// Underlying error, if any
+	Err error
+}
+
+func (e *UpscaleError) Error() string {
+	switch {
+	case len(e.Failed) > 0 && e.Err != nil:
+		return fmt.Sprintf("%v (%d of %d frames failed)", e.Err, len(e.Failed), e.Total)
+	case len(e.Failed) > 0:
+		return fmt.Sprintf("failed to upscale %d of %d frames (first: %s)", len(e.Failed), e.Total, filepath.Base(e.Failed[0]))
+	case e.Err != nil:
+		return e.Err.Error()
+	default:
+		return ErrUpscale.Error()
+	}
+}
+
+// Is makes the error match ErrUpscale
+func (e *UpscaleError) Is(target error) bool {
+	return target == ErrUpscale
+}
+
+// Unwrap returns the underlying error
+func (e *UpscaleError) Unwrap() error {
+	return e.Err
+}
+
+// Upscale marks err as a failure of the upscaling stage
+func Upscale(err error) error {
+	var upscaleErr *UpscaleError
+	if errors.As(err, &upscaleErr) {
+		return err
+	}
+	return &UpscaleError{Err: err}
+}
+
+// VerifyError reports an output that does not match what was expected from
+// the source
+type VerifyError struct {
+	// Path of the output
+	Path string
+	// What did not match, e.g. "frames: expected 240, got 200"
+	Mismatches []string
+	// Directories with the intermediate frames, kept for another attempt
+	Kept []string
+}
+
+func (e *VerifyError) Error() string {
+	return fmt.Sprintf("output %s failed verification: %s", filepath.Base(e.Path), strings.Join(e.Mismatches, "; "))
+}
+
+// Is makes the error match ErrVerify
+func (e *VerifyError) Is(target error) bool {
+	return target == ErrVerify
+}
+
+// ExitCode returns the process exit code for an error. A missing program
+// counts as a missing dependency even when it was only found missing when
+// it was run
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrCancelled):
+		return ExitCancelled
+	case errors.Is(err, ErrMissingDependency), errors.Is(err, exec.ErrNotFound):
+		return ExitMissingDependency
+	case errors.Is(err, ErrProbe):
+		return ExitProbe
+	case errors.Is(err, ErrExtract):
+		return ExitExtract
+	case errors.Is(err, ErrUpscale):
+		return ExitUpscale
+	case errors.Is(err, ErrEncode):
+		return ExitEncode
+	case errors.Is(err, ErrVerify):
+		return ExitVerify
+	default:
+		return ExitFailure
+	}
+}
+
+// Remedy returns advice on how to fix an error, or "" when there is none
+func Remedy(err error) string {
+	var dependencyErr *DependencyError
+	var upscaleErr *UpscaleError
+	var verifyErr *VerifyError
+	switch {
+	case err == nil, errors.Is(err, ErrCancelled):
+		return ""
+	case errors.As(err, &dependencyErr):
+		return dependencyErr.Remedy
+	case errors.Is(err, exec.ErrNotFound):
+		return "A required program is missing; run videoup doctor to see which one and how to install it"
+	case errors.Is(err, ErrProbe):
+		return "Make sure the file is a video ffprobe can read (try: ffprobe <file>)"
+	case errors.Is(err, ErrExtract):
+		return "Check that there is free disk space in the working directory and that ffmpeg can decode the source"
+	case errors.As(err, &upscaleErr):
+		remedy := "Lower -tile-size or the batch size, or run videoup doctor to check the GPUs"
+		if len(upscaleErr.Failed) > 0 {
+			var names []string
+			for i, frame := range upscaleErr.Failed {
+				if i == maxListedFrames {
+					names = append(names, fmt.Sprintf("and %d more", len(upscaleErr.Failed)-maxListedFrames))
+					break
+				}
+				names = append(names, filepath.Base(frame))
+			}
+			remedy += "\nFailed frames: " + strings.Join(names, ", ")
+		}
+		return remedy
+	case errors.As(err, &verifyErr):
+		remedy := "The output may be truncated or encoded with the wrong settings; check free disk space and the ffmpeg output above"
+		if len(verifyErr.Kept) > 0 {
+			remedy += "\nThe intermediate frames were kept in: " + strings.Join(verifyErr.Kept, ", ")
+		}
+		return remedy
+	case errors.Is(err, ErrEncode):
+		return "Check that there is free disk space next to the source and that ffmpeg has the encoders videoup uses (run videoup doctor)"
+	default:
+		return ""
+	}
+}
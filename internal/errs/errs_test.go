@@ -0,0 +1,112 @@
+package errs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"videoup/internal/errs"
+)
+
+func TestExitCode(t *testing.T) {
+	cause := errors.New("exit status 1")
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"nil", nil, errs.ExitOK},
+		{"plain error", cause, errs.ExitFailure},
+		{"cancelled", fmt.Errorf("job stopped: %w", errs.ErrCancelled), errs.ExitCancelled},
+		{"missing dependency", &errs.DependencyError{Name: "ffmpeg", Location: "PATH"}, errs.ExitMissingDependency},
+		{"program not found when run", errs.Extract(&exec.Error{Name: "ffmpeg", Err: exec.ErrNotFound}), errs.ExitMissingDependency},
+		{"probe", errs.Probe(cause), errs.ExitProbe},
+		{"extract", fmt.Errorf("clip.mp4: %w", errs.Extract(cause)), errs.ExitExtract},
+		{"encode wrapped twice", fmt.Errorf("job: %w", fmt.Errorf("encode: %w", errs.Encode(cause))), errs.ExitEncode},
+		{"upscale", errs.Upscale(cause), errs.ExitUpscale},
+		{"upscale with failed frames", fmt.Errorf("pass 2: %w", &errs.UpscaleError{Failed: []string{"frame_0001.png"}, Total: 10}), errs.ExitUpscale},
+		{"verify", fmt.Errorf("job: %w", &errs.VerifyError{Path: "clip_upscaled.mp4"}), errs.ExitVerify},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errs.ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStageErrorMatchesCause(t *testing.T) {
+	err := fmt.Errorf("clip.mp4: %w", errs.Encode(context.Canceled))
+	if !errors.Is(err, errs.ErrEncode) || !errors.Is(err, context.Canceled) {
+		t.Errorf("errors.Is(%v) does not match both the stage and the cause", err)
+	}
+	if errors.Is(err, errs.ErrExtract) {
+		t.Errorf("errors.Is(%v, ErrExtract) = true", err)
+	}
+	if err.Error() != "clip.mp4: context canceled" {
+		t.Errorf("Error() = %q", err.Error())
+	}
+}
+
+func TestUpscaleKeepsFailedFrames(t *testing.T) {
+	failed := &errs.UpscaleError{Failed: []string{"/tmp/frames/frame_0007.png"}, Total: 48}
+	if got := errs.Upscale(fmt.Errorf("pass 1: %w", failed)); !strings.Contains(got.Error(), "1 of 48 frames") {
+		t.Errorf("Upscale() replaced the frame details: %v", got)
+	}
+
+	var upscaleErr *errs.UpscaleError
+	if err := errs.Upscale(context.DeadlineExceeded); !errors.As(err, &upscaleErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Upscale(%v) = %#v, want an UpscaleError wrapping it", context.DeadlineExceeded, err)
+	}
+}
+
+func TestRemedy(t *testing.T) {
+	frames := make([]string, 8)
+	for i := range frames {
+		frames[i] = fmt.Sprintf("/work/temp_frames_clip/upscaled/frame_%04d.png", i+1)
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		contains []string
+	}{
+		{"dependency", fmt.Errorf("check: %w", &errs.DependencyError{Name: "realesrgan", Remedy: "Download realesrgan-ncnn-vulkan"}),
+			[]string{"Download realesrgan-ncnn-vulkan"}},
+		{"program not found", errs.Encode(exec.ErrNotFound), []string{"videoup doctor"}},
+		{"probe", errs.Probe(errors.New("invalid data")), []string{"ffprobe <file>"}},
+		{"extract", errs.Extract(errors.New("no space left")), []string{"free disk space"}},
+		{"encode", fmt.Errorf("write: %w", errs.Encode(errors.New("unknown encoder"))), []string{"encoders"}},
+		{"upscale", errs.Upscale(errors.New("vkCreateDevice failed")), []string{"-tile-size"}},
+		{"failed frames listed by name", fmt.Errorf("pass 1: %w", &errs.UpscaleError{Failed: frames, Total: 100}),
+			[]string{"Failed frames: frame_0001.png, frame_0002.png", "frame_0005.png, and 3 more"}},
+		{"verify with kept frames", fmt.Errorf("job: %w", &errs.VerifyError{Path: "clip_upscaled.mp4", Mismatches: []string{"frames: expected 240, got 200"}, Kept: []string{"/tmp/temp_frames_clip_123"}}),
+			[]string{"truncated", "kept in: /tmp/temp_frames_clip_123"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remedy := errs.Remedy(tt.err)
+			for _, want := range tt.contains {
+				if !strings.Contains(remedy, want) {
+					t.Errorf("Remedy() = %q, want it to contain %q", remedy, want)
+				}
+			}
+			if strings.Contains(remedy, "/work/") {
+				t.Errorf("Remedy() = %q, want frame names without their directory", remedy)
+			}
+		})
+	}
+
+	for _, err := range []error{nil, errs.ErrCancelled, errors.New("something else")} {
+		if remedy := errs.Remedy(err); remedy != "" {
+			t.Errorf("Remedy(%v) = %q, want none", err, remedy)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"videoup/internal/errs"
+)
+
+// hasAlphaPixelFormat reports whether an ffmpeg pixel format has an alpha channel
+func hasAlphaPixelFormat(pixFmt string) bool {
+	for _, prefix := range []string{"yuva", "rgba", "bgra", "argb", "abgr", "gbrap", "ya8", "ya16"} {
+		if strings.HasPrefix(pixFmt, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// decoderArgs returns the input options needed to decode the video with its
+// alpha channel. VP8/VP9 store alpha as side data that only libvpx decodes
+func decoderArgs(info *VideoInfo) []string {
+	if !info.HasAlpha {
+		return nil
+	}
+	switch info.CodecName {
+	case "vp9":
+		return []string{"-c:v", "libvpx-vp9"}
+	case "vp8":
+		return []string{"-c:v", "libvpx"}
+	}
+	return nil
+}
+
+// ExtractAlpha writes the alpha channel of every frame in framesDir to
+// outputDir as an RGB PNG sequence, so it can be upscaled like a normal frame
+func ExtractAlpha(framesDir, outputDir string, options Options) error {
+	// Create output directory if it doesn't exist
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create alpha directory: %w", err)
+	}
+
+	// -vf alphaextract: turn the alpha plane into a grayscale image
+	// format=rgb24: upscalers expect 8-bit RGB input
+	cmd := ffmpegCommand(options.commandContext(),
+		"-i", filepath.Join(framesDir, "frame_%04d.png"),
+		"-vf", "alphaextract,format=rgb24",
+		filepath.Join(outputDir, "frame_%04d.png"),
+	)
+
+	// Capture stdout and stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Run the command
+	if err := cmd.Run(); err != nil {
+		return errs.Extract(fmt.Errorf("ffmpeg alpha extraction failed: %w", err))
+	}
+
+	return nil
+}
+
+// MergeAlpha combines color frames with separately upscaled alpha frames and
+// writes RGBA PNGs to outputDir
+func MergeAlpha(colorDir, alphaDir, outputDir string, options Options) error {
+	// Create output directory if it doesn't exist
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// The alpha frames are RGB copies of the mask, take one channel as alpha
+	cmd := ffmpegCommand(options.commandContext(),
+		"-i", filepath.Join(colorDir, "frame_%04d.png"),
+		"-i", filepath.Join(alphaDir, "frame_%04d.png"),
+		"-filter_complex", "[1:v]format=gray[a];[0:v][a]alphamerge,format=rgba",
+		filepath.Join(outputDir, "frame_%04d.png"),
+	)
+
+	// Capture stdout and stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Run the command
+	if err := cmd.Run(); err != nil {
+		return errs.Encode(fmt.Errorf("ffmpeg alpha merge failed: %w", err))
+	}
+
+	return nil
+}
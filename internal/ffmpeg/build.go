@@ -0,0 +1,74 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// BuildInfo describes an installed ffmpeg or ffprobe binary
+type BuildInfo struct {
+	// Path of the binary
+	Path string
+	// Version string, e.g. "6.1.1"
+	Version string
+	// Options ffmpeg was configured with (--enable-libx265 ...)
+	Configuration string
+}
+
+// GetBuildInfo runs "<program> -version" and returns its version and build
+// configuration
+func GetBuildInfo(program string) (*BuildInfo, error) {
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not installed or not in PATH", program)
+	}
+
+	output, err := exec.Command(path, "-hide_banner", "-version").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s -version failed: %w\n%s", program, err, string(output))
+	}
+
+	info := &BuildInfo{Path: path}
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, program+" version "):
+			fields := strings.Fields(line)
+			info.Version = fields[2]
+		case strings.HasPrefix(line, "configuration:"):
+			info.Configuration = strings.TrimSpace(strings.TrimPrefix(line, "configuration:"))
+		}
+	}
+	if info.Version == "" {
+		return nil, fmt.Errorf("could not read the %s version", program)
+	}
+
+	return info, nil
+}
+
+// HasEncoder reports whether ffmpeg was built with the given encoder
+func HasEncoder(name string) bool {
+	return hasComponent("-encoders", name)
+}
+
+// HasDecoder reports whether ffmpeg was built with the given decoder
+func HasDecoder(name string) bool {
+	return hasComponent("-decoders", name)
+}
+
+// hasComponent looks for a name in the second column of an ffmpeg listing
+// such as -encoders, where each line is " V....D prores_ks  Apple ProRes"
+func hasComponent(listing, name string) bool {
+	output, err := exec.Command("ffmpeg", "-hide_banner", listing).Output()
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[1] == name {
+			return true
+		}
+	}
+	return false
+}
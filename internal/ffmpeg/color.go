@@ -0,0 +1,142 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ColorProperties describes how the pixel values of a video map to color
+type ColorProperties struct {
+	// Matrix is the YUV<->RGB matrix (ffmpeg colorspace name, e.g. "bt709")
+	Matrix string
+	// Primaries is the color primaries (e.g. "bt709", "bt470bg")
+	Primaries string
+	// Transfer is the transfer characteristic (e.g. "bt709", "smpte170m")
+	Transfer string
+	// Range is either "tv" (limited) or "pc" (full)
+	Range string
+}
+
+// ResolveColorProperties returns the color properties of a video, filling in
+// anything the source leaves untagged with the usual broadcast defaults
+func ResolveColorProperties(info *VideoInfo) ColorProperties {
+	props := ColorProperties{
+		Matrix:    tagValue(info.ColorSpace),
+		Primaries: tagValue(info.ColorPrimaries),
+		Transfer:  tagValue(info.ColorTransfer),
+		Range:     tagValue(info.ColorRange),
+	}
+
+	// RGB sources carry no YUV matrix; the output is YUV so pick one below
+	if props.Matrix == "gbr" {
+		props.Matrix = ""
+	}
+
+	// Untagged sources follow the convention used by most players:
+	// HD material is BT.709, SD material is BT.601 (PAL or NTSC flavour)
+	hd := info.Width >= 1280 || info.Height > 576
+	pal := !hd && (info.Height == 576 || (info.FrameRate > 24.5 && info.FrameRate < 25.5))
+
+	if props.Matrix == "" {
+		switch {
+		case hd:
+			props.Matrix = "bt709"
+		case pal:
+			props.Matrix = "bt470bg"
+		default:
+			props.Matrix = "smpte170m"
+		}
+	}
+
+	if props.Primaries == "" {
+		switch props.Matrix {
+		case "bt709", "smpte240m":
+			props.Primaries = props.Matrix
+		case "bt2020nc", "bt2020c":
+			props.Primaries = "bt2020"
+		case "bt470bg":
+			props.Primaries = "bt470bg"
+		default:
+			props.Primaries = "smpte170m"
+		}
+	}
+
+	if props.Transfer == "" {
+		switch props.Primaries {
+		case "bt709", "bt2020":
+			props.Transfer = "bt709"
+		case "bt470bg":
+			props.Transfer = "gamma28"
+		default:
+			props.Transfer = "smpte170m"
+		}
+	}
+
+	if props.Range == "" {
+		props.Range = "tv"
+		// JPEG-style YUV formats are full range by definition
+		if strings.HasPrefix(info.PixelFormat, "yuvj") {
+			props.Range = "pc"
+		}
+	}
+
+	return props
+}
+
+// scaleMatrix converts an ffmpeg colorspace name to the matrix name
+// understood by the scale filter's in_color_matrix/out_color_matrix options
+func scaleMatrix(colorSpace string) string {
+	switch colorSpace {
+	case "bt709":
+		return "bt709"
+	case "bt470bg":
+		return "bt470"
+	case "smpte170m":
+		return "smpte170m"
+	case "smpte240m":
+		return "smpte240m"
+	case "fcc":
+		return "fcc"
+	case "bt2020nc", "bt2020c":
+		return "bt2020"
+	default:
+		return "bt709"
+	}
+}
+
+// extractColorFilter returns a scale filter that converts the source to RGB
+// using the source's own matrix and range instead of ffmpeg's guess
+func extractColorFilter(props ColorProperties) string {
+	return fmt.Sprintf(
+		"scale=in_color_matrix=%s:in_range=%s:flags=spline+accurate_rnd+full_chroma_int",
+		scaleMatrix(props.Matrix), props.Range,
+	)
+}
+
+// encodeColorFilter returns a scale filter that converts RGB frames back to
+// YUV with the same matrix and range as the source
+func encodeColorFilter(props ColorProperties) string {
+	return fmt.Sprintf(
+		"scale=out_color_matrix=%s:out_range=%s:flags=spline+accurate_rnd+full_chroma_int",
+		scaleMatrix(props.Matrix), props.Range,
+	)
+}
+
+// colorTagArgs returns the ffmpeg output options that tag a stream with the
+// given color properties
+func colorTagArgs(props ColorProperties) []string {
+	return []string{
+		"-color_primaries", props.Primaries,
+		"-color_trc", props.Transfer,
+		"-colorspace", props.Matrix,
+		"-color_range", props.Range,
+	}
+}
+
+// tagValue normalizes an ffprobe color tag, treating "unknown" as empty
+func tagValue(value string) string {
+	if value == "unknown" || value == "unspecified" || value == "reserved" {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,207 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"videoup/internal/errs"
+)
+
+// CompareLayout is the layout of a comparison video
+type CompareLayout string
+
+const (
+	// CompareNone renders no comparison
+	CompareNone CompareLayout = ""
+	// CompareSideBySide puts the original and the upscaled video next to each other
+	CompareSideBySide CompareLayout = "sbs"
+	// CompareSplit shows the left half of the original and the right half of
+	// the upscaled video
+	CompareSplit CompareLayout = "split"
+	// CompareWipe moves a divider between the original and the upscaled
+	// video back and forth
+	CompareWipe CompareLayout = "wipe"
+)
+
+// wipePeriod is the time in seconds for the wipe to go across and back
+const wipePeriod = 8
+
+// ComparisonPath returns the path of the comparison video for an output
+func ComparisonPath(outputPath string) string {
+	ext := filepath.Ext(outputPath)
+	return strings.TrimSuffix(outputPath, ext) + "_compare" + ext
+}
+
+// RenderComparison renders a comparison between the original and the
+// upscaled video. The original is scaled to the upscaled size with
+// options.CompareFilter so the difference the upscaler makes is visible
+func RenderComparison(originalPath, upscaledPath, outputPath string, options Options) error {
+	return writeAtomically(outputPath, func(tempPath string) error {
+		return renderComparison(originalPath, upscaledPath, tempPath, options)
+	})
+}
+
+// renderComparison renders the comparison into outputPath
+func renderComparison(originalPath, upscaledPath, outputPath string, options Options) error {
+	original, err := GetVideoInfo(originalPath)
+	if err != nil {
+		return fmt.Errorf("failed to get original video info: %w", err)
+	}
+	upscaled, err := GetVideoInfo(upscaledPath)
+	if err != nil {
+		return fmt.Errorf("failed to get upscaled video info: %w", err)
+	}
+
+	width, height := upscaled.Width, upscaled.Height
+	scaleFlags := options.CompareFilter
+	if scaleFlags == "" {
+		scaleFlags = "bicubic"
+	}
+
+	// Both videos are compared in RGB, each converted with its own color
+	// properties, so tone-mapped outputs line up with their HDR original.
+	// The original is cropped like the upscaled frames but not cleaned up,
+	// so the comparison shows what the pre-filter did too
+	originalOptions := options
+	originalOptions.PreFilter = options.PreFilter.CropOnly()
+	originalFilter, _ := extractFilter(original, originalOptions)
+	upscaledColor := ResolveColorProperties(upscaled)
+	graph := []string{
+		fmt.Sprintf("[0:v]%s,format=gbrp16le,scale=%d:%d:flags=%s,setsar=1[orig]", originalFilter, width, height, scaleFlags),
+		fmt.Sprintf("[1:v]%s,format=gbrp16le,setsar=1[up]", extractColorFilter(upscaledColor)),
+	}
+
+	switch options.Compare {
+	case CompareSideBySide:
+		graph = append(graph, "[orig][up]hstack=inputs=2[cmp]")
+	case CompareSplit:
+		left := width / 2
+		graph = append(graph,
+			fmt.Sprintf("[orig]crop=%d:%d:0:0[left]", left, height),
+			fmt.Sprintf("[up]crop=%d:%d:%d:0[right]", width-left, height, left),
+			"[left][right]hstack=inputs=2[cmp]",
+		)
+	case CompareWipe:
+		// The divider follows a cosine so it slows down at the edges
+		graph = append(graph, fmt.Sprintf(
+			"[orig][up]blend=all_expr='if(lte(X\\,W*(0.5-0.5*cos(2*PI*T/%d)))\\,A\\,B)'[cmp]", wipePeriod))
+	default:
+		return fmt.Errorf("unknown comparison layout: %s", options.Compare)
+	}
+	graph = append(graph, fmt.Sprintf("[cmp]%s[v]", encodeColorFilter(upscaledColor)))
+
+	// A partial output is compared against the same range of the original
+	var seek []string
+	var duration []string
+	if options.HasRange() && !options.Splice {
+		start, end, err := ResolveRange(original, options)
+		if err != nil {
+			return err
+		}
+		seek = seekArgs(start, original.FrameRate)
+		duration = []string{"-frames:v", fmt.Sprintf("%d", end-start)}
+	}
+
+	// -shortest: stop when either video ends
+	args := append(decoderArgs(original), seek...)
+	args = append(args,
+		"-i", originalPath,
+		"-i", upscaledPath,
+		"-filter_complex", strings.Join(graph, ";"),
+		"-map", "[v]",
+		"-c:v", "prores_ks",
+		"-profile:v", "3",
+		"-pix_fmt", "yuv422p10le",
+		"-vendor", "ap10",
+		"-shortest",
+	)
+	args = append(args, duration...)
+	args = append(args, colorTagArgs(upscaledColor)...)
+	args = append(args, outputPath)
+
+	cmd := ffmpegCommand(options.commandContext(), args...)
+
+	// Capture stdout and stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Run the command
+	if err := cmd.Run(); err != nil {
+		return errs.Encode(fmt.Errorf("ffmpeg comparison failed: %w", err))
+	}
+
+	return nil
+}
+
+// RenderGrid stacks several videos into a grid, scaling each one to
+// width x height. Videos are placed left to right, top to bottom
+func RenderGrid(inputPaths []string, outputPath string, width, height int, options Options) error {
+	return writeAtomically(outputPath, func(tempPath string) error {
+		return renderGrid(inputPaths, tempPath, width, height, options)
+	})
+}
+
+// renderGrid renders the grid into outputPath
+func renderGrid(inputPaths []string, outputPath string, width, height int, options Options) error {
+	if len(inputPaths) == 0 {
+		return fmt.Errorf("no videos to put in the grid")
+	}
+
+	first, err := GetVideoInfo(inputPaths[0])
+	if err != nil {
+		return fmt.Errorf("failed to get video info: %w", err)
+	}
+	color := ResolveColorProperties(first)
+
+	// Use the squarest grid that fits every video
+	columns := 1
+	for columns*columns < len(inputPaths) {
+		columns++
+	}
+
+	var args []string
+	var graph []string
+	var labels string
+	var layout []string
+	for i, path := range inputPaths {
+		args = append(args, "-i", path)
+		graph = append(graph, fmt.Sprintf("[%d:v]scale=%d:%d:flags=bicubic,setsar=1[t%d]", i, width, height, i))
+		labels += fmt.Sprintf("[t%d]", i)
+		layout = append(layout, fmt.Sprintf("%d_%d", (i%columns)*width, (i/columns)*height))
+	}
+
+	if len(inputPaths) == 1 {
+		graph = append(graph, "[t0]null[v]")
+	} else {
+		graph = append(graph, fmt.Sprintf("%sxstack=inputs=%d:layout=%s:fill=black[v]",
+			labels, len(inputPaths), strings.Join(layout, "|")))
+	}
+
+	// -shortest: stop when the shortest video ends
+	args = append(args,
+		"-filter_complex", strings.Join(graph, ";"),
+		"-map", "[v]",
+		"-c:v", "prores_ks",
+		"-profile:v", "3",
+		"-pix_fmt", "yuv422p10le",
+		"-vendor", "ap10",
+		"-shortest",
+	)
+	args = append(args, colorTagArgs(color)...)
+	args = append(args, outputPath)
+
+	cmd := ffmpegCommand(options.commandContext(), args...)
+
+	// Capture stdout and stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Run the command
+	if err := cmd.Run(); err != nil {
+		return errs.Encode(fmt.Errorf("ffmpeg grid command failed: %w", err))
+	}
+
+	return nil
+}
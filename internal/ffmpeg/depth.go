@@ -0,0 +1,109 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"videoup/internal/errs"
+)
+
+// ReduceDepth writes 8-bit copies of the 16-bit frames in framesDir to
+// outputDir, for upscalers that only read 8 bits per component. The copies
+// are rounded, not dithered, so RestoreDepth can add back exactly what
+// was lost
+func ReduceDepth(framesDir, outputDir string, options Options) error {
+	info, err := ReadVideoInfo(framesDir)
+	if err != nil {
+		return err
+	}
+
+	// Create output directory if it doesn't exist
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create 8-bit frames directory: %w", err)
+	}
+
+	pixelFormat := "rgb24"
+	if info.HasAlpha {
+		pixelFormat = "rgba"
+	}
+
+	// sws_dither=none: round to the nearest 8-bit value, dither noise would
+	// be upscaled as detail
+	cmd := ffmpegCommand(options.commandContext(),
+		"-i", filepath.Join(framesDir, "frame_%04d.png"),
+		"-vf", "scale=sws_dither=none",
+		"-pix_fmt", pixelFormat,
+		filepath.Join(outputDir, "frame_%04d.png"),
+	)
+
+	// Capture stdout and stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Run the command
+	if err := cmd.Run(); err != nil {
+		return errs.Extract(fmt.Errorf("ffmpeg 8-bit conversion failed: %w", err))
+	}
+
+	// The copies are upscaled like extracted frames, which need the video info
+	info.BitDepth = 8
+	info.OutputDir = outputDir
+	return WriteVideoInfo(outputDir, info)
+}
+
+// RestoreDepth adds the precision ReduceDepth took away back to frames
+// upscaled from its 8-bit copies, writing 16-bit frames to outputDir. The
+// difference between the 16-bit frames in sourceDir and the 8-bit ones in
+// reducedDir is resized to the upscaled size and added to the frames in
+// upscaledDir, so gradients don't band
+func RestoreDepth(sourceDir, reducedDir, upscaledDir, outputDir string, options Options) error {
+	info, err := ReadVideoInfo(sourceDir)
+	if err != nil {
+		return err
+	}
+	width, height, err := FrameSize(upscaledDir)
+	if err != nil {
+		return err
+	}
+
+	// Create output directory if it doesn't exist
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create restored frames directory: %w", err)
+	}
+
+	// blend works on planar formats
+	planar, pixelFormat := "gbrp16le", "rgb48be"
+	if info.HasAlpha {
+		planar, pixelFormat = "gbrap16le", "rgba64be"
+	}
+
+	// [high]/[low]: the 16-bit and 8-bit source frames at the upscaled size
+	// A-B+32768: their difference, offset to stay positive
+	// A+B-32768: the difference added to the upscaled frames
+	filter := fmt.Sprintf("[0:v]scale=%[1]d:%[2]d:flags=lanczos,format=%[3]s[high];"+
+		"[1:v]scale=%[1]d:%[2]d:flags=lanczos,format=%[3]s[low];"+
+		"[high][low]blend=all_expr='clip(A-B+32768,0,65535)'[residual];"+
+		"[2:v]format=%[3]s[upscaled];"+
+		"[upscaled][residual]blend=all_expr='clip(A+B-32768,0,65535)',format=%[4]s",
+		width, height, planar, pixelFormat)
+
+	cmd := ffmpegCommand(options.commandContext(),
+		"-i", filepath.Join(sourceDir, "frame_%04d.png"),
+		"-i", filepath.Join(reducedDir, "frame_%04d.png"),
+		"-i", filepath.Join(upscaledDir, "frame_%04d.png"),
+		"-filter_complex", filter,
+		filepath.Join(outputDir, "frame_%04d.png"),
+	)
+
+	// Capture stdout and stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Run the command
+	if err := cmd.Run(); err != nil {
+		return errs.Encode(fmt.Errorf("ffmpeg bit depth restore failed: %w", err))
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package ffmpeg
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -10,42 +11,138 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"videoup/internal/errs"
 )
 
 // VideoInfo contains information about a video file
 type VideoInfo struct {
-	FrameRate     float64 `json:"frame_rate"`
-	Width         int     `json:"width"`
-	Height        int     `json:"height"`
-	TotalFrames   int     `json:"total_frames"`
-	Duration      float64 `json:"duration"`
-	FormatName    string  `json:"format_name"`
-	CodecName     string  `json:"codec_name"`
-	FileName      string  `json:"file_name"`
-	FilePath      string  `json:"file_path"`
-	OutputDir     string  `json:"output_dir"`
-	ExtractedTime string  `json:"extracted_time"`
+	FrameRate         float64 `json:"frame_rate"`
+	FrameRateRatio    string  `json:"frame_rate_ratio,omitempty"` // exact, e.g. "24000/1001"
+	Width             int     `json:"width"`
+	Height            int     `json:"height"`
+	TotalFrames       int     `json:"total_frames"`
+	FramesEstimated   bool    `json:"frames_estimated,omitempty"` // no nb_frames, TotalFrames is from the duration
+	Duration          float64 `json:"duration"`
+	FormatName        string  `json:"format_name"`
+	CodecName         string  `json:"codec_name"`
+	PixelFormat       string  `json:"pix_fmt"`
+	ColorSpace        string  `json:"color_space"`
+	ColorPrimaries    string  `json:"color_primaries"`
+	ColorTransfer     string  `json:"color_transfer"`
+	ColorRange        string  `json:"color_range"`
+	BitDepth          int     `json:"bit_depth"`
+	HasAlpha          bool    `json:"has_alpha"`
+	SampleAspectRatio string  `json:"sample_aspect_ratio"`
+	FileName          string  `json:"file_name"`
+	FilePath          string  `json:"file_path"`
+	OutputDir         string  `json:"output_dir"`
+	ExtractedTime     string  `json:"extracted_time"`
+
+	// Streams and chapters that can be carried over from the source
+	Subtitles   []StreamInfo `json:"subtitles,omitempty"`
+	Attachments []StreamInfo `json:"attachments,omitempty"`
+	Chapters    int          `json:"chapters"`
+
+	// HDR10 static metadata, only present for PQ sources that carry it
+	MasteringDisplay  *MasteringDisplay  `json:"mastering_display,omitempty"`
+	ContentLightLevel *ContentLightLevel `json:"content_light_level,omitempty"`
+}
+
+// Options contains options for frame extraction and encoding
+type Options struct {
+	// How HDR (PQ/HLG) sources are handled
+	HDR HDRMode
+	// Output container format
+	Container Container
+	// Start and End select part of the video (frame number, "60.5s" or
+	// timecode); empty means the beginning and the end of the video
+	Start string
+	End   string
+	// Splice puts the upscaled range back into a full-length output, with
+	// the rest of the video scaled conventionally
+	Splice bool
+	// Compare renders a comparison video after encoding, with the original
+	// scaled using CompareFilter ("neighbor" or "bicubic")
+	Compare       CompareLayout
+	CompareFilter string
+	// Metrics measures PSNR/SSIM/VMAF against the source after encoding
+	Metrics bool
+	// PreFilter deinterlaces, denoises, deblocks or crops the source before
+	// the frames are extracted
+	PreFilter PreFilter
+	// TargetWidth and TargetHeight resample the output to an exact
+	// resolution (0 follows the aspect ratio), fitted with TargetMode using
+	// the TargetFilter scaler and padded with PadColor
+	TargetWidth  int
+	TargetHeight int
+	TargetMode   TargetMode
+	TargetFilter string
+	PadColor     string
+	// Metadata is written to the output as container tags
+	Metadata map[string]string
+	// Context cancels the running ffmpeg command (nil for none)
+	Context context.Context `json:"-"`
+}
+
+// DefaultOptions returns default ffmpeg options
+func DefaultOptions() Options {
+	return Options{
+		HDR:           HDRPreserve,
+		Container:     ContainerMOV,
+		Compare:       CompareNone,
+		CompareFilter: "bicubic",
+		TargetMode:    TargetFit,
+		TargetFilter:  "lanczos",
+		PadColor:      "black",
+	}
 }
 
-// ExtractFrames extracts all frames from a video file to a PNG sequence
-func ExtractFrames(videoPath string, outputDir string) error {
+// ExtractFrames extracts all frames from a video file to a PNG sequence.
+// Sources above 8 bits per component are extracted as 16-bit PNGs
+func ExtractFrames(videoPath string, outputDir string, options Options) error {
 	// Create output directory if it doesn't exist
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	// Probe the source first so frames are converted with its color properties
+	info, err := GetVideoInfo(videoPath)
+	if err != nil {
+		return fmt.Errorf("failed to get video info: %w", err)
+	}
+	filter, pixelFormat := extractFilter(info, options)
+
+	// Only extract the selected range
+	start, end, err := ResolveRange(info, options)
+	if err != nil {
+		return err
+	}
+	var rangeArgs []string
+	if options.HasRange() {
+		rangeArgs = []string{"-frames:v", strconv.Itoa(end - start)}
+	}
+
 	// Construct the output pattern
 	outputPattern := filepath.Join(outputDir, "frame_%04d.png")
 
-	// Prepare the ffmpeg command to extract all frames
+	// Prepare the ffmpeg command to extract the frames
+	// -ss: frame-accurate seek to the start of the range
 	// -i: input file
+	// -vf: convert to RGB using the source's matrix and range
+	// -pix_fmt: 8-bit or 16-bit RGB(A) PNGs
 	// -q:v 1: highest quality for images
-	cmd := exec.Command(
-		"ffmpeg",
+	// -frames:v: number of frames in the range
+	args := append(decoderArgs(info), seekArgs(start, info.FrameRate)...)
+	args = append(args,
 		"-i", videoPath,
+		"-vf", filter,
+		"-pix_fmt", pixelFormat,
 		"-q:v", "1",
-		outputPattern,
 	)
+	args = append(args, rangeArgs...)
+	args = append(args, outputPattern)
+	cmd := ffmpegCommand(options.commandContext(), args...)
 
 	// Capture stdout and stderr
 	cmd.Stdout = os.Stdout
@@ -53,26 +150,80 @@ func ExtractFrames(videoPath string, outputDir string) error {
 
 	// Run the command
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg command failed: %w", err)
-	}
-
-	// Get video info and save it to the output directory
-	info, err := GetVideoInfo(videoPath)
-	if err != nil {
-		return fmt.Errorf("failed to get video info: %w", err)
+		return errs.Extract(fmt.Errorf("ffmpeg command failed: %w", err))
 	}
 
 	// Set output directory in the info
 	info.OutputDir = outputDir
 
 	// Save video info to a JSON file in the output directory
-	infoPath := filepath.Join(outputDir, "video_info.json")
+	return WriteVideoInfo(outputDir, info)
+}
+
+// extractFilter returns the filter that converts the source to RGB and the
+// PNG pixel format the frames are written in
+func extractFilter(info *VideoInfo, options Options) (string, string) {
+	color := ResolveColorProperties(info)
+
+	// Convert to RGB with the source's matrix, or tone-map HDR down to SDR
+	filter := extractColorFilter(color)
+	if info.IsHDR() && options.HDR == HDRToneMap {
+		filter = toneMapFilter(info, color)
+	}
+	// Clean up the source while it is still in its own format
+	if pre := options.PreFilter.filter(); pre != "" {
+		filter = pre + "," + filter
+	}
+
+	// Keep the extra precision of 10-bit and higher sources, and the alpha
+	// channel of transparent sources. The upscaler only gets 8 bits, see
+	// ReduceDepth and RestoreDepth
+	pixelFormat := "rgb24"
+	switch {
+	case info.HasAlpha && info.IsHighBitDepth():
+		pixelFormat = "rgba64be"
+	case info.HasAlpha:
+		pixelFormat = "rgba"
+	case info.IsHighBitDepth():
+		pixelFormat = "rgb48be"
+	}
+
+	return filter, pixelFormat
+}
+
+// frameRateArg returns the frame rate of the video for -framerate, exact so
+// 23.976 fps outputs don't drift from their source
+func (info *VideoInfo) frameRateArg() string {
+	if num, den, ok := strings.Cut(info.FrameRateRatio, "/"); ok && num != "0" && den != "0" {
+		return info.FrameRateRatio
+	}
+	return strconv.FormatFloat(info.FrameRate, 'f', -1, 64)
+}
+
+// ReadVideoInfo reads the video info saved by ExtractFrames in a frames directory
+func ReadVideoInfo(framesDir string) (*VideoInfo, error) {
+	infoData, err := os.ReadFile(filepath.Join(framesDir, "video_info.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read video info file: %w", err)
+	}
+
+	var info VideoInfo
+	if err := json.Unmarshal(infoData, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse video info file: %w", err)
+	}
+
+	return &info, nil
+}
+
+// WriteVideoInfo saves the video info of a frames directory, read back by
+// ReadVideoInfo
+func WriteVideoInfo(framesDir string, info *VideoInfo) error {
 	infoData, err := json.MarshalIndent(info, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal video info: %w", err)
 	}
 
-	if err := os.WriteFile(infoPath, infoData, 0644); err != nil {
+	if err := os.WriteFile(filepath.Join(framesDir, "video_info.json"), infoData, 0644); err != nil {
 		return fmt.Errorf("failed to write video info file: %w", err)
 	}
 
@@ -86,7 +237,7 @@ func GetVideoInfo(videoPath string) (*VideoInfo, error) {
 		"ffprobe",
 		"-v", "error",
 		"-select_streams", "v:0",
-		"-show_entries", "stream=width,height,r_frame_rate,codec_name,nb_frames",
+		"-show_entries", "stream=width,height,sample_aspect_ratio,r_frame_rate,codec_name,nb_frames,pix_fmt,bits_per_raw_sample,color_space,color_primaries,color_transfer,color_range:stream_tags=alpha_mode",
 		"-show_entries", "format=duration,format_name",
 		"-of", "default=noprint_wrappers=1",
 		videoPath,
@@ -94,7 +245,7 @@ func GetVideoInfo(videoPath string) (*VideoInfo, error) {
 
 	output, err := cmd.Output()
 	if err != nil {
-		return nil, fmt.Errorf("ffprobe command failed: %w", err)
+		return nil, errs.Probe(fmt.Errorf("ffprobe command failed: %w", err))
 	}
 
 	// Parse the output
@@ -119,7 +270,10 @@ func GetVideoInfo(videoPath string) (*VideoInfo, error) {
 			info.Width, _ = strconv.Atoi(value)
 		case "height":
 			info.Height, _ = strconv.Atoi(value)
+		case "sample_aspect_ratio":
+			info.SampleAspectRatio = value
 		case "r_frame_rate":
+			info.FrameRateRatio = value
 			// Parse frame rate (usually in the format "num/den")
 			if strings.Contains(value, "/") {
 				frParts := strings.Split(value, "/")
@@ -141,12 +295,53 @@ func GetVideoInfo(videoPath string) (*VideoInfo, error) {
 			info.Duration, _ = strconv.ParseFloat(value, 64)
 		case "format_name":
 			info.FormatName = value
+		case "pix_fmt":
+			info.PixelFormat = value
+		case "color_space":
+			info.ColorSpace = value
+		case "color_primaries":
+			info.ColorPrimaries = value
+		case "color_transfer":
+			info.ColorTransfer = value
+		case "color_range":
+			info.ColorRange = value
+		case "bits_per_raw_sample":
+			info.BitDepth, _ = strconv.Atoi(value)
+		case "TAG:alpha_mode":
+			// VP8/VP9 in WebM signal alpha with a stream tag
+			if value == "1" {
+				info.HasAlpha = true
+			}
 		}
 	}
 
 	// If nb_frames is not available, estimate from duration and frame rate
 	if info.TotalFrames == 0 && info.Duration > 0 && info.FrameRate > 0 {
 		info.TotalFrames = int(info.Duration * info.FrameRate)
+		info.FramesEstimated = true
+	}
+
+	// bits_per_raw_sample is often not reported, fall back to the pixel format
+	if info.BitDepth == 0 {
+		info.BitDepth = pixelFormatBitDepth(info.PixelFormat)
+	}
+
+	if hasAlphaPixelFormat(info.PixelFormat) {
+		info.HasAlpha = true
+	}
+
+	// HDR10 static metadata lives in the frame side data
+	if info.ColorTransfer == "smpte2084" {
+		info.MasteringDisplay, info.ContentLightLevel, err = probeHDRMetadata(videoPath)
+		if err != nil {
+			return nil, errs.Probe(fmt.Errorf("failed to read HDR metadata: %w", err))
+		}
+	}
+
+	// Subtitles, attachments and chapters are carried over at encoding
+	info.Subtitles, info.Attachments, info.Chapters, err = probeStreams(videoPath)
+	if err != nil {
+		return nil, errs.Probe(fmt.Errorf("failed to read streams: %w", err))
 	}
 
 	// Add current time
@@ -154,7 +349,7 @@ func GetVideoInfo(videoPath string) (*VideoInfo, error) {
 
 	// Check if scanner encountered any errors
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error scanning ffprobe output: %w", err)
+		return nil, errs.Probe(fmt.Errorf("error scanning ffprobe output: %w", err))
 	}
 
 	return info, nil
@@ -172,11 +367,10 @@ func CreateTempDir(videoPath string) (string, error) {
 		return "", fmt.Errorf("failed to get current working directory: %w", err)
 	}
 
-	// Create a directory named "temp_frames_{videoName}"
-	tempDir := filepath.Join(cwd, fmt.Sprintf("temp_frames_%s", videoName))
-
-	// Create the directory if it doesn't exist
-	if err := os.MkdirAll(tempDir, 0755); err != nil {
+	// Create a directory named "temp_frames_{videoName}_{random}", unique so
+	// jobs on videos with the same name never share their frames
+	tempDir, err := os.MkdirTemp(cwd, fmt.Sprintf("temp_frames_%s_", videoName))
+	if err != nil {
 		return "", fmt.Errorf("failed to create temp directory: %w", err)
 	}
 
@@ -195,35 +389,131 @@ func IsFFprobeInstalled() bool {
 	return err == nil
 }
 
-// CombineFramesToVideo combines PNG frames into a video file
-func CombineFramesToVideo(framesDir, outputPath string, info *VideoInfo) error {
+// CombineFramesToVideo combines PNG frames into a video file.
+// Sources with alpha are encoded as ProRes 4444. HDR10 sources kept as HDR
+// are encoded as 10-bit HEVC, as ProRes cannot carry the mastering display
+// metadata
+func CombineFramesToVideo(framesDir, outputPath string, info *VideoInfo, options Options) error {
 	// Create output directory if it doesn't exist
 	outputDir := filepath.Dir(outputPath)
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	return writeAtomically(outputPath, func(tempPath string) error {
+		return encodeFrames(framesDir, tempPath, info, options)
+	})
+}
+
+// encodeFrames encodes PNG frames into outputPath
+func encodeFrames(framesDir, outputPath string, info *VideoInfo, options Options) error {
+
 	// Construct the input pattern
 	inputPattern := filepath.Join(framesDir, "frame_%04d.png")
 
+	// Convert back to YUV with the source's matrix and range, and tag the
+	// output so players interpret it the same way as the source
+	color := ResolveColorProperties(info)
+	if info.IsHDR() && options.HDR == HDRToneMap {
+		// Frames were tone-mapped to SDR at extraction
+		color = toneMappedColor
+	}
+
+	// Resample to the target resolution before converting to YUV
+	outputFilter := encodeColorFilter(color)
+	if options.HasTarget() {
+		outputFilter = targetFilter(info, options) + "," + outputFilter
+	}
+
+	start, end, err := ResolveRange(info, options)
+	if err != nil {
+		return err
+	}
+
 	// Prepare the ffmpeg command
 	// -framerate: set the frame rate
-	// -i: input file pattern
-	// -c:v: video codec (prores_ks is compatible with Adobe products)
-	//       NOTE: ProRes codec requires a MOV container, not MP4
-	// -profile:v: ProRes profile (3 is ProRes HQ, good balance of quality and size)
-	// -pix_fmt: pixel format (yuv422p10le for ProRes)
-	// -vendor: vendor string
-	cmd := exec.Command(
-		"ffmpeg",
-		"-framerate", fmt.Sprintf("%.2f", info.FrameRate),
+	// -i: input file pattern, then the source for subtitles and chapters
+	args := []string{
+		"-framerate", info.frameRateArg(),
 		"-i", inputPattern,
-		"-c:v", "prores_ks",
-		"-profile:v", "3",
-		"-pix_fmt", "yuv422p10le",
-		"-vendor", "ap10",
-		outputPath,
-	)
+	}
+
+	if options.HasRange() && options.Splice {
+		// The source also provides the video outside the range, scaled
+		// conventionally to the size of the upscaled frames
+		width, height, err := FrameSize(framesDir)
+		if err != nil {
+			return err
+		}
+		sourceFilter, pixelFormat := extractFilter(info, options)
+		args = append(args, decoderArgs(info)...)
+		args = append(args,
+			"-i", info.FilePath,
+			"-filter_complex", spliceFilter(sourceFilter+",format="+pixelFormat,
+				width, height, start, end, info.TotalFrames, outputFilter),
+			"-map", "[v]",
+		)
+	} else {
+		// A partial output only carries the subtitles and chapters of its range
+		// -map 0:v: video from the upscaled frames
+		// -vf: resampling to the target, then explicit RGB to YUV conversion
+		//      matching the source
+		if options.HasRange() {
+			args = append(args, seekArgs(start, info.FrameRate)...)
+			args = append(args, "-t", strconv.FormatFloat(float64(end-start)/info.FrameRate, 'f', 6, 64))
+		}
+		args = append(args,
+			"-i", info.FilePath,
+			"-map", "0:v",
+			"-vf", outputFilter,
+		)
+	}
+	args = append(args, PlanPassthrough(info, options.Container).Args...)
+
+	if info.HasAlpha {
+		// -profile:v 4: ProRes 4444, the ProRes flavour that carries alpha
+		// -alpha_bits 16: keep the full precision of the alpha channel
+		args = append(args,
+			"-c:v", "prores_ks",
+			"-profile:v", "4",
+			"-pix_fmt", "yuva444p10le",
+			"-alpha_bits", "16",
+			"-vendor", "ap10",
+		)
+	} else if info.ColorTransfer == "smpte2084" && options.HDR == HDRPreserve {
+		// HDR10: 10-bit HEVC with the mastering display and content light
+		// level metadata in the bitstream
+		args = append(args,
+			"-c:v", "libx265",
+			"-preset", "slow",
+			"-crf", "12",
+			"-pix_fmt", "yuv420p10le",
+			"-x265-params", x265HDRParams(color, info.MasteringDisplay, info.ContentLightLevel),
+		)
+		// -tag:v hvc1: HEVC tag expected by Apple and Adobe software
+		if options.Container == ContainerMOV {
+			args = append(args, "-tag:v", "hvc1")
+		}
+	} else {
+		// -c:v: video codec (prores_ks is compatible with Adobe products)
+		//       NOTE: ProRes codec requires a MOV or MKV container, not MP4
+		// -profile:v: ProRes profile (3 is ProRes HQ, good balance of quality and size)
+		// -pix_fmt: pixel format (yuv422p10le for ProRes)
+		// -vendor: vendor string
+		args = append(args,
+			"-c:v", "prores_ks",
+			"-profile:v", "3",
+			"-pix_fmt", "yuv422p10le",
+			"-vendor", "ap10",
+		)
+	}
+
+	// -color_*: color tags matching the source (PQ/HLG signalling is kept)
+	args = append(args, colorTagArgs(color)...)
+	args = append(args, metadataArgs(options.Metadata, options.Container)...)
+	args = append(args, outputPath)
+
+	cmd := ffmpegCommand(options.commandContext(), args...)
 
 	// Capture stdout and stderr
 	cmd.Stdout = os.Stdout
@@ -231,7 +521,7 @@ func CombineFramesToVideo(framesDir, outputPath string, info *VideoInfo) error {
 
 	// Run the command
 	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("ffmpeg command failed: %w", err)
+		return errs.Encode(fmt.Errorf("ffmpeg command failed: %w", err))
 	}
 
 	return nil
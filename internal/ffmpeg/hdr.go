@@ -0,0 +1,220 @@
+package ffmpeg
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// HDRMode controls how HDR sources are handled
+type HDRMode string
+
+const (
+	// HDRPreserve keeps PQ/HLG signalling from extraction through encoding
+	HDRPreserve HDRMode = "preserve"
+	// HDRToneMap tone-maps HDR sources to BT.709 SDR at extraction
+	HDRToneMap HDRMode = "tonemap"
+)
+
+// MasteringDisplay holds HDR10 mastering display metadata (SMPTE ST 2086).
+// Chromaticities are CIE 1931 xy coordinates, luminance is in cd/m²
+type MasteringDisplay struct {
+	RedX         float64 `json:"red_x"`
+	RedY         float64 `json:"red_y"`
+	GreenX       float64 `json:"green_x"`
+	GreenY       float64 `json:"green_y"`
+	BlueX        float64 `json:"blue_x"`
+	BlueY        float64 `json:"blue_y"`
+	WhitePointX  float64 `json:"white_point_x"`
+	WhitePointY  float64 `json:"white_point_y"`
+	MinLuminance float64 `json:"min_luminance"`
+	MaxLuminance float64 `json:"max_luminance"`
+}
+
+// ContentLightLevel holds HDR10 content light level metadata in cd/m²
+type ContentLightLevel struct {
+	MaxContent int `json:"max_content"`
+	MaxAverage int `json:"max_average"`
+}
+
+// IsHDR reports whether the video uses a PQ or HLG transfer function
+func (info *VideoInfo) IsHDR() bool {
+	return info.ColorTransfer == "smpte2084" || info.ColorTransfer == "arib-std-b67"
+}
+
+// IsHighBitDepth reports whether the video stores more than 8 bits per component
+func (info *VideoInfo) IsHighBitDepth() bool {
+	return info.BitDepth > 8
+}
+
+// pixelFormatBitDepth derives the bit depth from an ffmpeg pixel format name,
+// e.g. "yuv420p10le" is 10-bit. Formats without a suffix are 8-bit
+func pixelFormatBitDepth(pixFmt string) int {
+	name := strings.TrimSuffix(strings.TrimSuffix(pixFmt, "le"), "be")
+	end := len(name)
+	start := end
+	for start > 0 && name[start-1] >= '0' && name[start-1] <= '9' {
+		start--
+	}
+	if start == end || start == 0 {
+		return 8
+	}
+
+	// Packed RGB formats such as rgb48 or rgba64 carry the total bit count,
+	// and NV formats carry the chroma subsampling instead of a depth
+	depth, _ := strconv.Atoi(name[start:end])
+	switch {
+	case strings.HasPrefix(name, "nv"):
+		return 8
+	case strings.HasPrefix(name, "rgba") || strings.HasPrefix(name, "bgra"):
+		depth /= 4
+	case strings.HasPrefix(name, "rgb") || strings.HasPrefix(name, "bgr"):
+		depth /= 3
+	}
+	if depth < 8 || depth > 16 {
+		return 8
+	}
+	return depth
+}
+
+// probeHDRMetadata reads the mastering display and content light level side
+// data attached to the first frame of the video, if any
+func probeHDRMetadata(videoPath string) (*MasteringDisplay, *ContentLightLevel, error) {
+	cmd := exec.Command(
+		"ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-read_intervals", "%+#1",
+		"-show_frames",
+		"-show_entries", "frame=side_data_list",
+		"-of", "json",
+		videoPath,
+	)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("ffprobe command failed: %w", err)
+	}
+
+	var result struct {
+		Frames []struct {
+			SideDataList []map[string]interface{} `json:"side_data_list"`
+		} `json:"frames"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	var mastering *MasteringDisplay
+	var light *ContentLightLevel
+	for _, frame := range result.Frames {
+		for _, sideData := range frame.SideDataList {
+			switch sideData["side_data_type"] {
+			case "Mastering display metadata":
+				mastering = &MasteringDisplay{
+					RedX:         parseRational(sideData["red_x"]),
+					RedY:         parseRational(sideData["red_y"]),
+					GreenX:       parseRational(sideData["green_x"]),
+					GreenY:       parseRational(sideData["green_y"]),
+					BlueX:        parseRational(sideData["blue_x"]),
+					BlueY:        parseRational(sideData["blue_y"]),
+					WhitePointX:  parseRational(sideData["white_point_x"]),
+					WhitePointY:  parseRational(sideData["white_point_y"]),
+					MinLuminance: parseRational(sideData["min_luminance"]),
+					MaxLuminance: parseRational(sideData["max_luminance"]),
+				}
+			case "Content light level metadata":
+				light = &ContentLightLevel{
+					MaxContent: int(parseRational(sideData["max_content"])),
+					MaxAverage: int(parseRational(sideData["max_average"])),
+				}
+			}
+		}
+	}
+
+	return mastering, light, nil
+}
+
+// parseRational parses an ffprobe value that is either a number or a
+// "num/den" string
+func parseRational(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		if num, den, ok := strings.Cut(v, "/"); ok {
+			n, _ := strconv.ParseFloat(num, 64)
+			d, _ := strconv.ParseFloat(den, 64)
+			if d == 0 {
+				return 0
+			}
+			return n / d
+		}
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// toneMappedColor is how tone-mapped frames are encoded
+var toneMappedColor = ColorProperties{Matrix: "bt709", Primaries: "bt709", Transfer: "bt709", Range: "tv"}
+
+// toneMapFilter returns a zscale/tonemap chain that converts a PQ or HLG
+// source to BT.709 SDR RGB
+func toneMapFilter(info *VideoInfo, props ColorProperties) string {
+	return fmt.Sprintf(
+		"zscale=tin=%s:min=%s:pin=%s:rin=%s:t=linear:npl=100,format=gbrpf32le,"+
+			"zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=full",
+		info.ColorTransfer, props.Matrix, props.Primaries, rangeName(props.Range),
+	)
+}
+
+// x265HDRParams returns the libx265 parameters that signal HDR10 and carry the
+// source's mastering display and content light level metadata
+func x265HDRParams(props ColorProperties, mastering *MasteringDisplay, light *ContentLightLevel) string {
+	rangeParam := "limited"
+	if props.Range == "pc" {
+		rangeParam = "full"
+	}
+
+	params := []string{
+		"colorprim=" + props.Primaries,
+		"transfer=" + props.Transfer,
+		"colormatrix=" + props.Matrix,
+		"range=" + rangeParam,
+		"hdr10=1",
+		"hdr10-opt=1",
+		"repeat-headers=1",
+	}
+
+	// master-display uses units of 0.00002 for chromaticity and
+	// 0.0001 cd/m² for luminance
+	if mastering != nil {
+		chroma := func(v float64) int { return int(math.Round(v * 50000)) }
+		lum := func(v float64) int { return int(math.Round(v * 10000)) }
+		params = append(params, fmt.Sprintf(
+			"master-display=G(%d,%d)B(%d,%d)R(%d,%d)WP(%d,%d)L(%d,%d)",
+			chroma(mastering.GreenX), chroma(mastering.GreenY),
+			chroma(mastering.BlueX), chroma(mastering.BlueY),
+			chroma(mastering.RedX), chroma(mastering.RedY),
+			chroma(mastering.WhitePointX), chroma(mastering.WhitePointY),
+			lum(mastering.MaxLuminance), lum(mastering.MinLuminance),
+		))
+	}
+	if light != nil {
+		params = append(params, fmt.Sprintf("max-cll=%d,%d", light.MaxContent, light.MaxAverage))
+	}
+
+	return strings.Join(params, ":")
+}
+
+// rangeName converts an ffmpeg color range tag to the zscale range name
+func rangeName(colorRange string) string {
+	if colorRange == "pc" {
+		return "full"
+	}
+	return "limited"
+}
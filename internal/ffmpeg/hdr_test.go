@@ -0,0 +1,89 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPixelFormatBitDepth(t *testing.T) {
+	depths := map[string]int{
+		"yuv420p":      8,
+		"yuvj420p":     8,
+		"nv12":         8,
+		"yuv420p10le":  10,
+		"yuv422p10be":  10,
+		"p010le":       10,
+		"yuv444p12le":  12,
+		"yuva444p16le": 16,
+		"rgb24":        8,
+		"rgb48be":      16,
+		"rgba64le":     16,
+		"gbrp10le":     10,
+		"gray":         8,
+	}
+	for pixFmt, want := range depths {
+		if got := pixelFormatBitDepth(pixFmt); got != want {
+			t.Errorf("pixelFormatBitDepth(%q) = %d, want %d", pixFmt, got, want)
+		}
+	}
+}
+
+func TestExtractPixelFormat(t *testing.T) {
+	// 10-bit and higher sources keep their precision in 16-bit PNGs, the
+	// upscaler gets 8-bit copies from ReduceDepth
+	tests := []struct {
+		bitDepth int
+		hasAlpha bool
+		want     string
+	}{
+		{8, false, "rgb24"},
+		{8, true, "rgba"},
+		{10, false, "rgb48be"},
+		{12, true, "rgba64be"},
+	}
+	for _, tt := range tests {
+		info := &VideoInfo{Width: 1920, Height: 1080, BitDepth: tt.bitDepth, HasAlpha: tt.hasAlpha}
+		if _, got := extractFilter(info, DefaultOptions()); got != tt.want {
+			t.Errorf("extractFilter(%d-bit, alpha %v) pixel format = %q, want %q", tt.bitDepth, tt.hasAlpha, got, tt.want)
+		}
+	}
+}
+
+func TestReferenceFilterToneMapsLikeExtraction(t *testing.T) {
+	hdr := &VideoInfo{Width: 3840, Height: 2160, ColorTransfer: "smpte2084", ColorSpace: "bt2020nc", ColorPrimaries: "bt2020", BitDepth: 10}
+	sdr := &VideoInfo{Width: 1920, Height: 1080, BitDepth: 8}
+
+	tonemap := DefaultOptions()
+	tonemap.HDR = HDRToneMap
+	preserve := DefaultOptions()
+	preserve.HDR = HDRPreserve
+
+	// Only an HDR source measured against a tone-mapped output is tone-mapped
+	tests := []struct {
+		name    string
+		info    *VideoInfo
+		options Options
+		want    bool
+	}{
+		{"hdr tonemap", hdr, tonemap, true},
+		{"hdr preserve", hdr, preserve, false},
+		{"sdr tonemap", sdr, tonemap, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := referenceFilter(tt.info, tt.options)
+			if got := strings.Contains(filter, "tonemap="); got != tt.want {
+				t.Errorf("referenceFilter() = %q, tone-mapped %v, want %v", filter, got, tt.want)
+			}
+			if !strings.HasSuffix(filter, "format=yuv444p10le") {
+				t.Errorf("referenceFilter() = %q, want it to end in the compared format", filter)
+			}
+		})
+	}
+
+	// The tone-mapped reference is converted to YUV like the output was
+	filter := referenceFilter(hdr, tonemap)
+	if want := encodeColorFilter(toneMappedColor); !strings.Contains(filter, want) {
+		t.Errorf("referenceFilter() = %q, want it to contain %q", filter, want)
+	}
+}
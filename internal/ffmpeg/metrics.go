@@ -0,0 +1,287 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maxPSNR is reported for identical frames, whose PSNR is infinite
+const maxPSNR = 100.0
+
+// FrameMetrics holds the quality metrics of a single frame
+type FrameMetrics struct {
+	Frame int      `json:"frame"`
+	PSNR  float64  `json:"psnr"`
+	SSIM  float64  `json:"ssim"`
+	VMAF  *float64 `json:"vmaf,omitempty"`
+}
+
+// MetricSummary summarizes a metric over all frames
+type MetricSummary struct {
+	Mean float64 `json:"mean"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+}
+
+// QualityReport holds the quality metrics of an upscaled video measured
+// against its source, after downscaling it back to the source resolution
+type QualityReport struct {
+	Reference string         `json:"reference"`
+	Upscaled  string         `json:"upscaled"`
+	Width     int            `json:"width"`
+	Height    int            `json:"height"`
+	PSNR      MetricSummary  `json:"psnr"`
+	SSIM      MetricSummary  `json:"ssim"`
+	VMAF      *MetricSummary `json:"vmaf,omitempty"`
+	Frames    []FrameMetrics `json:"frames"`
+
+	// The HDR reference was tone-mapped to SDR like the upscaled frames
+	ToneMapped bool `json:"tone_mapped,omitempty"`
+}
+
+// referenceFilter returns the filter that brings the reference to what the
+// upscaled video is compared as: 10-bit 4:4:4, cropped like the upscaled
+// frames were and tone-mapped to SDR when they were
+func referenceFilter(reference *VideoInfo, options Options) string {
+	filter := "format=yuv444p10le"
+	if reference.IsHDR() && options.HDR == HDRToneMap {
+		filter = toneMapFilter(reference, ResolveColorProperties(reference)) + "," +
+			encodeColorFilter(toneMappedColor) + "," + filter
+	}
+	if crop := options.PreFilter.CropOnly().filter(); crop != "" {
+		filter = crop + "," + filter
+	}
+	return filter
+}
+
+// HasFilter reports whether ffmpeg was built with the given filter
+func HasFilter(name string) bool {
+	return hasComponent("-filters", name)
+}
+
+// ComputeQualityMetrics downscales the upscaled video back to the resolution
+// of the reference and measures PSNR, SSIM and, when ffmpeg has libvmaf, VMAF
+func ComputeQualityMetrics(referencePath, upscaledPath string, options Options) (*QualityReport, error) {
+	reference, err := GetVideoInfo(referencePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reference video info: %w", err)
+	}
+
+	// The filters write their per-frame logs into a temp directory, which is
+	// also the working directory so the log paths need no escaping
+	statsDir, err := os.MkdirTemp("", "videoup_metrics_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metrics directory: %w", err)
+	}
+	defer os.RemoveAll(statsDir)
+
+	withVMAF := HasFilter("libvmaf")
+	outputs := 2
+	if withVMAF {
+		outputs = 3
+	}
+
+	width, height := SourceSize(reference, options)
+	graph := []string{
+		fmt.Sprintf("[0:v]%s,setsar=1,split=%d[ref0][ref1]%s", referenceFilter(reference, options), outputs, vmafLabel(withVMAF, "ref2")),
+		fmt.Sprintf("[1:v]scale=%d:%d:flags=bicubic,format=yuv444p10le,setsar=1,split=%d[up0][up1]%s",
+			width, height, outputs, vmafLabel(withVMAF, "up2")),
+		"[up0][ref0]psnr=stats_file=psnr.log",
+		"[up1][ref1]ssim=stats_file=ssim.log",
+	}
+	if withVMAF {
+		graph = append(graph, "[up2][ref2]libvmaf=log_fmt=json:log_path=vmaf.json")
+	}
+
+	// A partial output is measured against the same range of the reference
+	var seek []string
+	if options.HasRange() && !options.Splice {
+		start, _, err := ResolveRange(reference, options)
+		if err != nil {
+			return nil, err
+		}
+		seek = seekArgs(start, reference.FrameRate)
+	}
+
+	absReference, err := filepath.Abs(referencePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	absUpscaled, err := filepath.Abs(upscaledPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	// -shortest: stop at the end of the shorter video
+	// -f null: only the filter logs are wanted
+	args := append(seek, "-i", absReference, "-i", absUpscaled,
+		"-filter_complex", strings.Join(graph, ";"),
+		"-shortest",
+		"-f", "null", "-",
+	)
+	cmd := ffmpegCommand(options.commandContext(), args...)
+	cmd.Dir = statsDir
+
+	// Capture stdout and stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Run the command
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ffmpeg metrics command failed: %w", err)
+	}
+
+	report := &QualityReport{
+		Reference:  referencePath,
+		Upscaled:   upscaledPath,
+		ToneMapped: reference.IsHDR() && options.HDR == HDRToneMap,
+		Width:      width,
+		Height:     height,
+	}
+
+	if err := parseStatsLog(filepath.Join(statsDir, "psnr.log"), "psnr_avg", report, func(frame *FrameMetrics, value float64) {
+		frame.PSNR = value
+	}); err != nil {
+		return nil, err
+	}
+	if err := parseStatsLog(filepath.Join(statsDir, "ssim.log"), "All", report, func(frame *FrameMetrics, value float64) {
+		frame.SSIM = value
+	}); err != nil {
+		return nil, err
+	}
+	if withVMAF {
+		if err := parseVMAFLog(filepath.Join(statsDir, "vmaf.json"), report); err != nil {
+			return nil, err
+		}
+	}
+
+	report.summarize()
+	return report, nil
+}
+
+// vmafLabel returns the extra split output used for VMAF, if enabled
+func vmafLabel(withVMAF bool, label string) string {
+	if !withVMAF {
+		return ""
+	}
+	return "[" + label + "]"
+}
+
+// parseStatsLog reads a psnr or ssim stats file, where each line holds
+// "key:value" pairs starting with the frame number "n:1"
+func parseStatsLog(path, key string, report *QualityReport, set func(*FrameMetrics, float64)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open metrics log: %w", err)
+	}
+	defer file.Close()
+
+	index := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		frame := -1
+		value := math.NaN()
+		for _, field := range strings.Fields(scanner.Text()) {
+			name, raw, ok := strings.Cut(field, ":")
+			if !ok {
+				continue
+			}
+			switch name {
+			case "n":
+				frame, _ = strconv.Atoi(raw)
+			case key:
+				if raw == "inf" {
+					value = maxPSNR
+				} else {
+					value, _ = strconv.ParseFloat(raw, 64)
+				}
+			}
+		}
+		if frame < 0 || math.IsNaN(value) {
+			continue
+		}
+
+		// Frame numbers in the logs start at 1
+		if index >= len(report.Frames) {
+			report.Frames = append(report.Frames, FrameMetrics{Frame: frame - 1})
+		}
+		set(&report.Frames[index], value)
+		index++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading metrics log: %w", err)
+	}
+	return nil
+}
+
+// parseVMAFLog reads the per-frame scores from a libvmaf JSON log
+func parseVMAFLog(path string, report *QualityReport) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read VMAF log: %w", err)
+	}
+
+	var log struct {
+		Frames []struct {
+			FrameNum int `json:"frameNum"`
+			Metrics  struct {
+				VMAF float64 `json:"vmaf"`
+			} `json:"metrics"`
+		} `json:"frames"`
+	}
+	if err := json.Unmarshal(data, &log); err != nil {
+		return fmt.Errorf("failed to parse VMAF log: %w", err)
+	}
+
+	for i, frame := range log.Frames {
+		if i >= len(report.Frames) {
+			report.Frames = append(report.Frames, FrameMetrics{Frame: frame.FrameNum})
+		}
+		score := frame.Metrics.VMAF
+		report.Frames[i].VMAF = &score
+	}
+	return nil
+}
+
+// summarize computes the mean, minimum and maximum of each metric
+func (r *QualityReport) summarize() {
+	var psnr, ssim, vmaf []float64
+	for _, frame := range r.Frames {
+		psnr = append(psnr, frame.PSNR)
+		ssim = append(ssim, frame.SSIM)
+		if frame.VMAF != nil {
+			vmaf = append(vmaf, *frame.VMAF)
+		}
+	}
+
+	r.PSNR = summarizeValues(psnr)
+	r.SSIM = summarizeValues(ssim)
+	if len(vmaf) > 0 {
+		summary := summarizeValues(vmaf)
+		r.VMAF = &summary
+	}
+}
+
+// summarizeValues computes the mean, minimum and maximum of a list of values
+func summarizeValues(values []float64) MetricSummary {
+	if len(values) == 0 {
+		return MetricSummary{}
+	}
+
+	summary := MetricSummary{Min: values[0], Max: values[0]}
+	total := 0.0
+	for _, v := range values {
+		total += v
+		summary.Min = math.Min(summary.Min, v)
+		summary.Max = math.Max(summary.Max, v)
+	}
+	summary.Mean = total / float64(len(values))
+	return summary
+}
@@ -0,0 +1,86 @@
+package ffmpeg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"videoup/internal/cleanup"
+)
+
+// ffmpegCommand creates an ffmpeg command that never waits for input and is
+// killed when ctx is cancelled.
+// -nostdin: the terminal belongs to the TUI
+// -y: outputs are temporary files or paths already chosen by the overwrite
+// policy, so ffmpeg must not ask before replacing them
+func ffmpegCommand(ctx context.Context, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "ffmpeg", append([]string{"-nostdin", "-y"}, args...)...)
+}
+
+// commandContext returns the context the commands run with
+func (o Options) commandContext() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
+}
+
+// PartialPath returns the temporary path an output is written to before it
+// is renamed into place. It is hidden and marked as partial, so a file left
+// behind by a crash is not mistaken for a finished output
+func PartialPath(outputPath string) string {
+	ext := filepath.Ext(outputPath)
+	name := strings.TrimSuffix(filepath.Base(outputPath), ext)
+	return filepath.Join(filepath.Dir(outputPath), "."+name+".partial"+ext)
+}
+
+// writeAtomically calls write with a temporary path and renames the result
+// to outputPath once it succeeds, so outputPath only ever holds a complete file
+func writeAtomically(outputPath string, write func(tempPath string) error) error {
+	tempPath := PartialPath(outputPath)
+
+	// Register the file for cleanup in case of errors or interruption
+	cleanup.RegisterDirectory(tempPath)
+	defer func() {
+		os.Remove(tempPath)
+		cleanup.RemoveDirectory(tempPath)
+	}()
+
+	if err := write(tempPath); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, outputPath); err != nil {
+		return fmt.Errorf("failed to move output into place: %w", err)
+	}
+	return nil
+}
+
+// metadataArgs returns the arguments that write tags to the output, in a
+// stable order
+// -metadata: global tag of the output file
+// -movflags +use_metadata_tags: QuickTime drops keys it does not know
+// unless they are written as metadata tags
+func metadataArgs(tags map[string]string, container Container) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, key := range keys {
+		args = append(args, "-metadata", key+"="+tags[key])
+	}
+	if container == ContainerMOV {
+		args = append(args, "-movflags", "+use_metadata_tags")
+	}
+	return args
+}
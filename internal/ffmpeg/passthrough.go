@@ -0,0 +1,190 @@
+package ffmpeg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+)
+
+// Container is the output container format
+type Container string
+
+const (
+	// ContainerMOV is a QuickTime container, best supported by Adobe products
+	ContainerMOV Container = "mov"
+	// ContainerMKV is a Matroska container, which can carry any subtitle
+	// format as well as font attachments
+	ContainerMKV Container = "mkv"
+)
+
+// Extension returns the file extension for the container, including the dot
+func (c Container) Extension() string {
+	return "." + string(c)
+}
+
+// StreamInfo describes a non-video stream of the source that may be carried
+// over into the output
+type StreamInfo struct {
+	Index    int    `json:"index"`
+	Type     string `json:"type"`
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	FileName string `json:"file_name,omitempty"`
+}
+
+// Describe returns a short human readable description of the stream
+func (s StreamInfo) Describe() string {
+	name := fmt.Sprintf("%s stream #%d (%s", s.Type, s.Index, s.Codec)
+	if s.FileName != "" {
+		name += ", " + s.FileName
+	}
+	if s.Language != "" {
+		name += ", " + s.Language
+	}
+	return name + ")"
+}
+
+// PassthroughPlan describes how subtitle, attachment and chapter data from
+// the source is carried into the output
+type PassthroughPlan struct {
+	// Args are the ffmpeg output options, assuming the source is input 1
+	Args []string
+	// Carried lists what is copied or converted into the output
+	Carried []string
+	// Dropped lists what the output container cannot hold
+	Dropped []string
+	// Number of subtitle and attachment streams in the output
+	Subtitles   int
+	Attachments int
+}
+
+// textSubtitleCodecs are subtitle formats that can be converted to text
+// formats supported by other containers
+var textSubtitleCodecs = map[string]bool{
+	"ass":      true,
+	"ssa":      true,
+	"subrip":   true,
+	"srt":      true,
+	"webvtt":   true,
+	"mov_text": true,
+	"text":     true,
+}
+
+// probeStreams reads the subtitle and attachment streams and the number of
+// chapters in the source
+func probeStreams(videoPath string) ([]StreamInfo, []StreamInfo, int, error) {
+	cmd := exec.Command(
+		"ffprobe",
+		"-v", "error",
+		"-show_entries", "stream=index,codec_type,codec_name:stream_tags=language,title,filename",
+		"-show_chapters",
+		"-of", "json",
+		videoPath,
+	)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("ffprobe command failed: %w", err)
+	}
+
+	var result struct {
+		Streams []struct {
+			Index     int               `json:"index"`
+			CodecType string            `json:"codec_type"`
+			CodecName string            `json:"codec_name"`
+			Tags      map[string]string `json:"tags"`
+		} `json:"streams"`
+		Chapters []json.RawMessage `json:"chapters"`
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	var subtitles, attachments []StreamInfo
+	for _, stream := range result.Streams {
+		info := StreamInfo{
+			Index:    stream.Index,
+			Type:     stream.CodecType,
+			Codec:    stream.CodecName,
+			Language: stream.Tags["language"],
+			Title:    stream.Tags["title"],
+			FileName: stream.Tags["filename"],
+		}
+		switch stream.CodecType {
+		case "subtitle":
+			subtitles = append(subtitles, info)
+		case "attachment":
+			attachments = append(attachments, info)
+		}
+	}
+
+	return subtitles, attachments, len(result.Chapters), nil
+}
+
+// PlanPassthrough decides which subtitles, attachments and chapters of the
+// source can be carried into the given container
+func PlanPassthrough(info *VideoInfo, container Container) PassthroughPlan {
+	var plan PassthroughPlan
+
+	outputIndex := 0
+	for _, sub := range info.Subtitles {
+		codec := ""
+		switch container {
+		case ContainerMKV:
+			// Matroska holds everything except the MP4 text format
+			codec = "copy"
+			if sub.Codec == "mov_text" {
+				codec = "srt"
+			}
+		default:
+			// QuickTime only holds mov_text, bitmap subtitles cannot be converted
+			if textSubtitleCodecs[sub.Codec] {
+				codec = "mov_text"
+			}
+		}
+
+		if codec == "" {
+			plan.Dropped = append(plan.Dropped, sub.Describe()+": not supported by "+string(container))
+			continue
+		}
+
+		plan.Args = append(plan.Args,
+			"-map", fmt.Sprintf("1:%d", sub.Index),
+			fmt.Sprintf("-c:s:%d", outputIndex), codec,
+		)
+		outputIndex++
+		plan.Subtitles++
+
+		if codec == "copy" {
+			plan.Carried = append(plan.Carried, sub.Describe())
+		} else if sub.Codec == "ass" || sub.Codec == "ssa" {
+			plan.Carried = append(plan.Carried, sub.Describe()+" converted to "+codec+", styling lost")
+		} else {
+			plan.Carried = append(plan.Carried, sub.Describe()+" converted to "+codec)
+		}
+	}
+
+	for _, attachment := range info.Attachments {
+		if container != ContainerMKV {
+			plan.Dropped = append(plan.Dropped, attachment.Describe()+": attachments not supported by "+string(container))
+			continue
+		}
+		plan.Args = append(plan.Args, "-map", fmt.Sprintf("1:%d", attachment.Index))
+		plan.Attachments++
+		plan.Carried = append(plan.Carried, attachment.Describe())
+	}
+	if container == ContainerMKV && len(info.Attachments) > 0 {
+		plan.Args = append(plan.Args, "-c:t", "copy")
+	}
+
+	// Both containers support chapters
+	if info.Chapters > 0 {
+		plan.Args = append(plan.Args, "-map_chapters", "1")
+		plan.Carried = append(plan.Carried, fmt.Sprintf("%d chapters", info.Chapters))
+	} else {
+		plan.Args = append(plan.Args, "-map_chapters", "-1")
+	}
+
+	return plan
+}
@@ -0,0 +1,254 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"videoup/internal/errs"
+)
+
+// PreFilter cleans up the source before the frames are extracted. None of
+// the filters change the number of frames, so ranges and scenes still line up
+type PreFilter struct {
+	// Deinterlacer: "yadif" or "bwdif" (empty for none)
+	Deinterlace string `json:"deinterlace,omitempty"`
+	// Denoiser: "hqdn3d" or "nlmeans" (empty for none), with a strength of
+	// "light", "medium" or "strong"
+	Denoise         string `json:"denoise,omitempty"`
+	DenoiseStrength string `json:"denoise_strength,omitempty"`
+	// Deblock smooths the block edges of heavily compressed sources
+	Deblock bool `json:"deblock,omitempty"`
+	// Crop in source pixels (zero width for none)
+	Crop Crop `json:"crop"`
+}
+
+// Crop is a rectangle of the source
+type Crop struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+}
+
+// preFilterPresets are the named pre-filter combinations
+var preFilterPresets = map[string]PreFilter{
+	"none":        {},
+	"clean":       {Denoise: "hqdn3d", DenoiseStrength: "light"},
+	"deinterlace": {Deinterlace: "bwdif"},
+	"dvd":         {Deinterlace: "bwdif", Deblock: true, Denoise: "hqdn3d", DenoiseStrength: "light"},
+	"vhs":         {Deinterlace: "bwdif", Denoise: "hqdn3d", DenoiseStrength: "strong"},
+	"grain":       {Denoise: "nlmeans", DenoiseStrength: "medium"},
+}
+
+// denoiseFilters are the options of each denoiser for each strength
+var denoiseFilters = map[string]map[string]string{
+	// luma_spatial:chroma_spatial:luma_tmp:chroma_tmp
+	"hqdn3d": {
+		"light":  "hqdn3d=2:1.5:3:2.25",
+		"medium": "hqdn3d=4:3:6:4.5",
+		"strong": "hqdn3d=8:6:12:9",
+	},
+	// s: denoising strength, much slower than hqdn3d but keeps more detail
+	"nlmeans": {
+		"light":  "nlmeans=s=1.5",
+		"medium": "nlmeans=s=3",
+		"strong": "nlmeans=s=6",
+	},
+}
+
+// PreFilterPresets returns the names of the pre-filter presets
+func PreFilterPresets() []string {
+	names := make([]string, 0, len(preFilterPresets))
+	for name := range preFilterPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParsePreFilter parses a comma separated list of presets and filters, later
+// entries adding to or replacing earlier ones, e.g.
+// "dvd,hqdn3d:strong,crop=1440:1080:240:0"
+func ParsePreFilter(value string) (PreFilter, error) {
+	var p PreFilter
+	for _, item := range strings.Split(value, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		name, arg, hasArg := strings.Cut(item, ":")
+		if strings.HasPrefix(item, "crop=") {
+			name = "crop"
+		}
+
+		switch {
+		case item == "":
+		case preFilterPresets[item] != PreFilter{} || item == "none":
+			p = p.merge(preFilterPresets[item])
+		case item == "yadif" || item == "bwdif":
+			p.Deinterlace = item
+		case denoiseFilters[name] != nil:
+			p.Denoise, p.DenoiseStrength = name, "medium"
+			if hasArg {
+				if _, ok := denoiseFilters[name][arg]; !ok {
+					return PreFilter{}, fmt.Errorf("invalid %s strength %q (expected light, medium or strong)", name, arg)
+				}
+				p.DenoiseStrength = arg
+			}
+		case item == "deblock":
+			p.Deblock = true
+		case name == "crop":
+			crop, err := parseCrop(strings.TrimPrefix(item, "crop="))
+			if err != nil {
+				return PreFilter{}, err
+			}
+			p.Crop = crop
+		default:
+			return PreFilter{}, fmt.Errorf("unknown pre-filter %q (expected a preset: %s, or yadif, bwdif, hqdn3d, nlmeans, deblock, crop=W:H:X:Y)",
+				item, strings.Join(PreFilterPresets(), ", "))
+		}
+	}
+	return p, nil
+}
+
+// merge returns p with the filters set in other added or replaced
+func (p PreFilter) merge(other PreFilter) PreFilter {
+	if other.Deinterlace != "" {
+		p.Deinterlace = other.Deinterlace
+	}
+	if other.Denoise != "" {
+		p.Denoise, p.DenoiseStrength = other.Denoise, other.DenoiseStrength
+	}
+	p.Deblock = p.Deblock || other.Deblock
+	if other.Crop.Width > 0 {
+		p.Crop = other.Crop
+	}
+	return p
+}
+
+// parseCrop parses a crop given as "WIDTH:HEIGHT:X:Y"
+func parseCrop(value string) (Crop, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 4 {
+		return Crop{}, fmt.Errorf("invalid crop %q (expected WIDTH:HEIGHT:X:Y)", value)
+	}
+	var numbers [4]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Crop{}, fmt.Errorf("invalid crop %q (expected WIDTH:HEIGHT:X:Y)", value)
+		}
+		numbers[i] = n
+	}
+	if numbers[0] == 0 || numbers[1] == 0 {
+		return Crop{}, fmt.Errorf("invalid crop %q: the size must not be zero", value)
+	}
+	return Crop{Width: numbers[0], Height: numbers[1], X: numbers[2], Y: numbers[3]}, nil
+}
+
+// String formats the pre-filter the way ParsePreFilter reads it, in the
+// order the filters run
+func (p PreFilter) String() string {
+	var items []string
+	if p.Deinterlace != "" {
+		items = append(items, p.Deinterlace)
+	}
+	if p.Crop.Width > 0 {
+		items = append(items, fmt.Sprintf("crop=%d:%d:%d:%d", p.Crop.Width, p.Crop.Height, p.Crop.X, p.Crop.Y))
+	}
+	if p.Deblock {
+		items = append(items, "deblock")
+	}
+	if p.Denoise != "" {
+		items = append(items, p.Denoise+":"+p.DenoiseStrength)
+	}
+	return strings.Join(items, ",")
+}
+
+// IsEmpty reports whether no pre-filter is set
+func (p PreFilter) IsEmpty() bool {
+	return p == PreFilter{}
+}
+
+// CropOnly returns the pre-filter with only its crop, to show the source
+// with the same framing but otherwise untouched
+func (p PreFilter) CropOnly() PreFilter {
+	return PreFilter{Crop: p.Crop}
+}
+
+// filter returns the filtergraph of the pre-filter, applied to the decoded
+// source before the conversion to RGB (empty for none)
+func (p PreFilter) filter() string {
+	var filters []string
+	// Deinterlace first, the other filters expect whole frames
+	// mode=send_frame: one frame per frame, not per field
+	switch p.Deinterlace {
+	case "yadif":
+		filters = append(filters, "yadif=mode=send_frame")
+	case "bwdif":
+		filters = append(filters, "bwdif=mode=send_frame")
+	}
+	if p.Crop.Width > 0 {
+		filters = append(filters, fmt.Sprintf("crop=%d:%d:%d:%d", p.Crop.Width, p.Crop.Height, p.Crop.X, p.Crop.Y))
+	}
+	// Deblock before denoising, the block edges are not noise
+	// filter=weak: only smooth edges that look like block boundaries
+	if p.Deblock {
+		filters = append(filters, "deblock=filter=weak:block=8")
+	}
+	if p.Denoise != "" {
+		filters = append(filters, denoiseFilters[p.Denoise][p.DenoiseStrength])
+	}
+	return strings.Join(filters, ",")
+}
+
+// SourceSize returns the size of the source frames after the pre-filter crop
+func SourceSize(info *VideoInfo, options Options) (int, int) {
+	if options.PreFilter.Crop.Width > 0 {
+		return options.PreFilter.Crop.Width, options.PreFilter.Crop.Height
+	}
+	return info.Width, info.Height
+}
+
+// ValidatePreFilter checks that the pre-filter crop fits inside the source
+func ValidatePreFilter(info *VideoInfo, options Options) error {
+	crop := options.PreFilter.Crop
+	if crop.Width == 0 {
+		return nil
+	}
+	if crop.X+crop.Width > info.Width || crop.Y+crop.Height > info.Height {
+		return fmt.Errorf("crop %dx%d at %d,%d does not fit inside the %dx%d source",
+			crop.Width, crop.Height, crop.X, crop.Y, info.Width, info.Height)
+	}
+	return nil
+}
+
+// RenderPreFilterStill writes the given source frame before (left) and after
+// (right) the pre-filter side by side to outputPath, both cropped alike
+func RenderPreFilterStill(videoPath string, info *VideoInfo, frame int, outputPath string, options Options) error {
+	beforeOptions := options
+	beforeOptions.PreFilter = options.PreFilter.CropOnly()
+	beforeFilter, _ := extractFilter(info, beforeOptions)
+	afterFilter, _ := extractFilter(info, options)
+
+	// -ss: seek to the frame
+	// -filter_complex: the source twice, converted to RGB with and without
+	//                  the pre-filter, next to each other
+	// -frames:v 1: a single frame
+	args := append(decoderArgs(info), seekArgs(frame, info.FrameRate)...)
+	args = append(args,
+		"-i", videoPath,
+		"-filter_complex", fmt.Sprintf("[0:v]split=2[a][b];[a]%s,format=rgb24[before];[b]%s,format=rgb24[after];[before][after]hstack=inputs=2",
+			beforeFilter, afterFilter),
+		"-pix_fmt", "rgb24",
+		"-frames:v", "1",
+		outputPath,
+	)
+	cmd := ffmpegCommand(options.commandContext(), args...)
+
+	// Only shown when the still fails
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errs.Extract(fmt.Errorf("ffmpeg failed to render the pre-filter preview: %w\n%s", err, output))
+	}
+	return nil
+}
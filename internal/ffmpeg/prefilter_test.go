@@ -0,0 +1,135 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParsePreFilter(t *testing.T) {
+	dvd := PreFilter{Deinterlace: "bwdif", Deblock: true, Denoise: "hqdn3d", DenoiseStrength: "light"}
+
+	valid := map[string]PreFilter{
+		"":                     {},
+		"none":                 {},
+		"dvd":                  dvd,
+		" BWDIF ":              {Deinterlace: "bwdif"},
+		"nlmeans":              {Denoise: "nlmeans", DenoiseStrength: "medium"},
+		"hqdn3d:strong":        {Denoise: "hqdn3d", DenoiseStrength: "strong"},
+		"deblock":              {Deblock: true},
+		"crop=1440:1080:240:0": {Crop: Crop{Width: 1440, Height: 1080, X: 240}},
+		// Later entries replace the preset's denoiser and add a crop
+		"dvd,hqdn3d:strong,crop=704:480:8:0": {Deinterlace: "bwdif", Deblock: true, Denoise: "hqdn3d",
+			DenoiseStrength: "strong", Crop: Crop{Width: 704, Height: 480, X: 8}},
+		// A second preset only replaces what it sets, deblocking stays on
+		"dvd,grain": {Deinterlace: "bwdif", Deblock: true, Denoise: "nlmeans", DenoiseStrength: "medium"},
+		"yadif,vhs": {Deinterlace: "bwdif", Denoise: "hqdn3d", DenoiseStrength: "strong"},
+		"vhs,yadif": {Deinterlace: "yadif", Denoise: "hqdn3d", DenoiseStrength: "strong"},
+		// none adds nothing, it does not clear what came before
+		"deblock,none": {Deblock: true},
+	}
+	for value, want := range valid {
+		got, err := ParsePreFilter(value)
+		if err != nil {
+			t.Errorf("ParsePreFilter(%q) error = %v", value, err)
+		} else if got != want {
+			t.Errorf("ParsePreFilter(%q) = %+v\nwant %+v", value, got, want)
+		}
+	}
+
+	invalid := []string{
+		"sharpen",
+		"hqdn3d:extreme",
+		"yadif:1",
+		"crop=1440:1080",
+		"crop=0:1080:0:0",
+		"crop=1440:1080:-1:0",
+		"crop=a:b:c:d",
+		"dvd,unsharp",
+	}
+	for _, value := range invalid {
+		if got, err := ParsePreFilter(value); err == nil {
+			t.Errorf("ParsePreFilter(%q) = %+v, want an error", value, got)
+		}
+	}
+}
+
+func TestPreFilterRoundTrip(t *testing.T) {
+	filters := []PreFilter{
+		{Deinterlace: "yadif", Deblock: true, Denoise: "nlmeans", DenoiseStrength: "strong", Crop: Crop{Width: 1440, Height: 1080, X: 240}},
+		{Crop: Crop{Width: 704, Height: 576, X: 8, Y: 0}},
+	}
+	for _, name := range PreFilterPresets() {
+		filters = append(filters, preFilterPresets[name])
+	}
+
+	// What String writes, ParsePreFilter reads back
+	for _, filter := range filters {
+		parsed, err := ParsePreFilter(filter.String())
+		if err != nil || parsed != filter {
+			t.Errorf("ParsePreFilter(%q) = %+v, %v, want %+v", filter.String(), parsed, err, filter)
+		}
+	}
+
+	full := PreFilter{Deinterlace: "yadif", Deblock: true, Denoise: "hqdn3d", DenoiseStrength: "light", Crop: Crop{Width: 1440, Height: 1080, X: 240}}
+	if got, want := full.String(), "yadif,crop=1440:1080:240:0,deblock,hqdn3d:light"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
+
+func TestPreFilterChain(t *testing.T) {
+	if got := (PreFilter{}).filter(); got != "" {
+		t.Errorf("filter() of no pre-filter = %q, want empty", got)
+	}
+
+	// Deinterlacing runs first and denoising last, whatever order they were given in
+	p, err := ParsePreFilter("nlmeans:light,crop=704:480:8:0,deblock,yadif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := strings.Split(p.filter(), ",")
+	want := []string{"yadif=", "crop=704:480:8:0", "deblock=", "nlmeans="}
+	if len(steps) != len(want) {
+		t.Fatalf("filter() = %q, want %d filters", p.filter(), len(want))
+	}
+	for i, prefix := range want {
+		if !strings.HasPrefix(steps[i], prefix) {
+			t.Errorf("filter step %d = %q, want %s...", i, steps[i], prefix)
+		}
+	}
+
+	if got := p.CropOnly().filter(); got != "crop=704:480:8:0" {
+		t.Errorf("CropOnly().filter() = %q, want only the crop", got)
+	}
+}
+
+func TestPreFilterCrop(t *testing.T) {
+	info := &VideoInfo{Width: 1920, Height: 1080}
+
+	options := Options{PreFilter: PreFilter{Crop: Crop{Width: 1440, Height: 1080, X: 240}}}
+	if err := ValidatePreFilter(info, options); err != nil {
+		t.Errorf("ValidatePreFilter() error = %v", err)
+	}
+	if width, height := SourceSize(info, options); width != 1440 || height != 1080 {
+		t.Errorf("SourceSize() = %dx%d, want the crop", width, height)
+	}
+
+	// The target is planned from the cropped 4:3 picture, so it is pillarboxed
+	options.TargetWidth, options.TargetHeight = 3840, 2160
+	if w, h, _, _ := TargetSize(info, options); w != 2880 || h != 2160 {
+		t.Errorf("TargetSize() of the crop = %dx%d, want 2880x2160", w, h)
+	}
+
+	if width, height := SourceSize(info, Options{}); width != 1920 || height != 1080 {
+		t.Errorf("SourceSize() without a crop = %dx%d, want the source size", width, height)
+	}
+
+	for _, crop := range []Crop{
+		{Width: 1920, Height: 1080, X: 1},
+		{Width: 2000, Height: 1080},
+		{Width: 1440, Height: 1000, X: 0, Y: 100},
+	} {
+		if err := ValidatePreFilter(info, Options{PreFilter: PreFilter{Crop: crop}}); err == nil {
+			t.Errorf("ValidatePreFilter(%+v) succeeded on a 1920x1080 source", crop)
+		}
+	}
+}
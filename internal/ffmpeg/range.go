@@ -0,0 +1,187 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ParseRangePoint converts a range start or end into a frame number. Values
+// can be a frame number ("1440"), seconds with an "s" suffix ("60.5s") or a
+// timecode ("HH:MM:SS.mmm", "MM:SS" or "HH:MM:SS:FF" with a frame count)
+func ParseRangePoint(value string, frameRate float64) (int, error) {
+	value = strings.TrimSpace(value)
+
+	// Plain frame number
+	if frame, err := strconv.Atoi(value); err == nil {
+		if frame < 0 {
+			return 0, fmt.Errorf("frame number must not be negative: %s", value)
+		}
+		return frame, nil
+	}
+
+	if frameRate <= 0 {
+		return 0, fmt.Errorf("cannot convert %q to a frame without a frame rate", value)
+	}
+
+	// Seconds
+	if strings.HasSuffix(value, "s") {
+		seconds, err := strconv.ParseFloat(strings.TrimSuffix(value, "s"), 64)
+		if err != nil || seconds < 0 {
+			return 0, fmt.Errorf("invalid time: %s", value)
+		}
+		return secondsToFrame(seconds, frameRate), nil
+	}
+
+	// Timecode
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return 0, fmt.Errorf("invalid timecode: %s", value)
+	}
+
+	// HH:MM:SS:FF has a trailing frame count
+	frames := 0
+	if len(parts) == 4 {
+		var err error
+		frames, err = strconv.Atoi(parts[3])
+		if err != nil || frames < 0 {
+			return 0, fmt.Errorf("invalid timecode: %s", value)
+		}
+		parts = parts[:3]
+	}
+
+	seconds := 0.0
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid timecode: %s", value)
+		}
+		seconds = seconds*60 + v
+	}
+
+	return secondsToFrame(seconds, frameRate) + frames, nil
+}
+
+// secondsToFrame returns the number of the frame shown at the given time
+func secondsToFrame(seconds, frameRate float64) int {
+	return int(math.Round(seconds * frameRate))
+}
+
+// ResolveRange returns the first frame and the frame after the last frame
+// selected by options.Start and options.End. Without a range, the whole
+// video is selected
+func ResolveRange(info *VideoInfo, options Options) (int, int, error) {
+	start, end := 0, info.TotalFrames
+
+	if options.Start != "" {
+		frame, err := ParseRangePoint(options.Start, info.FrameRate)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid start: %w", err)
+		}
+		start = frame
+	}
+
+	if options.End != "" {
+		frame, err := ParseRangePoint(options.End, info.FrameRate)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid end: %w", err)
+		}
+		end = frame
+	}
+
+	if info.TotalFrames > 0 && end > info.TotalFrames {
+		end = info.TotalFrames
+	}
+	if start >= end {
+		return 0, 0, fmt.Errorf("range start (frame %d) must be before its end (frame %d)", start, end)
+	}
+
+	return start, end, nil
+}
+
+// HasRange reports whether only part of the video is selected
+func (o Options) HasRange() bool {
+	return o.Start != "" || o.End != ""
+}
+
+// seekArgs returns the input options that seek to the given frame. The seek
+// lands half a frame early so timestamp rounding never skips the first frame
+func seekArgs(frame int, frameRate float64) []string {
+	if frame == 0 || frameRate <= 0 {
+		return nil
+	}
+	seconds := (float64(frame) - 0.5) / frameRate
+	return []string{"-ss", strconv.FormatFloat(seconds, 'f', 6, 64)}
+}
+
+// FrameSize returns the dimensions of the first frame in a frames directory
+func FrameSize(framesDir string) (int, int, error) {
+	files, err := filepath.Glob(filepath.Join(framesDir, "frame_*.png"))
+	if err != nil || len(files) == 0 {
+		return 0, 0, fmt.Errorf("no frames found in %s", framesDir)
+	}
+
+	file, err := os.Open(files[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open frame: %w", err)
+	}
+	defer file.Close()
+
+	config, err := png.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read frame size: %w", err)
+	}
+
+	return config.Width, config.Height, nil
+}
+
+// CountFrames returns the number of frames in a frames directory
+func CountFrames(framesDir string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(framesDir, "frame_*.png"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list frames: %w", err)
+	}
+	return len(files), nil
+}
+
+// spliceFilter builds a filtergraph that places the upscaled frames (input 0)
+// between the parts of the source (input 1) before and after the range,
+// scaled conventionally to the same size. The result is labelled [v]
+func spliceFilter(sourceFilter string, width, height, start, end, totalFrames int, outputFilter string) string {
+	scaled := fmt.Sprintf("%s,scale=%d:%d:flags=lanczos,setsar=1", sourceFilter, width, height)
+
+	var graph []string
+	var segments []string
+
+	hasBefore := start > 0
+	hasAfter := totalFrames == 0 || end < totalFrames
+
+	switch {
+	case hasBefore && hasAfter:
+		graph = append(graph, fmt.Sprintf("[1:v]%s,split=2[src0][src1]", scaled))
+	case hasBefore:
+		graph = append(graph, fmt.Sprintf("[1:v]%s[src0]", scaled))
+	case hasAfter:
+		graph = append(graph, fmt.Sprintf("[1:v]%s[src1]", scaled))
+	}
+
+	if hasBefore {
+		graph = append(graph, fmt.Sprintf("[src0]trim=end_frame=%d,setpts=PTS-STARTPTS[before]", start))
+		segments = append(segments, "[before]")
+	}
+	graph = append(graph, "[0:v]setsar=1[range]")
+	segments = append(segments, "[range]")
+	if hasAfter {
+		graph = append(graph, fmt.Sprintf("[src1]trim=start_frame=%d,setpts=PTS-STARTPTS[after]", end))
+		segments = append(segments, "[after]")
+	}
+
+	graph = append(graph, fmt.Sprintf("%sconcat=n=%d:v=1:a=0,%s[v]",
+		strings.Join(segments, ""), len(segments), outputFilter))
+
+	return strings.Join(graph, ";")
+}
@@ -0,0 +1,104 @@
+package ffmpeg
+
+import "testing"
+
+func TestParseRangePoint(t *testing.T) {
+	tests := []struct {
+		value     string
+		frameRate float64
+		want      int
+		wantErr   bool
+	}{
+		{value: "0", frameRate: 24, want: 0},
+		{value: "1440", frameRate: 24, want: 1440},
+		{value: " 12 ", frameRate: 0, want: 12},
+		{value: "60.5s", frameRate: 24, want: 1452},
+		{value: "0s", frameRate: 25, want: 0},
+		{value: "01:30", frameRate: 25, want: 2250},
+		{value: "00:01:30.5", frameRate: 24, want: 2172},
+		{value: "01:00:00", frameRate: 30, want: 108000},
+		{value: "00:00:10:12", frameRate: 24, want: 252},
+		// 23.976 fps rounds to the frame shown at that time
+		{value: "10s", frameRate: 24000.0 / 1001, want: 240},
+		{value: "-1", frameRate: 24, wantErr: true},
+		{value: "-2s", frameRate: 24, wantErr: true},
+		{value: "10s", frameRate: 0, wantErr: true},
+		{value: "abc", frameRate: 24, wantErr: true},
+		{value: "1:2:3:4:5", frameRate: 24, wantErr: true},
+		{value: "00:00:10:-1", frameRate: 24, wantErr: true},
+		{value: "00:xx:10", frameRate: 24, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseRangePoint(tt.value, tt.frameRate)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRangePoint(%q, %v) = %d, want an error", tt.value, tt.frameRate, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRangePoint(%q, %v) error = %v", tt.value, tt.frameRate, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRangePoint(%q, %v) = %d, want %d", tt.value, tt.frameRate, got, tt.want)
+		}
+	}
+}
+
+func TestResolveRange(t *testing.T) {
+	info := &VideoInfo{FrameRate: 24, TotalFrames: 2400}
+
+	tests := []struct {
+		name       string
+		start, end string
+		total      int
+		wantStart  int
+		wantEnd    int
+		wantErr    bool
+	}{
+		{name: "whole video", total: 2400, wantStart: 0, wantEnd: 2400},
+		{name: "start only", start: "10s", total: 2400, wantStart: 240, wantEnd: 2400},
+		{name: "end only", end: "480", total: 2400, wantStart: 0, wantEnd: 480},
+		{name: "start and end", start: "00:00:05", end: "00:00:10", total: 2400, wantStart: 120, wantEnd: 240},
+		{name: "end clipped to the video", start: "100", end: "5000", total: 2400, wantStart: 100, wantEnd: 2400},
+		{name: "unknown length keeps the end", start: "100", end: "5000", total: 0, wantStart: 100, wantEnd: 5000},
+		{name: "start after end", start: "500", end: "400", total: 2400, wantErr: true},
+		{name: "empty range", start: "400", end: "400", total: 2400, wantErr: true},
+		{name: "start past the video", start: "3000", total: 2400, wantErr: true},
+		{name: "invalid start", start: "soon", total: 2400, wantErr: true},
+		{name: "invalid end", end: "later", total: 2400, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := *info
+			info.TotalFrames = tt.total
+			start, end, err := ResolveRange(&info, Options{Start: tt.start, End: tt.end})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ResolveRange() = %d, %d, want an error", start, end)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolveRange() error = %v", err)
+			}
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("ResolveRange() = %d, %d, want %d, %d", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestSeekArgs(t *testing.T) {
+	if args := seekArgs(0, 24); args != nil {
+		t.Errorf("seekArgs(0) = %v, want none", args)
+	}
+	// Half a frame before frame 48 at 24 fps
+	args := seekArgs(48, 24)
+	if len(args) != 2 || args[0] != "-ss" || args[1] != "1.979167" {
+		t.Errorf("seekArgs(48, 24) = %v, want [-ss 1.979167]", args)
+	}
+}
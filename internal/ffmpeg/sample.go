@@ -0,0 +1,53 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"videoup/internal/errs"
+)
+
+// SampleFrames writes count frames spread evenly over the selected range of
+// a video to outputDir, scaled down to at most width pixels wide, and returns
+// their paths. The samples are 8-bit RGB
+func SampleFrames(videoPath string, info *VideoInfo, outputDir string, count, width int, options Options) ([]string, error) {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create sample directory: %w", err)
+	}
+
+	start, end, err := ResolveRange(info, options)
+	if err != nil {
+		return nil, err
+	}
+	filter, _ := extractFilter(info, options)
+
+	var paths []string
+	for i := 0; i < count; i++ {
+		// The middle of each of count equal parts, away from the very start
+		frame := start + int(float64(end-start)*(float64(i)+0.5)/float64(count))
+		path := filepath.Join(outputDir, fmt.Sprintf("sample_%02d.png", i+1))
+
+		// -ss: seek to the sample
+		// -vf: convert to RGB like the extracted frames, then scale down
+		// -frames:v 1: a single frame
+		args := append(decoderArgs(info), seekArgs(frame, info.FrameRate)...)
+		args = append(args,
+			"-i", videoPath,
+			"-vf", fmt.Sprintf("%s,scale='min(%d,iw)':-2", filter, width),
+			"-pix_fmt", "rgb24",
+			"-frames:v", "1",
+			path,
+		)
+		cmd := ffmpegCommand(options.commandContext(), args...)
+
+		// Only shown when the sample fails
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return nil, errs.Extract(fmt.Errorf("ffmpeg failed to sample frame %d: %w\n%s", frame, err, output))
+		}
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,153 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"videoup/internal/errs"
+)
+
+// sceneLogName is the file the scene scores are written to
+const sceneLogName = "scenes.log"
+
+// SceneCuts returns the source frame numbers in the selected range where a
+// new scene starts, judged by ffmpeg's scene score (0-1) against threshold.
+// The first frame of the range is not included
+func SceneCuts(videoPath string, info *VideoInfo, threshold float64, options Options) ([]int, error) {
+	if threshold <= 0 || threshold >= 1 {
+		return nil, fmt.Errorf("invalid scene threshold %g (expected between 0 and 1)", threshold)
+	}
+
+	start, end, err := ResolveRange(info, options)
+	if err != nil {
+		return nil, err
+	}
+
+	absVideo, err := filepath.Abs(videoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	// The log is written to the working directory, so the filter argument
+	// does not need escaping for the path
+	logDir, err := os.MkdirTemp("", "videoup_scenes_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scene log directory: %w", err)
+	}
+	defer os.RemoveAll(logDir)
+
+	// -ss/-frames:v: only the selected range, timestamps start at 0
+	// scale=320:-2: scene scores don't need full resolution, this is much faster
+	// select='gt(scene,T)': keep the frames that differ enough from the previous
+	// metadata=print: log the timestamp of each kept frame
+	// -f null: only the log is wanted
+	args := append(decoderArgs(info), seekArgs(start, info.FrameRate)...)
+	args = append(args, "-i", absVideo)
+	if options.HasRange() {
+		args = append(args, "-frames:v", strconv.Itoa(end-start))
+	}
+	args = append(args,
+		"-vf", fmt.Sprintf("scale=320:-2,select='gt(scene,%s)',metadata=print:file=%s",
+			strconv.FormatFloat(threshold, 'f', -1, 64), sceneLogName),
+		"-an", "-f", "null", "-",
+	)
+	cmd := ffmpegCommand(options.commandContext(), args...)
+	cmd.Dir = logDir
+
+	// Capture stdout and stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Run the command
+	if err := cmd.Run(); err != nil {
+		return nil, errs.Probe(fmt.Errorf("ffmpeg scene detection failed: %w", err))
+	}
+
+	cuts, err := parseSceneLog(filepath.Join(logDir, sceneLogName), info.FrameRate)
+	if err != nil {
+		return nil, err
+	}
+	for i := range cuts {
+		cuts[i] += start
+	}
+	return cuts, nil
+}
+
+// parseSceneLog reads the frames kept by the select filter from a
+// metadata=print log, as frame numbers from the start of the input
+func parseSceneLog(path string, frameRate float64) ([]int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		// No frame was selected
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open scene log: %w", err)
+	}
+	defer file.Close()
+
+	// Lines look like "frame:0    pts:1234    pts_time:51.468"
+	seen := map[int]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		for _, field := range strings.Fields(scanner.Text()) {
+			value, ok := strings.CutPrefix(field, "pts_time:")
+			if !ok {
+				continue
+			}
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid scene log line %q", scanner.Text())
+			}
+			if frame := secondsToFrame(seconds, frameRate); frame > 0 {
+				seen[frame] = true
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read scene log: %w", err)
+	}
+
+	cuts := make([]int, 0, len(seen))
+	for frame := range seen {
+		cuts = append(cuts, frame)
+	}
+	sort.Ints(cuts)
+	return cuts, nil
+}
+
+// ResizeFrames resamples count frames of inputDir, starting at frame file
+// number first, to width x height and writes them to outputDir under the
+// same names
+func ResizeFrames(inputDir, outputDir string, first, count, width, height int, filter string, options Options) error {
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	// -start_number: the frames don't start at 1
+	// -vf scale: resample to the common size of the upscaled frames
+	cmd := ffmpegCommand(options.commandContext(),
+		"-start_number", strconv.Itoa(first),
+		"-i", filepath.Join(inputDir, "frame_%04d.png"),
+		"-frames:v", strconv.Itoa(count),
+		"-vf", fmt.Sprintf("scale=%d:%d:flags=%s", width, height, filter),
+		"-start_number", strconv.Itoa(first),
+		filepath.Join(outputDir, "frame_%04d.png"),
+	)
+
+	// Capture stdout and stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	// Run the command
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg resize failed: %w", err)
+	}
+
+	return nil
+}
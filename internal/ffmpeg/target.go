@@ -0,0 +1,161 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// TargetMode controls how a video is fitted into a target resolution
+type TargetMode string
+
+const (
+	// TargetFit scales the video to fit inside the target and pads the rest
+	TargetFit TargetMode = "fit"
+	// TargetFill scales the video to cover the target and crops the overflow
+	TargetFill TargetMode = "fill"
+)
+
+// namedResolutions are the target resolutions that can be given by name
+var namedResolutions = map[string][2]int{
+	"uhd":   {3840, 2160},
+	"4k":    {3840, 2160},
+	"dci4k": {4096, 2160},
+	"8k":    {7680, 4320},
+	"2160p": {0, 2160},
+	"1440p": {0, 1440},
+	"1080p": {0, 1080},
+	"720p":  {0, 720},
+}
+
+// ParseResolution parses a target resolution given as "WIDTHxHEIGHT" or by
+// name ("4k", "uhd", "1440p", ...). A zero width or height means it follows
+// from the aspect ratio of the video
+func ParseResolution(value string) (int, int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if size, ok := namedResolutions[value]; ok {
+		return size[0], size[1], nil
+	}
+
+	w, h, ok := strings.Cut(value, "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid resolution %q (expected WIDTHxHEIGHT or a name such as 4k or 1440p)", value)
+	}
+	width, err1 := strconv.Atoi(w)
+	height, err2 := strconv.Atoi(h)
+	if err1 != nil || err2 != nil || width < 0 || height < 0 || (width == 0 && height == 0) {
+		return 0, 0, fmt.Errorf("invalid resolution %q", value)
+	}
+	return width, height, nil
+}
+
+// HasTarget reports whether the output is resampled to a target resolution
+func (o Options) HasTarget() bool {
+	return o.TargetWidth > 0 || o.TargetHeight > 0
+}
+
+// SampleAspect returns the sample (pixel) aspect ratio of the video, 1 for
+// square pixels or when it is unknown
+func (info *VideoInfo) SampleAspect() float64 {
+	num, den, ok := strings.Cut(info.SampleAspectRatio, ":")
+	if !ok {
+		return 1
+	}
+	n, _ := strconv.ParseFloat(num, 64)
+	d, _ := strconv.ParseFloat(den, 64)
+	if n <= 0 || d <= 0 {
+		return 1
+	}
+	return n / d
+}
+
+// TargetSize returns the size of the picture inside the target and the size
+// of the output frame, based on the display aspect ratio of the source. For
+// fit the picture is smaller than the frame (padded), for fill larger (cropped)
+func TargetSize(info *VideoInfo, options Options) (int, int, int, int) {
+	width, height := SourceSize(info, options)
+	displayWidth := float64(width) * info.SampleAspect()
+	displayHeight := float64(height)
+
+	frameWidth, frameHeight := options.TargetWidth, options.TargetHeight
+	switch {
+	case frameWidth == 0:
+		frameWidth = evenSize(displayWidth * float64(frameHeight) / displayHeight)
+	case frameHeight == 0:
+		frameHeight = evenSize(displayHeight * float64(frameWidth) / displayWidth)
+	}
+
+	factorX := float64(frameWidth) / displayWidth
+	factorY := float64(frameHeight) / displayHeight
+	// The picture must fit inside the frame to be padded, and cover it to be
+	// cropped, also when the target is odd
+	if options.TargetMode == TargetFill {
+		factor := math.Max(factorX, factorY)
+		return evenSizeUp(displayWidth * factor), evenSizeUp(displayHeight * factor), frameWidth, frameHeight
+	}
+	factor := math.Min(factorX, factorY)
+	return evenSizeDown(displayWidth * factor), evenSizeDown(displayHeight * factor), frameWidth, frameHeight
+}
+
+// TargetFactor returns how much the source has to be enlarged to reach the
+// target, measured in source pixels so non-square pixels are accounted for
+func TargetFactor(info *VideoInfo, options Options) float64 {
+	pictureWidth, pictureHeight, _, _ := TargetSize(info, options)
+	width, height := SourceSize(info, options)
+	return math.Max(float64(pictureWidth)/float64(width), float64(pictureHeight)/float64(height))
+}
+
+// targetFilter returns the filter that resamples the upscaled frames to the
+// exact target resolution with square pixels
+func targetFilter(info *VideoInfo, options Options) string {
+	pictureWidth, pictureHeight, frameWidth, frameHeight := TargetSize(info, options)
+
+	flags := options.TargetFilter
+	if flags == "" {
+		flags = "lanczos"
+	}
+	filter := fmt.Sprintf("scale=%d:%d:flags=%s,setsar=1", pictureWidth, pictureHeight, flags)
+
+	switch {
+	case pictureWidth == frameWidth && pictureHeight == frameHeight:
+		return filter
+	case options.TargetMode == TargetFill:
+		return filter + fmt.Sprintf(",crop=%d:%d", frameWidth, frameHeight)
+	default:
+		color := options.PadColor
+		if color == "" {
+			color = "black"
+		}
+		return filter + fmt.Sprintf(",pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=%s", frameWidth, frameHeight, color)
+	}
+}
+
+// evenSize rounds a dimension to the nearest even number, as required by
+// 4:2:0 and 4:2:2 formats
+func evenSize(size float64) int {
+	even := int(math.Round(size/2)) * 2
+	if even < 2 {
+		return 2
+	}
+	return even
+}
+
+// evenSizeDown rounds a dimension down to an even number
+func evenSizeDown(size float64) int {
+	// The tolerance keeps 1919.9999 from becoming 1918
+	even := int(math.Floor(size/2+1e-6)) * 2
+	if even < 2 {
+		return 2
+	}
+	return even
+}
+
+// evenSizeUp rounds a dimension up to an even number
+func evenSizeUp(size float64) int {
+	even := int(math.Ceil(size/2-1e-6)) * 2
+	if even < 2 {
+		return 2
+	}
+	return even
+}
@@ -0,0 +1,80 @@
+package ffmpeg
+
+import "testing"
+
+func TestParseResolution(t *testing.T) {
+	valid := map[string][2]int{
+		"4k":        {3840, 2160},
+		" UHD ":     {3840, 2160},
+		"dci4k":     {4096, 2160},
+		"1440p":     {0, 1440},
+		"1920x1080": {1920, 1080},
+		"0x720":     {0, 720},
+		"2560x0":    {2560, 0},
+	}
+	for value, want := range valid {
+		width, height, err := ParseResolution(value)
+		if err != nil {
+			t.Errorf("ParseResolution(%q) error = %v", value, err)
+			continue
+		}
+		if width != want[0] || height != want[1] {
+			t.Errorf("ParseResolution(%q) = %dx%d, want %dx%d", value, width, height, want[0], want[1])
+		}
+	}
+
+	for _, value := range []string{"", "1080", "0x0", "-1x720", "x720", "widexhigh", "5k"} {
+		if _, _, err := ParseResolution(value); err == nil {
+			t.Errorf("ParseResolution(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestTargetSize(t *testing.T) {
+	hd := &VideoInfo{Width: 1920, Height: 1080}
+	fourByThree := &VideoInfo{Width: 1440, Height: 1080}
+	scope := &VideoInfo{Width: 1920, Height: 800}
+	// NTSC DVD, 720x480 stored with 32:27 pixels for a 16:9 picture
+	dvd := &VideoInfo{Width: 720, Height: 480, SampleAspectRatio: "32:27"}
+
+	tests := []struct {
+		name    string
+		info    *VideoInfo
+		width   int
+		height  int
+		mode    TargetMode
+		picture [2]int
+		frame   [2]int
+	}{
+		{"same aspect", hd, 3840, 2160, TargetFit, [2]int{3840, 2160}, [2]int{3840, 2160}},
+		{"pillarbox", fourByThree, 3840, 2160, TargetFit, [2]int{2880, 2160}, [2]int{3840, 2160}},
+		{"fill crops the height", fourByThree, 3840, 2160, TargetFill, [2]int{3840, 2880}, [2]int{3840, 2160}},
+		{"letterbox", scope, 1920, 1080, TargetFit, [2]int{1920, 800}, [2]int{1920, 1080}},
+		{"fill crops the width", scope, 1920, 1080, TargetFill, [2]int{2592, 1080}, [2]int{1920, 1080}},
+		{"mode defaults to fit", scope, 1920, 1080, "", [2]int{1920, 800}, [2]int{1920, 1080}},
+		{"width from the height", hd, 0, 1440, TargetFit, [2]int{2560, 1440}, [2]int{2560, 1440}},
+		{"height from the width", hd, 1280, 0, TargetFit, [2]int{1280, 720}, [2]int{1280, 720}},
+		{"anamorphic source", dvd, 0, 1080, TargetFit, [2]int{1920, 1080}, [2]int{1920, 1080}},
+		{"anamorphic into 4:3", dvd, 1440, 1080, TargetFit, [2]int{1440, 810}, [2]int{1440, 1080}},
+		// Odd targets: the picture is rounded to even sizes inwards for fit,
+		// so the pad never goes negative, and outwards for fill so the crop
+		// never runs out of picture
+		{"odd target fit", hd, 1441, 1081, TargetFit, [2]int{1440, 810}, [2]int{1441, 1081}},
+		{"odd target fill", hd, 1441, 1081, TargetFill, [2]int{1922, 1082}, [2]int{1441, 1081}},
+		{"odd height fit", fourByThree, 1921, 1081, TargetFit, [2]int{1440, 1080}, [2]int{1921, 1081}},
+		{"odd fill of a wide source", scope, 1279, 721, TargetFill, [2]int{1732, 722}, [2]int{1279, 721}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := Options{TargetWidth: tt.width, TargetHeight: tt.height, TargetMode: tt.mode}
+			pictureWidth, pictureHeight, frameWidth, frameHeight := TargetSize(tt.info, options)
+			if pictureWidth != tt.picture[0] || pictureHeight != tt.picture[1] {
+				t.Errorf("picture = %dx%d, want %dx%d", pictureWidth, pictureHeight, tt.picture[0], tt.picture[1])
+			}
+			if frameWidth != tt.frame[0] || frameHeight != tt.frame[1] {
+				t.Errorf("frame = %dx%d, want %dx%d", frameWidth, frameHeight, tt.frame[0], tt.frame[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"videoup/internal/app"
+	"videoup/internal/upscaler"
+)
+
+// JobStatus is where a job is in its life
+type JobStatus string
+
+const (
+	StatusQueued    JobStatus = "queued"
+	StatusRunning   JobStatus = "running"
+	StatusDone      JobStatus = "done"
+	StatusSkipped   JobStatus = "skipped"
+	StatusFailed    JobStatus = "failed"
+	StatusCancelled JobStatus = "cancelled"
+)
+
+// Finished reports whether a job will not change any more
+func (s JobStatus) Finished() bool {
+	switch s {
+	case StatusDone, StatusSkipped, StatusFailed, StatusCancelled:
+		return true
+	}
+	return false
+}
+
+// Job is an upscale submitted to the server
+type Job struct {
+	ID    string `json:"id"`
+	Video string `json:"video"`
+	// Options as command line flags without the dash, e.g. {"scale": "8"}
+	Options  map[string]string  `json:"options,omitempty"`
+	Status   JobStatus          `json:"status"`
+	Stage    string             `json:"stage,omitempty"`
+	Progress *upscaler.Progress `json:"progress,omitempty"`
+	Result   *app.JobResult     `json:"result,omitempty"`
+	Error    string             `json:"error,omitempty"`
+	Remedy   string             `json:"remedy,omitempty"`
+	ExitCode int                `json:"exit_code"`
+	Created  time.Time          `json:"created"`
+	Started  *time.Time         `json:"started,omitempty"`
+	Finished *time.Time         `json:"finished,omitempty"`
+}
+
+// jobStore holds the jobs and saves them to a file after every change, so
+// they survive a restart of the server
+type jobStore struct {
+	mu     sync.Mutex
+	path   string
+	jobs   map[string]*Job
+	nextID int
+}
+
+// openJobStore loads the jobs saved at path. Jobs that were running when the
+// server stopped are queued again, they start over from the beginning
+func openJobStore(path string) (*jobStore, error) {
+	store := &jobStore{path: path, jobs: map[string]*Job{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jobs: %w", err)
+	}
+
+	var jobs []*Job
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to parse jobs in %s: %w", path, err)
+	}
+	for _, job := range jobs {
+		if job.Status == StatusRunning {
+			job.Status = StatusQueued
+			job.Stage = ""
+			job.Progress = nil
+			job.Started = nil
+		}
+		store.jobs[job.ID] = job
+		var n int
+		if _, err := fmt.Sscanf(job.ID, "job-%d", &n); err == nil && n > store.nextID {
+			store.nextID = n
+		}
+	}
+	return store, store.save()
+}
+
+// add stores a new queued job and returns a copy of it
+func (s *jobStore) add(video string, options map[string]string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	job := &Job{
+		ID:      fmt.Sprintf("job-%d", s.nextID),
+		Video:   video,
+		Options: options,
+		Status:  StatusQueued,
+		Created: time.Now(),
+	}
+	s.jobs[job.ID] = job
+	return *job, s.save()
+}
+
+// get returns a copy of a job
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// list returns copies of all jobs, oldest first
+func (s *jobStore) list() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sorted()
+}
+
+// claim marks the oldest queued job as running and returns it
+func (s *jobStore) claim() (Job, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, queued := range s.sorted() {
+		if queued.Status != StatusQueued {
+			continue
+		}
+		job := s.jobs[queued.ID]
+		started := time.Now()
+		job.Status = StatusRunning
+		job.Started = &started
+		return *job, true, s.save()
+	}
+	return Job{}, false, nil
+}
+
+// update changes a job and saves the jobs. Progress updates are frequent and
+// only kept in memory, save is false for them
+func (s *jobStore) update(id string, save bool, change func(job *Job)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return fmt.Errorf("unknown job %q", id)
+	}
+	change(job)
+	if !save {
+		return nil
+	}
+	return s.save()
+}
+
+// sorted returns copies of all jobs ordered by creation. The caller holds mu
+func (s *jobStore) sorted() []Job {
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].Created.Equal(jobs[j].Created) {
+			return jobs[i].Created.Before(jobs[j].Created)
+		}
+		return jobs[i].ID < jobs[j].ID
+	})
+	return jobs
+}
+
+// save writes the jobs to a temporary file and renames it into place, so a
+// crash never leaves a truncated file. The caller holds mu
+func (s *jobStore) save() error {
+	jobs := s.sorted()
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal jobs: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create jobs directory: %w", err)
+	}
+	tempPath := s.path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write jobs: %w", err)
+	}
+	if err := os.Rename(tempPath, s.path); err != nil {
+		return fmt.Errorf("failed to write jobs: %w", err)
+	}
+	return nil
+}
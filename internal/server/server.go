@@ -0,0 +1,334 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"videoup/internal/app"
+	"videoup/internal/errs"
+	"videoup/internal/upscaler"
+)
+
+// Options contains options for the server
+type Options struct {
+	// Address to listen on, e.g. "127.0.0.1:8089"
+	Addr string
+	// File the jobs are saved to
+	JobsPath string
+	// ParseOptions turns the options of a submitted job into job options. It
+	// is the same parsing as the command line flags
+	ParseOptions func(flags map[string]string) (app.Options, error)
+}
+
+// Server runs submitted jobs one at a time and reports on them over HTTP
+type Server struct {
+	options Options
+	store   *jobStore
+	// wake is signalled when a job is queued
+	wake chan struct{}
+
+	mu sync.Mutex
+	// cancel stops the running job
+	cancel    context.CancelFunc
+	runningID string
+}
+
+// submitRequest is the body of POST /jobs
+type submitRequest struct {
+	Video   string         `json:"video"`
+	Options map[string]any `json:"options"`
+}
+
+// New creates a server with the jobs saved in options.JobsPath
+func New(options Options) (*Server, error) {
+	store, err := openJobStore(options.JobsPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		options: options,
+		store:   store,
+		wake:    make(chan struct{}, 1),
+	}, nil
+}
+
+// Handler returns the HTTP API:
+//
+//	POST /jobs              submit {"video": "/path", "options": {"scale": 8}}
+//	GET  /jobs              list jobs
+//	GET  /jobs/{id}         status and progress of a job
+//	POST /jobs/{id}/cancel  cancel a queued or running job
+//	GET  /jobs/{id}/report  job report of a finished job
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.handleSubmit)
+	mux.HandleFunc("GET /jobs", s.handleList)
+	mux.HandleFunc("GET /jobs/{id}", s.handleGet)
+	mux.HandleFunc("POST /jobs/{id}/cancel", s.handleCancel)
+	mux.HandleFunc("GET /jobs/{id}/report", s.handleReport)
+	return mux
+}
+
+// Run serves the API and runs the queued jobs until ctx is cancelled. A job
+// interrupted by the shutdown is queued again
+func (s *Server) Run(ctx context.Context) error {
+	httpServer := &http.Server{Addr: s.options.Addr, Handler: s.Handler()}
+
+	// Listen before any job starts, so a port in use fails right away
+	listener, err := net.Listen("tcp", s.options.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.options.Addr, err)
+	}
+
+	// Jobs only run while the API is up, so the runner also stops when
+	// serving fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var runner sync.WaitGroup
+	runner.Add(1)
+	go func() {
+		defer runner.Done()
+		s.runJobs(ctx)
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Listening on http://%s\n", s.options.Addr)
+	err = httpServer.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	cancel()
+	runner.Wait()
+	return err
+}
+
+// runJobs runs queued jobs, oldest first, until ctx is cancelled
+func (s *Server) runJobs(ctx context.Context) {
+	for ctx.Err() == nil {
+		// The job is claimed under mu, so a cancel either finds it queued or
+		// finds it running with its cancel function set
+		jobCtx, cancel := context.WithCancel(ctx)
+		s.mu.Lock()
+		job, ok, err := s.store.claim()
+		if ok {
+			s.cancel, s.runningID = cancel, job.ID
+		}
+		s.mu.Unlock()
+		if err != nil {
+			fmt.Printf("Warning: failed to save jobs: %v\n", err)
+		}
+		if !ok {
+			cancel()
+			select {
+			case <-s.wake:
+			case <-ctx.Done():
+			}
+			continue
+		}
+
+		s.runJob(ctx, jobCtx, job)
+
+		s.mu.Lock()
+		s.cancel, s.runningID = nil, ""
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+// runJob runs one job and records its outcome. jobCtx is cancelled to
+// cancel the job, ctx when the server shuts down
+func (s *Server) runJob(ctx, jobCtx context.Context, job Job) {
+	fmt.Printf("Starting %s: %s\n", job.ID, job.Video)
+
+	var result *app.JobResult
+	options, err := s.options.ParseOptions(job.Options)
+	if err == nil {
+		options.Upscaler.OnProgress = func(progress upscaler.Progress) {
+			s.store.update(job.ID, false, func(job *Job) {
+				job.Progress = &progress
+			})
+		}
+		result, err = app.RunJob(jobCtx, job.Video, options, func(stage string) {
+			s.store.update(job.ID, true, func(job *Job) {
+				job.Stage = stage
+			})
+		})
+	}
+
+	// Leave a job stopped by the shutdown for the next start
+	if ctx.Err() != nil {
+		s.store.update(job.ID, true, func(job *Job) {
+			job.Status = StatusQueued
+			job.Stage = ""
+			job.Progress = nil
+			job.Started = nil
+		})
+		fmt.Printf("Stopped %s, it will run again on the next start\n", job.ID)
+		return
+	}
+
+	finished := time.Now()
+	s.store.update(job.ID, true, func(job *Job) {
+		job.Finished = &finished
+		job.Result = result
+		job.ExitCode = errs.ExitCode(err)
+		switch {
+		case err == nil && result.Skipped:
+			job.Status = StatusSkipped
+		case err == nil:
+			job.Status = StatusDone
+		case errors.Is(err, errs.ErrCancelled):
+			job.Status = StatusCancelled
+		default:
+			job.Status = StatusFailed
+			job.Error = err.Error()
+			job.Remedy = errs.Remedy(err)
+		}
+	})
+	if err != nil {
+		fmt.Printf("Finished %s: %v\n", job.ID, err)
+	} else {
+		fmt.Printf("Finished %s: %s\n", job.ID, result.Output)
+	}
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var request submitRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if request.Video == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("missing video"))
+		return
+	}
+	video, err := filepath.Abs(request.Video)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := os.Stat(video); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("video not found: %s", video))
+		return
+	}
+
+	// Options are checked now, so a typo fails the request instead of the job
+	flags := map[string]string{}
+	for name, value := range request.Options {
+		flags[name] = fmt.Sprint(value)
+	}
+	if _, err := s.options.ParseOptions(flags); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := s.store.add(video, flags)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// Wake the runner if it is waiting for a job
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	writeJSON(w, http.StatusCreated, job)
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.store.list())
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.store.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown job %q", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, ok := s.store.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown job %q", id))
+		return
+	}
+
+	if job.Status.Finished() {
+		writeError(w, http.StatusConflict, fmt.Errorf("job %s is already %s", id, job.Status))
+		return
+	}
+
+	s.mu.Lock()
+	if s.runningID == id {
+		// The runner records the cancel once the job has stopped
+		s.cancel()
+	} else {
+		finished := time.Now()
+		s.store.update(id, true, func(job *Job) {
+			if job.Status == StatusQueued {
+				job.Status = StatusCancelled
+				job.ExitCode = errs.ExitCancelled
+				job.Finished = &finished
+			}
+		})
+	}
+	s.mu.Unlock()
+
+	job, _ = s.store.get(id)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	job, ok := s.store.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown job %q", id))
+		return
+	}
+	if job.Result == nil || job.Result.JobReport == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %s has no report (status %s)", id, job.Status))
+		return
+	}
+
+	data, err := os.ReadFile(job.Result.JobReport)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("failed to read job report: %w", err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// writeJSON writes v as the JSON response
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// writeError writes an error as {"error": "..."}
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
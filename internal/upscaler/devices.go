@@ -0,0 +1,128 @@
+package upscaler
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// smokeTestSize is the width and height of the frame used by SmokeTest
+const smokeTestSize = 64
+
+// deviceLine matches the lines realesrgan prints for each Vulkan device it
+// finds, e.g. "[0 NVIDIA GeForce RTX 3080]  queueC=2[8]  queueG=0[16]"
+var deviceLine = regexp.MustCompile(`^\[(\d+) (.+?)\]\s+queueC=`)
+
+// Device is a Vulkan device found by realesrgan
+type Device struct {
+	ID   int
+	Name string
+}
+
+// SmokeTestResult holds the outcome of upscaling one frame
+type SmokeTestResult struct {
+	// Devices realesrgan enumerated
+	Devices []Device
+	// Raw realesrgan output
+	Output string
+}
+
+// SmokeTest upscales a small generated frame with the given model and scale
+// on a device and returns the devices realesrgan enumerated while doing so
+func SmokeTest(model string, scale, gpu int) (*SmokeTestResult, error) {
+	exePath, err := getRealesrganPath()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "videoup_doctor_")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	inputFile := filepath.Join(dir, "input.png")
+	outputFile := filepath.Join(dir, "output.png")
+	if err := writeTestFrame(inputFile); err != nil {
+		return nil, err
+	}
+
+	output, err := exec.Command(exePath,
+		"-i", inputFile,
+		"-o", outputFile,
+		"-n", model,
+		"-s", strconv.Itoa(scale),
+		"-g", strconv.Itoa(gpu),
+	).CombinedOutput()
+	result := &SmokeTestResult{Devices: parseDevices(string(output)), Output: string(output)}
+	if err != nil {
+		return result, fmt.Errorf("realesrgan failed: %w", err)
+	}
+	if isOutOfMemory(result.Output) {
+		return result, fmt.Errorf("realesrgan ran out of GPU memory on a %dx%d frame", smokeTestSize, smokeTestSize)
+	}
+
+	width, height, err := imageSize(outputFile)
+	if err != nil {
+		return result, fmt.Errorf("realesrgan did not write a valid frame: %w", err)
+	}
+	if width != smokeTestSize*scale || height != smokeTestSize*scale {
+		return result, fmt.Errorf("expected a %dx%d frame, got %dx%d",
+			smokeTestSize*scale, smokeTestSize*scale, width, height)
+	}
+
+	return result, nil
+}
+
+// writeTestFrame writes a small gradient image to upscale
+func writeTestFrame(path string) error {
+	frame := image.NewRGBA(image.Rect(0, 0, smokeTestSize, smokeTestSize))
+	for y := 0; y < smokeTestSize; y++ {
+		for x := 0; x < smokeTestSize; x++ {
+			frame.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create test frame: %w", err)
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, frame); err != nil {
+		return fmt.Errorf("failed to write test frame: %w", err)
+	}
+	return nil
+}
+
+// parseDevices reads the Vulkan devices from realesrgan output. Each device
+// is printed on several lines, only the first one is used
+func parseDevices(output string) []Device {
+	var devices []Device
+	seen := map[int]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		match := deviceLine.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		id, _ := strconv.Atoi(match[1])
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		devices = append(devices, Device{ID: id, Name: match[2]})
+	}
+	return devices
+}
+
+// RealesrganPath returns the path of the realesrgan executable
+func RealesrganPath() (string, error) {
+	return getRealesrganPath()
+}
@@ -0,0 +1,312 @@
+package upscaler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// maxDeviceFailures is the number of frames in a row a device may fail
+// before it is excluded from the rest of the job
+const maxDeviceFailures = 3
+
+// maxFrameAttempts is the number of times a frame is tried, on any device,
+// before it is reported as failed
+const maxFrameAttempts = 3
+
+// GPUDevice is a Vulkan device used for upscaling
+type GPUDevice struct {
+	// Vulkan device ID (-1 for CPU)
+	ID int `json:"id"`
+	// Share of the parallel frames processed on this device
+	Weight int `json:"weight"`
+}
+
+// DeviceProgress holds the progress of one device
+type DeviceProgress struct {
+	ID       int  `json:"id"`
+	Workers  int  `json:"workers"`
+	Done     int  `json:"done"`
+	Failed   int  `json:"failed"`
+	Excluded bool `json:"excluded"`
+}
+
+// Progress holds the progress of an upscale
+type Progress struct {
+	Pass    int              `json:"pass"`
+	Passes  int              `json:"passes"`
+	Frames  int              `json:"frames"`
+	Done    int              `json:"done"`
+	Devices []DeviceProgress `json:"devices"`
+}
+
+// ParseGPUs parses a comma separated list of device IDs, each optionally
+// followed by ":weight" (e.g. "0:2,1")
+func ParseGPUs(value string) ([]GPUDevice, error) {
+	var devices []GPUDevice
+	seen := map[int]bool{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		id, weight, hasWeight := strings.Cut(item, ":")
+		device := GPUDevice{Weight: 1}
+		var err error
+		if device.ID, err = strconv.Atoi(id); err != nil || device.ID < -1 {
+			return nil, fmt.Errorf("invalid GPU %q", item)
+		}
+		if hasWeight {
+			if device.Weight, err = strconv.Atoi(weight); err != nil || device.Weight < 1 {
+				return nil, fmt.Errorf("invalid GPU weight %q", item)
+			}
+		}
+		if seen[device.ID] {
+			return nil, fmt.Errorf("GPU %d listed twice", device.ID)
+		}
+		seen[device.ID] = true
+		devices = append(devices, device)
+	}
+
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("no GPUs given")
+	}
+	return devices, nil
+}
+
+// FormatGPUs formats devices in the form accepted by ParseGPUs
+func FormatGPUs(devices []GPUDevice) string {
+	var items []string
+	for _, device := range devices {
+		if device.Weight > 1 {
+			items = append(items, fmt.Sprintf("%d:%d", device.ID, device.Weight))
+		} else {
+			items = append(items, strconv.Itoa(device.ID))
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// deviceState is the state of a device during an upscale
+type deviceState struct {
+	GPUDevice
+	progress DeviceProgress
+	// Frames failed in a row
+	failures int
+}
+
+// scheduler spreads frames over per-device worker pools. All workers take
+// frames from one queue, so faster devices end up processing more of them
+type scheduler struct {
+	mu         sync.Mutex
+	ctx        context.Context
+	devices    []*deviceState
+	progress   Progress
+	onProgress func(Progress)
+}
+
+// newScheduler creates a scheduler for the devices in the options
+func newScheduler(options UpscalerOptions, passes int) *scheduler {
+	devices := options.GPUs
+	if len(devices) == 0 {
+		devices = []GPUDevice{{ID: 0, Weight: 1}}
+	}
+
+	s := &scheduler{ctx: options.commandContext(), onProgress: options.OnProgress}
+	s.progress.Passes = passes
+	for _, device := range devices {
+		if device.Weight < 1 {
+			device.Weight = 1
+		}
+		s.devices = append(s.devices, &deviceState{
+			GPUDevice: device,
+			progress:  DeviceProgress{ID: device.ID},
+		})
+	}
+	return s
+}
+
+// run processes files on every device that has not been excluded, running up
+// to parallel frames at once split by device weight. It returns the frames
+// that could not be processed
+func (s *scheduler) run(pass int, files []string, parallel int, process func(file string, device int) error) ([]string, error) {
+	s.mu.Lock()
+	var active []*deviceState
+	totalWeight := 0
+	for _, device := range s.devices {
+		if !device.progress.Excluded {
+			active = append(active, device)
+			totalWeight += device.Weight
+		}
+	}
+	if len(active) == 0 {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("all GPUs were excluded after repeated failures")
+	}
+	s.progress.Pass = pass
+	s.progress.Frames = len(files)
+	s.progress.Done = 0
+	for _, device := range s.devices {
+		device.progress.Workers = 0
+		if !device.progress.Excluded {
+			device.progress.Workers = max(1, (parallel*device.Weight+totalWeight/2)/totalWeight)
+		}
+	}
+	s.mu.Unlock()
+	s.report()
+
+	// Each frame is in the queue at most once, so it never blocks
+	queue := make(chan string, len(files))
+	for _, file := range files {
+		queue <- file
+	}
+
+	var failed []string
+	attempts := map[string]int{}
+	remaining := len(files)
+	finished := make(chan struct{})
+	if remaining == 0 {
+		close(finished)
+	}
+
+	// settle records the final outcome of a frame
+	settle := func(file string, ok bool) {
+		if !ok {
+			failed = append(failed, file)
+		}
+		s.progress.Done++
+		remaining--
+		if remaining == 0 {
+			close(finished)
+		}
+	}
+
+	var workers sync.WaitGroup
+	for _, device := range active {
+		for w := 0; w < device.progress.Workers; w++ {
+			workers.Add(1)
+			go func(device *deviceState) {
+				defer workers.Done()
+				for {
+					var file string
+					select {
+					case file = <-queue:
+					case <-finished:
+						return
+					case <-s.ctx.Done():
+						return
+					}
+
+					// Hand the frame back if the device was excluded meanwhile
+					s.mu.Lock()
+					if device.progress.Excluded {
+						if s.activeDevices() > 0 {
+							queue <- file
+						} else {
+							settle(file, false)
+						}
+						s.mu.Unlock()
+						return
+					}
+					s.mu.Unlock()
+
+					err := process(file, device.ID)
+					// Frames interrupted by a cancel are not failures
+					if s.ctx.Err() != nil {
+						return
+					}
+
+					s.mu.Lock()
+					if err == nil {
+						device.failures = 0
+						device.progress.Done++
+						settle(file, true)
+					} else {
+						device.failures++
+						device.progress.Failed++
+						attempts[file]++
+						if device.failures >= maxDeviceFailures && !device.progress.Excluded {
+							device.progress.Excluded = true
+							fmt.Printf("Excluding GPU %d after %d failed frames in a row\n", device.ID, device.failures)
+						}
+						if attempts[file] < maxFrameAttempts && s.activeDevices() > 0 {
+							queue <- file
+						} else {
+							settle(file, false)
+						}
+					}
+					excluded := device.progress.Excluded
+					s.mu.Unlock()
+					s.report()
+
+					if excluded {
+						s.mu.Lock()
+						// With no device left the queued frames can never be done
+						if s.activeDevices() == 0 {
+							for len(queue) > 0 {
+								settle(<-queue, false)
+							}
+						}
+						s.mu.Unlock()
+						return
+					}
+				}
+			}(device)
+		}
+	}
+
+	// Wait until every frame is settled or every device is gone
+	workers.Wait()
+	if err := s.ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.activeDevices() == 0 {
+		return failed, fmt.Errorf("all GPUs were excluded after repeated failures")
+	}
+	return failed, nil
+}
+
+// activeDevices returns the number of devices that have not been excluded.
+// The caller must hold s.mu
+func (s *scheduler) activeDevices() int {
+	count := 0
+	for _, device := range s.devices {
+		if !device.progress.Excluded {
+			count++
+		}
+	}
+	return count
+}
+
+// report passes the current progress to the progress callback
+func (s *scheduler) report() {
+	if s.onProgress == nil {
+		return
+	}
+	s.mu.Lock()
+	progress := s.progress
+	progress.Devices = nil
+	for _, device := range s.devices {
+		progress.Devices = append(progress.Devices, device.progress)
+	}
+	s.mu.Unlock()
+	s.onProgress(progress)
+}
+
+// describe lists the devices that have not been excluded
+func (s *scheduler) describe() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var devices []GPUDevice
+	for _, device := range s.devices {
+		if !device.progress.Excluded {
+			devices = append(devices, device.GPUDevice)
+		}
+	}
+	return FormatGPUs(devices)
+}
@@ -0,0 +1,162 @@
+package upscaler
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestParseGPUs(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []GPUDevice
+	}{
+		{"0", []GPUDevice{{ID: 0, Weight: 1}}},
+		{"0:2,1", []GPUDevice{{ID: 0, Weight: 2}, {ID: 1, Weight: 1}}},
+		{" 1 , 0:3 ,", []GPUDevice{{ID: 1, Weight: 1}, {ID: 0, Weight: 3}}},
+		{"-1", []GPUDevice{{ID: -1, Weight: 1}}},
+	}
+	for _, tt := range tests {
+		got, err := ParseGPUs(tt.value)
+		if err != nil {
+			t.Errorf("ParseGPUs(%q) error = %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseGPUs(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+		// Formatting and parsing again gives the same devices
+		if again, err := ParseGPUs(FormatGPUs(got)); err != nil || !reflect.DeepEqual(again, got) {
+			t.Errorf("ParseGPUs(FormatGPUs(%v)) = %v, %v", got, again, err)
+		}
+	}
+
+	for _, value := range []string{"", ",", "gpu0", "-2", "0:0", "0:x", "0,0", "1,0:2,1"} {
+		if got, err := ParseGPUs(value); err == nil {
+			t.Errorf("ParseGPUs(%q) = %v, want an error", value, got)
+		}
+	}
+}
+
+func TestFormatGPUs(t *testing.T) {
+	devices := []GPUDevice{{ID: 0, Weight: 2}, {ID: 1, Weight: 1}, {ID: -1}}
+	if got := FormatGPUs(devices); got != "0:2,1,-1" {
+		t.Errorf("FormatGPUs() = %q, want %q", got, "0:2,1,-1")
+	}
+}
+
+// frameNames returns n frame file names
+func frameNames(n int) []string {
+	files := make([]string, n)
+	for i := range files {
+		files[i] = fmt.Sprintf("frame_%04d.png", i+1)
+	}
+	return files
+}
+
+func TestSchedulerExcludesFailingDevice(t *testing.T) {
+	s := newScheduler(UpscalerOptions{GPUs: []GPUDevice{{ID: 0, Weight: 1}, {ID: 1, Weight: 1}}}, 1)
+
+	// GPU 0 holds its first frame until GPU 1 has failed enough frames in a
+	// row to be excluded, so the exclusion does not depend on timing
+	var mu sync.Mutex
+	done := map[int][]string{}
+	failures := 0
+	excluded := make(chan struct{})
+	failed, err := s.run(1, frameNames(20), 2, func(file string, device int) error {
+		if device == 1 {
+			mu.Lock()
+			defer mu.Unlock()
+			if failures++; failures == maxDeviceFailures {
+				close(excluded)
+			}
+			return errors.New("vkQueueSubmit failed")
+		}
+		<-excluded
+		mu.Lock()
+		done[device] = append(done[device], file)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if len(failed) != 0 {
+		t.Errorf("run() failed frames %v, want none", failed)
+	}
+
+	// Every frame ends up on the healthy device, including the ones GPU 1 gave back
+	sort.Strings(done[0])
+	if !reflect.DeepEqual(done[0], frameNames(20)) {
+		t.Errorf("GPU 0 processed %d frames, want all 20", len(done[0]))
+	}
+	if got := s.describe(); got != "0" {
+		t.Errorf("devices left = %q, want %q", got, "0")
+	}
+	if device := s.devices[1].progress; !device.Excluded || device.Failed != maxDeviceFailures {
+		t.Errorf("GPU 1 progress = %+v, want excluded after %d failures", device, maxDeviceFailures)
+	}
+
+	// The excluded device stays out of later passes
+	var devices []int
+	if _, err := s.run(2, frameNames(4), 2, func(file string, device int) error {
+		mu.Lock()
+		devices = append(devices, device)
+		mu.Unlock()
+		return nil
+	}); err != nil {
+		t.Fatalf("second run() error = %v", err)
+	}
+	for _, device := range devices {
+		if device != 0 {
+			t.Fatalf("second pass used GPU %d", device)
+		}
+	}
+}
+
+func TestSchedulerAllDevicesExcluded(t *testing.T) {
+	s := newScheduler(UpscalerOptions{GPUs: []GPUDevice{{ID: 0, Weight: 1}}}, 1)
+
+	failed, err := s.run(1, frameNames(10), 1, func(string, int) error {
+		return errors.New("device lost")
+	})
+	if err == nil {
+		t.Fatal("run() succeeded with every device failing")
+	}
+	if len(failed) != 10 {
+		t.Errorf("run() failed %d frames, want 10", len(failed))
+	}
+	if _, err := s.run(2, frameNames(1), 1, func(string, int) error { return nil }); err == nil {
+		t.Error("run() after every device was excluded succeeded")
+	}
+}
+
+func TestSchedulerGivesUpOnFrame(t *testing.T) {
+	// A frame that fails on every attempt is reported without excluding the
+	// devices, as long as other frames keep succeeding in between
+	s := newScheduler(UpscalerOptions{GPUs: []GPUDevice{{ID: 0, Weight: 1}}}, 1)
+
+	var mu sync.Mutex
+	attempts := 0
+	failed, err := s.run(1, frameNames(5), 1, func(file string, device int) error {
+		if file != "frame_0003.png" {
+			return nil
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		return errors.New("decode failed")
+	})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if !reflect.DeepEqual(failed, []string{"frame_0003.png"}) {
+		t.Errorf("run() failed frames = %v, want [frame_0003.png]", failed)
+	}
+	if attempts != maxFrameAttempts {
+		t.Errorf("frame attempted %d times, want %d", attempts, maxFrameAttempts)
+	}
+}
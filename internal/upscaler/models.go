@@ -0,0 +1,117 @@
+package upscaler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ModelScale is a model together with one of the scales it supports
+type ModelScale struct {
+	Model string `json:"model"`
+	Scale int    `json:"scale"`
+}
+
+// String returns the model and scale as "model x4"
+func (m ModelScale) String() string {
+	return fmt.Sprintf("%s x%d", m.Model, m.Scale)
+}
+
+// NativeScales returns the scales a model was trained for. The animevideov3
+// models ship one network per scale, the x4plus models only upscale 4x
+func NativeScales(model string) []int {
+	if strings.HasSuffix(model, "animevideov3") {
+		return []int{2, 3, 4}
+	}
+	return []int{4}
+}
+
+// modelFiles returns the base name of the model files used for a scale
+func modelFiles(model string, scale int) string {
+	if strings.HasSuffix(model, "animevideov3") {
+		return fmt.Sprintf("%s-x%d", model, scale)
+	}
+	return model
+}
+
+// modelDir returns the directory holding the models next to the realesrgan executable
+func modelDir() (string, error) {
+	exePath, err := getRealesrganPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(exePath), "models"), nil
+}
+
+// IsModelInstalled checks if the .param and .bin files of a model are present
+// for the given scale
+func IsModelInstalled(model string, scale int) bool {
+	dir, err := modelDir()
+	if err != nil {
+		return false
+	}
+	base := filepath.Join(dir, modelFiles(model, scale))
+	for _, ext := range []string{".param", ".bin"} {
+		if _, err := os.Stat(base + ext); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// InstalledModelScales returns every installed model with each of its native scales
+func InstalledModelScales() []ModelScale {
+	var installed []ModelScale
+	for _, model := range GetAvailableModels() {
+		for _, scale := range NativeScales(model) {
+			if IsModelInstalled(model, scale) {
+				installed = append(installed, ModelScale{Model: model, Scale: scale})
+			}
+		}
+	}
+	return installed
+}
+
+// ModelHashes returns the SHA-256 of the .param and .bin files used by the
+// passes, keyed by file name
+func ModelHashes(passes []ModelScale) (map[string]string, error) {
+	dir, err := modelDir()
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := map[string]string{}
+	for _, pass := range passes {
+		for _, ext := range []string{".param", ".bin"} {
+			name := modelFiles(pass.Model, pass.Scale) + ext
+			if _, ok := hashes[name]; ok {
+				continue
+			}
+			hash, err := FileHash(filepath.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			hashes[name] = hash
+		}
+	}
+	return hashes, nil
+}
+
+// FileHash returns the SHA-256 of a file as a hex string
+func FileHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
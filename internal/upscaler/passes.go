@@ -0,0 +1,120 @@
+package upscaler
+
+import (
+	"fmt"
+	"image/png"
+	"os"
+	"sort"
+)
+
+// maxScale is the largest scale offered for multi-pass upscaling. Beyond it
+// the intermediate frames get too large to be practical
+const maxScale = 16
+
+// largeFramePixels is the input size above which a pass uses smaller tiles
+const largeFramePixels = 3840 * 2160
+
+// largeFrameTile is the tile size used for large inputs (0 lets realesrgan
+// pick one from the available GPU memory, which can fail on very large frames)
+const largeFrameTile = 100
+
+// frameMemoryBudget bounds the memory used by the frames of one batch. Each
+// frame is held as 32-bit float RGB by realesrgan, input and output
+const frameMemoryBudget = 4 << 30
+
+// PlanPasses splits options.Scale into passes the models support natively.
+// The first pass uses options.Model, later passes use options.IntermediateModels
+// in order (the last one is reused) or options.Model when none are given.
+// Each pass uses the largest native scale that still divides what is left
+func PlanPasses(options UpscalerOptions) ([]ModelScale, error) {
+	if options.Scale < 2 {
+		return nil, fmt.Errorf("invalid scale %d", options.Scale)
+	}
+
+	var passes []ModelScale
+	remaining := options.Scale
+	for remaining > 1 {
+		model := passModel(options, len(passes))
+
+		scales := append([]int(nil), NativeScales(model)...)
+		sort.Sort(sort.Reverse(sort.IntSlice(scales)))
+
+		scale := 0
+		for _, s := range scales {
+			if remaining%s == 0 {
+				scale = s
+				break
+			}
+		}
+		if scale == 0 {
+			return nil, fmt.Errorf("cannot reach %dx: %s has no native scale for the remaining %dx (set an intermediate model such as realesr-animevideov3)",
+				options.Scale, model, remaining)
+		}
+
+		passes = append(passes, ModelScale{Model: model, Scale: scale})
+		remaining /= scale
+	}
+
+	return passes, nil
+}
+
+// passModel returns the model used for a pass
+func passModel(options UpscalerOptions, pass int) string {
+	if pass == 0 || len(options.IntermediateModels) == 0 {
+		return options.Model
+	}
+	if pass-1 < len(options.IntermediateModels) {
+		return options.IntermediateModels[pass-1]
+	}
+	return options.IntermediateModels[len(options.IntermediateModels)-1]
+}
+
+// SupportedScales returns the scales that can be reached with the models in
+// the options, in one or more passes
+func SupportedScales(options UpscalerOptions) []int {
+	var scales []int
+	for scale := 2; scale <= maxScale; scale++ {
+		options.Scale = scale
+		if _, err := PlanPasses(options); err == nil {
+			scales = append(scales, scale)
+		}
+	}
+	return scales
+}
+
+// passTileSize returns the tile size for a pass over frames of the given size
+func passTileSize(tileSize, width, height int) int {
+	if tileSize == 0 && width*height > largeFramePixels {
+		return largeFrameTile
+	}
+	return tileSize
+}
+
+// passBatchSize lowers the batch size of a pass so the frames it holds fit in
+// frameMemoryBudget
+func passBatchSize(batchSize, width, height, scale int) int {
+	perFrame := int64(width) * int64(height) * int64(1+scale*scale) * 12
+	limit := int(frameMemoryBudget / perFrame)
+	if limit < 1 {
+		limit = 1
+	}
+	if batchSize > limit {
+		return limit
+	}
+	return batchSize
+}
+
+// imageSize returns the size of a PNG file
+func imageSize(path string) (int, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer file.Close()
+
+	config, err := png.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode image header: %w", err)
+	}
+	return config.Width, config.Height, nil
+}
@@ -0,0 +1,84 @@
+package upscaler
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	photoModel = "realesrgan-x4plus"
+	animeModel = "realesrgan-x4plus-anime"
+	videoModel = "realesr-animevideov3"
+)
+
+func TestPlanPasses(t *testing.T) {
+	tests := []struct {
+		name         string
+		model        string
+		intermediate []string
+		scale        int
+		want         []ModelScale
+	}{
+		{"native", photoModel, nil, 4, []ModelScale{{photoModel, 4}}},
+		{"two passes of the same model", photoModel, nil, 16, []ModelScale{{photoModel, 4}, {photoModel, 4}}},
+		{"largest scale that divides first", videoModel, nil, 6, []ModelScale{{videoModel, 3}, {videoModel, 2}}},
+		{"intermediate model", photoModel, []string{videoModel}, 8, []ModelScale{{photoModel, 4}, {videoModel, 2}}},
+		{"intermediates in order", photoModel, []string{animeModel, videoModel}, 32,
+			[]ModelScale{{photoModel, 4}, {animeModel, 4}, {videoModel, 2}}},
+		{"last intermediate reused", photoModel, []string{videoModel}, 64,
+			[]ModelScale{{photoModel, 4}, {videoModel, 4}, {videoModel, 4}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			passes, err := PlanPasses(UpscalerOptions{Model: tt.model, IntermediateModels: tt.intermediate, Scale: tt.scale})
+			if err != nil {
+				t.Fatalf("PlanPasses() error = %v", err)
+			}
+			if !reflect.DeepEqual(passes, tt.want) {
+				t.Errorf("PlanPasses() = %v, want %v", passes, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanPassesUnreachable(t *testing.T) {
+	for _, options := range []UpscalerOptions{
+		{Model: photoModel, Scale: 1},
+		{Model: photoModel, Scale: 2},
+		{Model: photoModel, Scale: 8},
+		// The first pass always uses the main model
+		{Model: photoModel, IntermediateModels: []string{videoModel}, Scale: 6},
+		{Model: videoModel, Scale: 10},
+	} {
+		if passes, err := PlanPasses(options); err == nil {
+			t.Errorf("PlanPasses(%s, %v, %dx) = %v, want an error", options.Model, options.IntermediateModels, options.Scale, passes)
+		}
+	}
+}
+
+func TestSupportedScales(t *testing.T) {
+	check := func(options UpscalerOptions, want []int) {
+		t.Helper()
+		if got := SupportedScales(options); !reflect.DeepEqual(got, want) {
+			t.Errorf("SupportedScales(%s, %v) = %v, want %v", options.Model, options.IntermediateModels, got, want)
+		}
+	}
+
+	check(UpscalerOptions{Model: photoModel}, []int{4, 16})
+	check(UpscalerOptions{Model: videoModel}, []int{2, 3, 4, 6, 8, 9, 12, 16})
+	check(UpscalerOptions{Model: photoModel, IntermediateModels: []string{videoModel}}, []int{4, 8, 12, 16})
+}
+
+func TestPassBatchSize(t *testing.T) {
+	// A 1080p frame at 4x takes about 420MB, so a 4GB budget fits 10
+	if got := passBatchSize(16, 1920, 1080, 4); got != 10 {
+		t.Errorf("passBatchSize(16, 1080p, 4x) = %d, want 10", got)
+	}
+	if got := passBatchSize(4, 1920, 1080, 4); got != 4 {
+		t.Errorf("passBatchSize(4, 1080p, 4x) = %d, want 4", got)
+	}
+	if got := passBatchSize(8, 7680, 4320, 4); got != 1 {
+		t.Errorf("passBatchSize(8, 8k, 4x) = %d, want 1", got)
+	}
+}
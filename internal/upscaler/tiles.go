@@ -0,0 +1,92 @@
+package upscaler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minTileSize is the smallest tile tried when retrying after running out of memory
+const minTileSize = 32
+
+// retryTileSize is the first tile size tried when the automatic one ran out of memory
+const retryTileSize = 128
+
+// outOfMemoryMarkers are messages realesrgan and Vulkan print when GPU memory runs out
+var outOfMemoryMarkers = []string{
+	"out of memory",
+	"vkallocatememory failed",
+	"vk_error_out_of_device_memory",
+	"vk_error_out_of_host_memory",
+}
+
+// ValidateThreads checks a realesrgan thread count given as "load:proc:save".
+// Empty means the realesrgan default
+func ValidateThreads(threads string) error {
+	if threads == "" {
+		return nil
+	}
+	parts := strings.Split(threads, ":")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid threads %q (expected load:proc:save, e.g. 1:2:2)", threads)
+	}
+	for _, part := range parts {
+		if n, err := strconv.Atoi(part); err != nil || n < 1 {
+			return fmt.Errorf("invalid threads %q (expected load:proc:save, e.g. 1:2:2)", threads)
+		}
+	}
+	return nil
+}
+
+// isOutOfMemory reports whether realesrgan output shows it ran out of memory
+func isOutOfMemory(output string) bool {
+	output = strings.ToLower(output)
+	for _, marker := range outOfMemoryMarkers {
+		if strings.Contains(output, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// tileSizer holds the tile size of a pass. When a frame runs out of memory
+// the tile size is lowered for every frame processed after it
+type tileSizer struct {
+	mu   sync.Mutex
+	size int
+}
+
+// current returns the tile size to use
+func (t *tileSizer) current() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// reduce lowers the tile size after a frame processed with failed ran out of
+// memory. It returns false when the tile size cannot go any lower
+func (t *tileSizer) reduce(failed int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Another frame may have lowered it already
+	if t.size != failed {
+		return true
+	}
+
+	next := failed / 2
+	if failed == 0 {
+		next = retryTileSize
+	}
+	if next < minTileSize {
+		if failed <= minTileSize {
+			return false
+		}
+		next = minTileSize
+	}
+
+	fmt.Printf("Out of GPU memory, retrying with tile size %d\n", next)
+	t.size = next
+	return true
+}
@@ -0,0 +1,62 @@
+package upscaler
+
+import "testing"
+
+func TestValidateThreads(t *testing.T) {
+	for _, threads := range []string{"", "1:2:2", "4:8:4"} {
+		if err := ValidateThreads(threads); err != nil {
+			t.Errorf("ValidateThreads(%q) error = %v", threads, err)
+		}
+	}
+	for _, threads := range []string{"2", "1:2", "1:2:2:2", "1::2", "0:2:2", "1:-2:2", "a:b:c", " 1:2:2"} {
+		if err := ValidateThreads(threads); err == nil {
+			t.Errorf("ValidateThreads(%q) succeeded, want an error", threads)
+		}
+	}
+}
+
+func TestIsOutOfMemory(t *testing.T) {
+	tests := map[string]bool{
+		"vkAllocateMemory failed":                               true,
+		"[0 NVIDIA GeForce] VK_ERROR_OUT_OF_DEVICE_MEMORY":      true,
+		"vkQueueSubmit failed -2 (vk_error_out_of_host_memory)": true,
+		"ncnn: Out Of Memory":                                   true,
+		"decode image frame_0001.png failed":                    false,
+		"":                                                      false,
+	}
+	for output, want := range tests {
+		if got := isOutOfMemory(output); got != want {
+			t.Errorf("isOutOfMemory(%q) = %v, want %v", output, got, want)
+		}
+	}
+}
+
+func TestTileSizerReduce(t *testing.T) {
+	// Starting from the automatic size, each failure halves the tile size
+	// down to the minimum and then gives up
+	sizer := &tileSizer{}
+	for _, want := range []int{128, 64, 32} {
+		if !sizer.reduce(sizer.current()) {
+			t.Fatalf("reduce() gave up before reaching tile size %d", want)
+		}
+		if got := sizer.current(); got != want {
+			t.Fatalf("tile size = %d, want %d", got, want)
+		}
+	}
+	if sizer.reduce(sizer.current()) {
+		t.Errorf("reduce() at the minimum tile size = true, want false")
+	}
+
+	// Sizes that do not halve onto the minimum stop at it first
+	sizer = &tileSizer{size: 48}
+	if !sizer.reduce(48) || sizer.current() != minTileSize {
+		t.Errorf("reduce(48) left tile size %d, want %d", sizer.current(), minTileSize)
+	}
+
+	// A frame that failed with a tile size another frame already lowered
+	// retries with the lower size instead of halving it again
+	sizer = &tileSizer{size: 64}
+	if !sizer.reduce(128) || sizer.current() != 64 {
+		t.Errorf("reduce(128) with tile size 64 left %d, want 64", sizer.current())
+	}
+}
@@ -1,26 +1,53 @@
 package upscaler
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"image/color"
+	"image/png"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
-	"sync"
+
+	"videoup/internal/errs"
 )
 
 // UpscalerOptions contains options for the upscaler
 type UpscalerOptions struct {
-	// Scale factor (2, 3, or 4)
+	// Scale factor. Scales above the native scale of the model are done in
+	// several passes (e.g. 8 = 4x then 2x)
 	Scale int
 	// Model to use (e.g., "realesrgan-x4plus", "realesrgan-x4plus-anime")
 	Model string
-	// Number of threads to use (0 for auto)
-	Threads int
-	// GPU ID to use (-1 for CPU)
-	GPUID int
-	// Batch size for processing (number of frames to process in parallel)
+	// Models for the passes after the first, the last one is reused for any
+	// further passes (empty to use Model for every pass)
+	IntermediateModels []string
+	// Tile size passed to realesrgan (0 for auto). Lowered automatically when
+	// the GPU runs out of memory
+	TileSize int
+	// Thread counts for loading, processing and saving as "load:proc:save"
+	// (empty for the realesrgan default)
+	Threads string
+	// GPUs to use, with their share of the frames (ID -1 for CPU)
+	GPUs []GPUDevice
+	// Batch size for processing (number of frames to process in parallel,
+	// across all GPUs)
 	BatchSize int
+	// Called whenever a frame is done (may be nil). It is called from the
+	// worker goroutines and must not block
+	OnProgress func(Progress) `json:"-"`
+	// Context stops the upscale when cancelled (nil for none)
+	Context context.Context `json:"-"`
+}
+
+// commandContext returns the context the upscale runs with
+func (o UpscalerOptions) commandContext() context.Context {
+	if o.Context == nil {
+		return context.Background()
+	}
+	return o.Context
 }
 
 // DefaultOptions returns default upscaler options
@@ -28,13 +55,14 @@ func DefaultOptions() UpscalerOptions {
 	return UpscalerOptions{
 		Scale:     4,
 		Model:     "realesrgan-x4plus-anime",
-		Threads:   0, // Auto
-		GPUID:     0, // First GPU
+		TileSize:  0,                               // Auto
+		GPUs:      []GPUDevice{{ID: 0, Weight: 1}}, // First GPU
 		BatchSize: 10,
 	}
 }
 
-// UpscaleFrames upscales all frames in the input directory and saves them to the output directory
+// UpscaleFrames upscales all frames in the input directory and saves them to the output directory.
+// Scales above the native scale of the model are reached with several passes
 func UpscaleFrames(inputDir, outputDir string, options UpscalerOptions) error {
 	// Get the path to the realesrgan executable
 	exePath, err := getRealesrganPath()
@@ -42,9 +70,9 @@ func UpscaleFrames(inputDir, outputDir string, options UpscalerOptions) error {
 		return err
 	}
 
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %w", err)
+	passes, err := PlanPasses(options)
+	if err != nil {
+		return err
 	}
 
 	// Get all PNG files in the input directory
@@ -57,64 +85,135 @@ func UpscaleFrames(inputDir, outputDir string, options UpscalerOptions) error {
 		return fmt.Errorf("no PNG files found in input directory: %s", inputDir)
 	}
 
-	// Process files in batches to avoid memory issues
+	width, height, err := imageSize(files[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Found %d frames to upscale\n", len(files))
+	fmt.Printf("Using model: %s with scale: %d (%d pass(es)), output %dx%d\n",
+		options.Model, options.Scale, len(passes), width*options.Scale, height*options.Scale)
+
+	// Devices excluded in one pass stay excluded in the next
+	devices := newScheduler(options, len(passes))
+
+	passInput := inputDir
+	for i, pass := range passes {
+		if err := options.commandContext().Err(); err != nil {
+			return err
+		}
+
+		// Intermediate passes write next to the output directory
+		passOutput := outputDir
+		if i < len(passes)-1 {
+			passOutput = fmt.Sprintf("%s_pass%d", outputDir, i+1)
+		}
+
+		fmt.Printf("Pass %d/%d: %s, %dx%d -> %dx%d\n", i+1, len(passes), pass,
+			width, height, width*pass.Scale, height*pass.Scale)
+
+		if err := upscalePass(exePath, passInput, passOutput, i+1, pass, width, height, devices, options); err != nil {
+			return err
+		}
+
+		// The previous intermediate frames are no longer needed
+		if i > 0 {
+			os.RemoveAll(passInput)
+		}
+		passInput = passOutput
+		width, height = width*pass.Scale, height*pass.Scale
+	}
+
+	return nil
+}
+
+// upscalePass runs one realesrgan pass over the frames in inputDir, which are
+// width x height, spread over the devices
+func upscalePass(exePath, inputDir, outputDir string, number int, pass ModelScale, width, height int, devices *scheduler, options UpscalerOptions) error {
+	// Create output directory if it doesn't exist
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(inputDir, "*.png"))
+	if err != nil {
+		return fmt.Errorf("failed to list input files: %w", err)
+	}
+
+	// Limit the number of frames processed at once to avoid memory issues
 	batchSize := options.BatchSize
 	if batchSize <= 0 {
 		batchSize = 10 // Default to 10 if invalid batch size
 	}
-	totalFiles := len(files)
+	// Large intermediate frames use smaller batches and tiles
+	batchSize = passBatchSize(batchSize, width, height, pass.Scale)
+	// Each device lowers its tile size on its own, as they may have
+	// different amounts of memory
+	tiles := map[int]*tileSizer{}
+	for _, device := range devices.devices {
+		tiles[device.ID] = &tileSizer{size: passTileSize(options.TileSize, width, height)}
+	}
 
-	fmt.Printf("Found %d frames to upscale\n", totalFiles)
-	fmt.Printf("Using model: %s with scale: %d\n", options.Model, options.Scale)
-	fmt.Printf("Processing in batches of %d files\n", batchSize)
+	fmt.Printf("Processing %d frames, %d at a time on GPU(s) %s\n", len(files), batchSize, devices.describe())
 
-	for i := 0; i < totalFiles; i += batchSize {
-		end := i + batchSize
-		if end > totalFiles {
-			end = totalFiles
-		}
+	failed, err := devices.run(number, files, batchSize, func(inputFile string, gpu int) error {
+		// Get the base filename
+		baseName := filepath.Base(inputFile)
+		outputFile := filepath.Join(outputDir, baseName)
 
-		batch := files[i:end]
-		fmt.Printf("Processing batch %d/%d (files %d-%d of %d)\n",
-			(i/batchSize)+1, (totalFiles+batchSize-1)/batchSize, i+1, end, totalFiles)
-
-		// Process batch
-		var wg sync.WaitGroup
-		for _, file := range batch {
-			wg.Add(1)
-			go func(inputFile string) {
-				defer wg.Done()
-
-				// Get the base filename
-				baseName := filepath.Base(inputFile)
-				outputFile := filepath.Join(outputDir, baseName)
-
-				// Prepare the command
-				cmd := exec.Command(
-					exePath,
-					"-i", inputFile,
-					"-o", outputFile,
-					"-n", options.Model,
-					"-s", fmt.Sprintf("%d", options.Scale),
-					"-t", fmt.Sprintf("%d", options.Threads),
-					"-g", fmt.Sprintf("%d", options.GPUID),
-				)
-
-				// Run the command
-				output, err := cmd.CombinedOutput()
-				if err != nil {
-					fmt.Printf("Error upscaling %s: %v\n%s\n", baseName, err, string(output))
-				}
-			}(file)
+		err := upscaleFrame(exePath, inputFile, outputFile, pass, gpu, tiles[gpu], options)
+		if err != nil {
+			fmt.Printf("Error upscaling %s on GPU %d: %v\n", baseName, gpu, err)
 		}
-
-		// Wait for all files in the batch to be processed
-		wg.Wait()
+		return err
+	})
+	// A cancelled upscale did not fail any frames
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if err != nil || len(failed) > 0 {
+		return &errs.UpscaleError{Failed: failed, Total: len(files), Err: err}
 	}
 
 	return nil
 }
 
+// upscaleFrame runs realesrgan on one frame, retrying with smaller tiles
+// when it runs out of GPU memory
+func upscaleFrame(exePath, inputFile, outputFile string, pass ModelScale, gpu int, tiles *tileSizer, options UpscalerOptions) error {
+	for {
+		tileSize := tiles.current()
+
+		// Prepare the command
+		args := []string{
+			"-i", inputFile,
+			"-o", outputFile,
+			"-n", pass.Model,
+			"-s", fmt.Sprintf("%d", pass.Scale),
+			"-t", fmt.Sprintf("%d", tileSize),
+			"-g", fmt.Sprintf("%d", gpu),
+		}
+		if options.Threads != "" {
+			args = append(args, "-j", options.Threads)
+		}
+		cmd := exec.CommandContext(options.commandContext(), exePath, args...)
+
+		// Run the command. realesrgan does not always fail when it runs out
+		// of memory, so its output is checked as well
+		output, err := cmd.CombinedOutput()
+		if isOutOfMemory(string(output)) {
+			if tiles.reduce(tileSize) {
+				continue
+			}
+			return fmt.Errorf("out of GPU memory even with tile size %d\n%s", tileSize, string(output))
+		}
+		if err != nil {
+			return fmt.Errorf("%w\n%s", err, string(output))
+		}
+		return nil
+	}
+}
+
 // getRealesrganPath returns the path to the realesrgan executable based on the OS
 func getRealesrganPath() (string, error) {
 	var exeName, dirName string
@@ -150,7 +249,11 @@ func getRealesrganPath() (string, error) {
 		return path, nil
 	}
 
-	return "", fmt.Errorf("%s not found in %s directory or PATH", exeName, dirName)
+	return "", &errs.DependencyError{
+		Name:     exeName,
+		Location: dirName + " or PATH",
+		Remedy:   fmt.Sprintf("Please make sure %s is in the %s directory", exeName, dirName),
+	}
 }
 
 // IsRealesrganInstalled checks if realesrgan is installed for the current OS
@@ -170,6 +273,34 @@ func GetAvailableModels() []string {
 	}
 }
 
+// HasAlphaChannel reports whether a PNG file has an alpha channel
+func HasAlphaChannel(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open image: %w", err)
+	}
+	defer file.Close()
+
+	config, err := png.DecodeConfig(file)
+	if err != nil {
+		return false, fmt.Errorf("failed to decode image header: %w", err)
+	}
+
+	switch config.ColorModel {
+	case color.NRGBAModel, color.NRGBA64Model, color.RGBAModel, color.RGBA64Model:
+		return true, nil
+	}
+	// Paletted images may carry transparency in their palette
+	if palette, ok := config.ColorModel.(color.Palette); ok {
+		for _, c := range palette {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
 // CreateUpscaledDir creates a directory for upscaled frames
 func CreateUpscaledDir(framesDir string) (string, error) {
 	// Create a directory named "upscaled" inside the frames directory
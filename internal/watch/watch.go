@@ -0,0 +1,211 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"videoup/internal/app"
+	"videoup/internal/errs"
+	"videoup/internal/filepicker"
+)
+
+// Subfolders of the watched directory
+const (
+	DoneDir   = "done"
+	FailedDir = "failed"
+	OutputDir = "output"
+)
+
+// Options contains options for watching a directory
+type Options struct {
+	// Directory to watch. Only files directly inside it are picked up
+	Dir string
+	// How often the directory is scanned
+	Interval time.Duration
+	// How long a file must keep the same size before it is picked up, so
+	// files still being copied are left alone
+	Settle time.Duration
+	// Options each video is upscaled with. Relative output directories are
+	// resolved against the watched directory, without one the outputs go to
+	// its output/ subfolder
+	Job app.Options
+}
+
+// DefaultOptions returns default watch options
+func DefaultOptions() Options {
+	return Options{
+		Interval: 5 * time.Second,
+		Settle:   10 * time.Second,
+		Job:      app.DefaultOptions(),
+	}
+}
+
+// fileState is what was seen of a file on the last scan
+type fileState struct {
+	size    int64
+	modTime time.Time
+	// When the size or modification time last changed
+	changed time.Time
+	// The file was processed but could not be moved away
+	stuck bool
+}
+
+// Watch upscales every video that appears in options.Dir until ctx is
+// cancelled. Each source is moved to done/ or failed/ once its job ends, so
+// a video left in the directory by a restart is picked up again
+func Watch(ctx context.Context, options Options) error {
+	// Outputs written next to the sources would be picked up as new videos
+	if options.Job.Output.Dir == "" {
+		options.Job.Output.Dir = OutputDir
+	}
+	for _, sub := range []string{DoneDir, FailedDir} {
+		if err := os.MkdirAll(filepath.Join(options.Dir, sub), 0755); err != nil {
+			return fmt.Errorf("failed to create %s directory: %w", sub, err)
+		}
+	}
+
+	fmt.Printf("Watching %s for videos\n", options.Dir)
+
+	seen := map[string]*fileState{}
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+	for {
+		ready, err := scan(options, seen, time.Now())
+		if err != nil {
+			return err
+		}
+		for _, path := range ready {
+			if ctx.Err() != nil {
+				break
+			}
+			if err := process(ctx, path, options); err != nil {
+				// Don't upscale it again unless the file is replaced
+				fmt.Printf("Warning: %v\n", err)
+				seen[path].stuck = true
+				continue
+			}
+			delete(seen, path)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan updates seen with the videos in the directory and returns the ones
+// that have not changed for options.Settle, in name order
+func scan(options Options, seen map[string]*fileState, now time.Time) ([]string, error) {
+	entries, err := os.ReadDir(options.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", options.Dir, err)
+	}
+
+	present := map[string]bool{}
+	var ready []string
+	for _, entry := range entries {
+		// Hidden files include the partial outputs of other jobs
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !filepicker.VideoFileFilter(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+
+		path := filepath.Join(options.Dir, name)
+		present[path] = true
+		state, ok := seen[path]
+		if !ok || state.size != info.Size() || !state.modTime.Equal(info.ModTime()) {
+			seen[path] = &fileState{size: info.Size(), modTime: info.ModTime(), changed: now}
+			continue
+		}
+		if !state.stuck && info.Size() > 0 && now.Sub(state.changed) >= options.Settle {
+			ready = append(ready, path)
+		}
+	}
+
+	// Forget files that went away
+	for path := range seen {
+		if !present[path] {
+			delete(seen, path)
+		}
+	}
+
+	sort.Strings(ready)
+	return ready, nil
+}
+
+// process runs one video through the pipeline and moves it to done/ or
+// failed/. A failed video gets a <name>.error.txt next to it. It only
+// returns an error if the video could not be moved
+func process(ctx context.Context, path string, options Options) error {
+	name := filepath.Base(path)
+	fmt.Printf("Upscaling %s\n", name)
+
+	result, err := app.RunJob(ctx, path, options.Job, func(stage string) {
+		fmt.Printf("%s: %s\n", name, stage)
+	})
+
+	// Leave a video interrupted by the shutdown for the next start
+	if ctx.Err() != nil {
+		fmt.Printf("Stopped %s, it will be upscaled again on the next start\n", name)
+		return nil
+	}
+
+	dir := DoneDir
+	switch {
+	case err != nil:
+		dir = FailedDir
+		fmt.Printf("Failed %s: %v\n", name, err)
+	case result.Skipped:
+		fmt.Printf("Skipped %s, the output already exists: %s\n", name, result.Output)
+	default:
+		fmt.Printf("Finished %s: %s\n", name, result.Output)
+	}
+	if err == nil && result.ReportError != "" {
+		fmt.Printf("Warning: job report of %s not written: %s\n", name, result.ReportError)
+	}
+
+	moved, moveErr := moveTo(path, filepath.Join(options.Dir, dir))
+	if moveErr != nil {
+		return moveErr
+	}
+	if err != nil {
+		message := fmt.Sprintf("%v\nExit code: %d\n", err, errs.ExitCode(err))
+		if remedy := errs.Remedy(err); remedy != "" {
+			message += remedy + "\n"
+		}
+		os.WriteFile(moved+".error.txt", []byte(message), 0644)
+	}
+	return nil
+}
+
+// moveTo moves a file into dir, adding a number to its name if dir already
+// holds a file with that name, and returns its new path
+func moveTo(path, dir string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(filepath.Base(path), ext)
+
+	target := filepath.Join(dir, base+ext)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(target); os.IsNotExist(err) {
+			break
+		}
+		target = filepath.Join(dir, fmt.Sprintf("%s_%d%s", base, i, ext))
+	}
+
+	if err := os.Rename(path, target); err != nil {
+		return "", fmt.Errorf("failed to move %s to %s: %w", path, dir, err)
+	}
+	return target, nil
+}
@@ -40,18 +40,19 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Run the videoup command with the original directory as an environment variable
+	// Run the videoup command with the original directory as an environment variable,
+	// forwarding any command line arguments
 	var cmd *exec.Cmd
 
 	// Create the command based on the operating system
 	switch runtime.GOOS {
 	case "windows":
-		cmd = exec.Command("cmd", "/c", filepath.Join("cmd", "videoup", "videoup.exe"))
+		cmd = exec.Command("cmd", append([]string{"/c", filepath.Join("cmd", "videoup", "videoup.exe")}, os.Args[1:]...)...)
 	case "darwin", "linux":
 		execPath := filepath.Join("cmd", "videoup", "videoup")
 		// Make sure the file is executable
 		os.Chmod(execPath, 0755)
-		cmd = exec.Command(execPath)
+		cmd = exec.Command(execPath, os.Args[1:]...)
 	default:
 		fmt.Println("Unsupported operating system:", runtime.GOOS)
 		os.Exit(1)